@@ -1,5 +1,7 @@
 package balance
 
+import "context"
+
 // Balance describes a concurrency-safe account that tracks a value,
 // transaction count, and the last update timestamp.
 type Balance interface {
@@ -13,11 +15,74 @@ type Balance interface {
 	// successful mutation.
 	LastUpdated() int64
 
-	// Add increases the account balance by amount. Implementations must treat
-	// negative values as undefined behavior.
+	// Snapshot returns the value, transaction count, and last update
+	// timestamp in a single call. Whether the three fields are read as one
+	// consistent unit depends on the implementation; see each package's
+	// documentation.
+	Snapshot() Snapshot
+
+	// Add increases the account balance by amount. Zero and negative
+	// amounts are ignored and leave both the value and the transaction
+	// count untouched. Add does not guard against int64 overflow; use
+	// CheckedBalance.AddChecked to have either case reported as an error.
 	Add(amount int64)
 
-	// Subtract decreases the balance by amount or returns an error if the
-	// resulting balance would fall below zero.
+	// Subtract decreases the balance by amount or returns an
+	// *InsufficientFundsError, matching ErrInsufficientFunds, if the
+	// resulting balance would fall below zero. Zero and negative amounts
+	// are rejected with ErrInvalidAmount.
 	Subtract(amount int64) error
 }
+
+// CheckedBalance is a Balance whose deposits can be rejected instead of
+// silently wrapping.
+type CheckedBalance interface {
+	Balance
+
+	// AddChecked increases the balance by amount. It leaves the account
+	// untouched and returns ErrInvalidAmount if amount is zero or negative,
+	// or ErrOverflow if the result would not fit in an int64.
+	AddChecked(amount int64) error
+}
+
+// AddChecked deposits amount into b, using b's AddChecked when b is a
+// CheckedBalance. Otherwise it rejects a zero or negative amount with
+// ErrInvalidAmount and falls back to Add, which cannot report an overflow.
+func AddChecked(b Balance, amount int64) error {
+	if checked, ok := b.(CheckedBalance); ok {
+		return checked.AddChecked(amount)
+	}
+	if amount <= 0 {
+		return ErrInvalidAmount
+	}
+
+	b.Add(amount)
+	return nil
+}
+
+// ContextBalance is a Balance whose mutations can be bounded by a context.
+// Both methods return ctx.Err() without changing the account if ctx is done
+// before the mutation is applied; a context that is already done never
+// mutates.
+type ContextBalance interface {
+	Balance
+
+	// AddContext behaves like CheckedBalance.AddChecked but gives up when
+	// ctx is done.
+	AddContext(ctx context.Context, amount int64) error
+
+	// SubtractContext behaves like Subtract but gives up when ctx is done.
+	SubtractContext(ctx context.Context, amount int64) error
+}
+
+// Snapshot is a point-in-time view of a Balance. Implementations that
+// guarantee consistency populate every field from the same state, so Value
+// always matches the TransactionCount and LastUpdated it was recorded with.
+type Snapshot struct {
+	// Value is the account balance.
+	Value int64
+	// TransactionCount is the number of mutations applied to reach Value.
+	TransactionCount int64
+	// LastUpdated is the timestamp (nanoseconds) of the latest mutation.
+	LastUpdated int64
+}
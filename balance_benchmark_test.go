@@ -1,58 +1,45 @@
-package balance
+package balance_test
 
 import (
+	"io"
 	"sync/atomic"
 	"testing"
 
-	atomicbugsfull "github.com/madflojo/atomics-v-rwmutex-examples/implementations/atomics/bugs/full"
-	atomicbugssimple "github.com/madflojo/atomics-v-rwmutex-examples/implementations/atomics/bugs/simple"
-	atomiccasfull "github.com/madflojo/atomics-v-rwmutex-examples/implementations/atomics/cas/full"
-	atomiccassimple "github.com/madflojo/atomics-v-rwmutex-examples/implementations/atomics/cas/simple"
-	mutexfull "github.com/madflojo/atomics-v-rwmutex-examples/implementations/mutex/full"
-	mutexsimple "github.com/madflojo/atomics-v-rwmutex-examples/implementations/mutex/simple"
-	rwmutexfull "github.com/madflojo/atomics-v-rwmutex-examples/implementations/rwmutex/full"
-	rwmutexsimple "github.com/madflojo/atomics-v-rwmutex-examples/implementations/rwmutex/simple"
+	balance "github.com/madflojo/atomics-v-rwmutex-examples"
+	"github.com/madflojo/atomics-v-rwmutex-examples/balancetest"
+	_ "github.com/madflojo/atomics-v-rwmutex-examples/implementations/all"
 )
 
-var benchmarkImplementations = []struct {
-	name string
-}{
-	{
-		name: "Atomic_Balance_bugs_simple",
-	},
-	{
-		name: "Atomic_Balance_bugs_full",
-	},
-	{
-		name: "Atomic_Balance_CAS_simple",
-	},
-	{
-		name: "Atomic_Balance_CAS_full",
-	},
-	{
-		name: "RWMutex_Balance_simple",
-	},
-	{
-		name: "RWMutex_Balance_full",
-	},
-	{
-		name: "Mutex_Balance_simple",
-	},
-	{
-		name: "Mutex_Balance_full",
-	},
-}
-
 // balanceSink ensures Balance() results are observed in read-only benchmarks.
 // Note: writing to a shared sink can add contention and slightly skew results,
 // but it prevents compiler elision and avoids data races in RunParallel.
 var balanceSink int64
 
 func BenchmarkBalanceAdd(b *testing.B) {
-	for _, impl := range benchmarkImplementations {
+	for _, impl := range balance.Implementations() {
+		impl := impl
+		b.Run(impl.Name, func(b *testing.B) {
+			account := newBenchmarkBalance(b, impl)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					account.Add(1)
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkBalanceAddManualClock repeats BenchmarkBalanceAdd with a manual
+// clock, leaving the cost of reading the system clock out of the numbers.
+func BenchmarkBalanceAddManualClock(b *testing.B) {
+	for _, impl := range balance.Implementations() {
 		impl := impl
-		b.Run(impl.name, func(b *testing.B) {
-			account := newBalanceForName(impl.name)
+		b.Run(impl.Name, func(b *testing.B) {
+			account := newBenchmarkBalance(b, impl, balance.WithClock(balancetest.NewManualClock(1)))
 
 			b.ReportAllocs()
 			b.ResetTimer()
@@ -67,10 +54,10 @@ func BenchmarkBalanceAdd(b *testing.B) {
 }
 
 func BenchmarkBalanceAddWithRead(b *testing.B) {
-	for _, impl := range benchmarkImplementations {
+	for _, impl := range balance.Implementations() {
 		impl := impl
-		b.Run(impl.name, func(b *testing.B) {
-			account := newBalanceForName(impl.name)
+		b.Run(impl.Name, func(b *testing.B) {
+			account := newBenchmarkBalance(b, impl)
 
 			b.ReportAllocs()
 			b.ResetTimer()
@@ -93,10 +80,10 @@ func BenchmarkBalanceAddWithRead(b *testing.B) {
 }
 
 func BenchmarkBalanceReadOnly(b *testing.B) {
-	for _, impl := range benchmarkImplementations {
+	for _, impl := range balance.Implementations() {
 		impl := impl
-		b.Run(impl.name, func(b *testing.B) {
-			account := newBalanceForName(impl.name)
+		b.Run(impl.Name, func(b *testing.B) {
+			account := newBenchmarkBalance(b, impl)
 
 			// Prime the value to avoid zero-edge quirks.
 			account.Add(1)
@@ -114,28 +101,102 @@ func BenchmarkBalanceReadOnly(b *testing.B) {
 	}
 }
 
-// newBalanceForName constructs a fresh Balance for the given benchmark name.
-// Keeping construction here avoids repeating switch logic and ensures each
-// sub-benchmark gets a clean instance.
-func newBalanceForName(name string) Balance {
-	switch name {
-	case "Atomic_Balance_bugs_simple":
-		return atomicbugssimple.New()
-	case "Atomic_Balance_bugs_full":
-		return atomicbugsfull.New()
-	case "Atomic_Balance_CAS_simple":
-		return atomiccassimple.New()
-	case "Atomic_Balance_CAS_full":
-		return atomiccasfull.New()
-	case "RWMutex_Balance_simple":
-		return rwmutexsimple.New()
-	case "RWMutex_Balance_full":
-		return rwmutexfull.New()
-	case "Mutex_Balance_simple":
-		return mutexsimple.New()
-	case "Mutex_Balance_full":
-		return mutexfull.New()
-	default:
-		return rwmutexsimple.New()
+// BenchmarkBalanceApplyBatch compares committing a deposit, fee, and charge
+// as one ApplyBatch against the same three calls made individually. The ops
+// net to zero so the balance stays primed.
+func BenchmarkBalanceApplyBatch(b *testing.B) {
+	ops := []balance.Op{
+		{Kind: balance.OpAdd, Amount: 3},
+		{Kind: balance.OpSubtract, Amount: 1},
+		{Kind: balance.OpSubtract, Amount: 2},
+	}
+
+	for _, impl := range balance.Implementations() {
+		impl := impl
+		b.Run(impl.Name+"/batch", func(b *testing.B) {
+			account := newBenchmarkBalance(b, impl)
+			account.Add(1_000)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					_ = balance.ApplyBatch(account, ops)
+				}
+			})
+		})
+
+		b.Run(impl.Name+"/individual", func(b *testing.B) {
+			account := newBenchmarkBalance(b, impl)
+			account.Add(1_000)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					account.Add(3)
+					_ = account.Subtract(1)
+					_ = account.Subtract(2)
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkBalanceContention alternates deposits and withdrawals with
+// contention stats enabled and reports them per op: retries/op for CAS
+// loops, and lock-waits/op and wait-ns/op for lock-based implementations.
+// Implementations that do not report stats only get the usual metrics.
+func BenchmarkBalanceContention(b *testing.B) {
+	for _, impl := range balance.Implementations() {
+		impl := impl
+		b.Run(impl.Name, func(b *testing.B) {
+			account := newBenchmarkBalance(b, impl, balance.WithStats())
+			account.Add(1_000)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					account.Add(1)
+					_ = account.Subtract(1)
+				}
+			})
+
+			reportStats(b, account)
+		})
+	}
+}
+
+// reportStats adds the contention counters of account, if it keeps any, to
+// the benchmark output as per-op metrics.
+func reportStats(b *testing.B, account balance.Balance) {
+	sb, ok := account.(balance.StatsBalance)
+	if !ok {
+		return
+	}
+
+	stats := sb.Stats()
+	n := float64(b.N)
+	b.ReportMetric(float64(stats.CASRetries)/n, "retries/op")
+	b.ReportMetric(float64(stats.LockWaits)/n, "lock-waits/op")
+	b.ReportMetric(float64(stats.LockWait.Nanoseconds())/n, "wait-ns/op")
+}
+
+// newBenchmarkBalance constructs a fresh Balance for a sub-benchmark and
+// closes it afterwards when the implementation owns resources. Options are
+// applied when the implementation accepts them and ignored otherwise.
+func newBenchmarkBalance(b *testing.B, impl balance.Implementation, opts ...balance.Option) balance.Balance {
+	account := impl.New
+	if len(opts) > 0 && impl.NewWithOptions != nil {
+		account = func() balance.Balance { return impl.NewWithOptions(opts...) }
+	}
+	acct := account()
+	if c, ok := acct.(io.Closer); ok {
+		b.Cleanup(func() { _ = c.Close() })
 	}
+	return acct
 }
@@ -0,0 +1,479 @@
+package balancetest
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	balance "github.com/madflojo/atomics-v-rwmutex-examples"
+
+	"github.com/madflojo/testlazy/helpers/counter"
+)
+
+// Run certifies the Balance produced by factory against the shared
+// contract: initial state, single-threaded deposits and withdrawals,
+// concurrent subtracts, insufficient funds, rejection of zero and negative
+// amounts, a linearizability check of a recorded concurrent history,
+// snapshots, and the transaction counter. Scenarios run in order against a
+// single instance, mirroring how a real account accumulates history.
+//
+// traits adjusts the expectations: implementations without metadata must
+// report zero transactions and timestamps, known-buggy implementations must
+// go negative under contention and produce a non-linearizable history, and
+// consistent snapshots are checked under concurrent writers.
+func Run(t *testing.T, factory balance.Factory, traits balance.Traits) {
+	t.Helper()
+
+	acct := factory()
+	if c, ok := acct.(io.Closer); ok {
+		t.Cleanup(func() { _ = c.Close() })
+	}
+	bal := counter.New()
+	trx := counter.New()
+
+	t.Run("initial state", func(t *testing.T) {
+		if acct.Balance() != bal.Value() {
+			t.Fatalf("expected zero balance, got %d", acct.Balance())
+		}
+
+		if traits.TracksMetadata {
+			if acct.TransactionCount() != trx.Value() {
+				t.Fatalf("expected zero transaction count, got %d", acct.TransactionCount())
+			}
+			if acct.LastUpdated() != 0 {
+				t.Fatalf("expected zero last updated, got %d", acct.LastUpdated())
+			}
+		} else {
+			if acct.TransactionCount() != 0 {
+				t.Fatalf("simple balances must not track transactions, got %d", acct.TransactionCount())
+			}
+			if acct.LastUpdated() != 0 {
+				t.Fatalf("simple balances must not track last updated, got %d", acct.LastUpdated())
+			}
+		}
+	})
+
+	t.Run("single threaded", func(t *testing.T) {
+		t.Run("deposit", func(t *testing.T) {
+			prev := acct.LastUpdated()
+			acct.Add(1000)
+			bal.Add(1000)
+			trx.Add(1)
+
+			if acct.Balance() != bal.Value() {
+				t.Fatalf("deposit mismatch, got %d want %d", acct.Balance(), bal.Value())
+			}
+
+			if traits.TracksMetadata {
+				if acct.LastUpdated() < prev {
+					t.Fatalf("last updated failed to advance after deposit")
+				}
+			} else if acct.LastUpdated() != 0 {
+				t.Fatalf("simple balances must not track last updated")
+			}
+
+			prev = acct.LastUpdated()
+			for i := 0; i < 1000; i++ {
+				acct.Add(100)
+				bal.Add(100)
+				trx.Add(1)
+			}
+
+			if acct.Balance() != bal.Value() {
+				t.Fatalf(
+					"balance mismatch after deposits, got %d want %d",
+					acct.Balance(),
+					bal.Value(),
+				)
+			}
+
+			if traits.TracksMetadata {
+				if acct.LastUpdated() < prev {
+					t.Fatalf("last updated failed to advance after deposits")
+				}
+			} else if acct.LastUpdated() != 0 {
+				t.Fatalf("simple balances must not track last updated")
+			}
+		})
+
+		t.Run("withdraw", func(t *testing.T) {
+			prev := acct.LastUpdated()
+			for i := 0; i < 500; i++ {
+				if err := acct.Subtract(50); err != nil {
+					t.Fatalf("unexpected subtract error: %v", err)
+				}
+				bal.Subtract(50)
+				trx.Add(1)
+			}
+
+			if acct.Balance() != bal.Value() {
+				t.Fatalf(
+					"balance mismatch after withdrawals, got %d want %d",
+					acct.Balance(),
+					bal.Value(),
+				)
+			}
+
+			if traits.TracksMetadata {
+				if acct.LastUpdated() < prev {
+					t.Fatalf("last updated failed to advance after withdrawals")
+				}
+			} else if acct.LastUpdated() != 0 {
+				t.Fatalf("simple balances must not track last updated")
+			}
+		})
+	})
+
+	t.Run("concurrent subtract", func(t *testing.T) {
+		const (
+			deposit  = 1_000
+			withdraw = 25
+			workers  = 32
+			iters    = 80
+		)
+
+		if current := acct.Balance(); current > deposit {
+			if err := acct.Subtract(current - deposit); err != nil {
+				t.Fatalf("failed to normalize balance before concurrent test: %v", err)
+			}
+			bal.Subtract(current - deposit)
+			trx.Add(1)
+		}
+
+		prev := acct.LastUpdated()
+		acct.Add(deposit)
+		bal.Add(deposit)
+		trx.Add(1)
+
+		if traits.TracksMetadata {
+			if acct.LastUpdated() < prev {
+				t.Fatalf("last updated failed to advance after prep deposit")
+			}
+		} else if acct.LastUpdated() != 0 {
+			t.Fatalf("simple balances must not track last updated")
+		}
+		prev = acct.LastUpdated()
+
+		var wg sync.WaitGroup
+		var success int64
+		var fail int64
+		total := workers * iters
+
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < iters; i++ {
+					if err := acct.Subtract(withdraw); err != nil {
+						atomic.AddInt64(&fail, 1)
+						continue
+					}
+
+					bal.Subtract(withdraw)
+					trx.Add(1)
+					atomic.AddInt64(&success, 1)
+				}
+			}()
+		}
+
+		wg.Wait()
+
+		successCount := atomic.LoadInt64(&success)
+		failCount := atomic.LoadInt64(&fail)
+		if got := successCount + failCount; got != int64(total) {
+			t.Fatalf("unexpected subtract attempts, got %d want %d", got, total)
+		}
+
+		if traits.TracksMetadata {
+			if acct.LastUpdated() < prev {
+				t.Fatalf("last updated failed to advance after concurrent subtracts")
+			}
+		} else if acct.LastUpdated() != 0 {
+			t.Fatalf("simple balances must not track last updated")
+		}
+
+		if traits.KnownBuggy {
+			if acct.Balance() >= 0 {
+				t.Fatalf(
+					"expected buggy implementation to go negative, got %d",
+					acct.Balance(),
+				)
+			}
+			if failCount == 0 {
+				t.Fatalf("expected at least one subtract failure")
+			}
+			return
+		}
+
+		if acct.Balance() < 0 {
+			t.Fatalf("balance went negative, got %d", acct.Balance())
+		}
+
+		if acct.Balance() != bal.Value() {
+			t.Fatalf(
+				"balance mismatch after concurrent subtracts, got %d want %d",
+				acct.Balance(),
+				bal.Value(),
+			)
+		}
+
+		if failCount == 0 {
+			t.Fatalf("expected at least one subtract failure")
+		}
+	})
+
+	t.Run("insufficient funds", func(t *testing.T) {
+		prevBal := bal.Value()
+		prevTrx := trx.Value()
+		prevUpdate := acct.LastUpdated()
+		// Known-buggy implementations may already be negative; keep the amount
+		// positive so the withdrawal is rejected for lack of funds.
+		amount := max(prevBal, 0) + 123
+
+		err := acct.Subtract(amount)
+		if !errors.Is(err, balance.ErrInsufficientFunds) {
+			t.Fatalf("expected ErrInsufficientFunds when subtracting past balance, got %v", err)
+		}
+
+		var fundsErr *balance.InsufficientFundsError
+		if !errors.As(err, &fundsErr) {
+			t.Fatalf("expected *InsufficientFundsError, got %T", err)
+		}
+		if fundsErr.Requested != amount {
+			t.Fatalf("error requested mismatch, got %d want %d", fundsErr.Requested, amount)
+		}
+		if fundsErr.Available != prevBal {
+			t.Fatalf("error available mismatch, got %d want %d", fundsErr.Available, prevBal)
+		}
+
+		if acct.Balance() != prevBal {
+			t.Fatalf(
+				"balance changed after failed subtract, got %d want %d",
+				acct.Balance(),
+				prevBal,
+			)
+		}
+
+		if traits.TracksMetadata {
+			if acct.TransactionCount() != prevTrx {
+				t.Fatalf(
+					"transaction count changed after failed subtract, got %d want %d",
+					acct.TransactionCount(),
+					prevTrx,
+				)
+			}
+			if acct.LastUpdated() != prevUpdate {
+				t.Fatalf("last updated changed after failed subtract")
+			}
+		} else {
+			if acct.TransactionCount() != 0 {
+				t.Fatalf("simple balances must not track transactions, got %d", acct.TransactionCount())
+			}
+			if acct.LastUpdated() != 0 {
+				t.Fatalf("simple balances must not track last updated")
+			}
+		}
+	})
+
+	t.Run("invalid amounts", func(t *testing.T) {
+		for _, amount := range []int64{0, -1, -100, math.MinInt64} {
+			t.Run(fmt.Sprintf("amount %d", amount), func(t *testing.T) {
+				before := acct.Snapshot()
+
+				acct.Add(amount)
+				if err := acct.Subtract(amount); !errors.Is(err, balance.ErrInvalidAmount) {
+					t.Fatalf("Subtract(%d): expected ErrInvalidAmount, got %v", amount, err)
+				}
+				if checked, ok := acct.(balance.CheckedBalance); ok {
+					if err := checked.AddChecked(amount); !errors.Is(err, balance.ErrInvalidAmount) {
+						t.Fatalf("AddChecked(%d): expected ErrInvalidAmount, got %v", amount, err)
+					}
+				}
+
+				after := acct.Snapshot()
+				if after.Value != before.Value {
+					t.Fatalf("value changed, got %d want %d", after.Value, before.Value)
+				}
+				if after.TransactionCount != before.TransactionCount {
+					t.Fatalf(
+						"transaction count changed, got %d want %d",
+						after.TransactionCount,
+						before.TransactionCount,
+					)
+				}
+				if after.LastUpdated != before.LastUpdated {
+					t.Fatalf("last updated changed after invalid amount")
+				}
+			})
+		}
+	})
+
+	t.Run("linearizable history", func(t *testing.T) {
+		const (
+			deposit  = 200
+			withdraw = 30
+			refill   = 5
+			workers  = 8
+			iters    = 20
+		)
+
+		// Known-buggy implementations may already be negative, so top up
+		// enough to give every worker a chance to succeed.
+		initial := acct.Balance()
+		topUp := int64(deposit)
+		if initial < 0 {
+			topUp -= initial
+		}
+
+		rec := NewRecorder(acct)
+		rec.Add(topUp)
+
+		var wg sync.WaitGroup
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < iters; i++ {
+					switch i % 4 {
+					case 0:
+						rec.Balance()
+					case 1:
+						rec.Add(refill)
+					default:
+						_ = rec.Subtract(withdraw)
+					}
+				}
+			}()
+		}
+		wg.Wait()
+
+		history := rec.History()
+		for _, op := range history {
+			switch {
+			case op.Kind == OpAdd:
+				bal.Add(op.Amount)
+				trx.Add(1)
+			case op.Kind == OpSubtract && op.Err == nil:
+				bal.Subtract(op.Amount)
+				trx.Add(1)
+			}
+		}
+
+		ok := Linearizable(initial, history)
+		if traits.KnownBuggy {
+			if ok {
+				t.Fatalf("expected buggy implementation to produce a non-linearizable history")
+			}
+			return
+		}
+		if !ok {
+			t.Fatalf("history of %d operations is not linearizable", len(history))
+		}
+	})
+
+	t.Run("snapshot", func(t *testing.T) {
+		snap := acct.Snapshot()
+		if snap.Value != acct.Balance() {
+			t.Fatalf("snapshot value mismatch, got %d want %d", snap.Value, acct.Balance())
+		}
+		if snap.TransactionCount != acct.TransactionCount() {
+			t.Fatalf(
+				"snapshot transaction count mismatch, got %d want %d",
+				snap.TransactionCount,
+				acct.TransactionCount(),
+			)
+		}
+		if snap.LastUpdated != acct.LastUpdated() {
+			t.Fatalf(
+				"snapshot last updated mismatch, got %d want %d",
+				snap.LastUpdated,
+				acct.LastUpdated(),
+			)
+		}
+	})
+
+	if traits.TracksMetadata && traits.ConsistentSnapshot {
+		t.Run("concurrent snapshot", func(t *testing.T) {
+			const (
+				writers = 8
+				readers = 8
+				iters   = 500
+			)
+
+			// Every Add(1) moves value and transaction count together, so
+			// a consistent snapshot must always preserve their difference.
+			base := acct.Snapshot()
+			offset := base.Value - base.TransactionCount
+
+			var wg sync.WaitGroup
+			var torn int64
+
+			for r := 0; r < readers; r++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for i := 0; i < iters; i++ {
+						snap := acct.Snapshot()
+						if snap.Value-snap.TransactionCount != offset {
+							atomic.AddInt64(&torn, 1)
+						}
+					}
+				}()
+			}
+
+			for w := 0; w < writers; w++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for i := 0; i < iters; i++ {
+						acct.Add(1)
+						bal.Add(1)
+						trx.Add(1)
+					}
+				}()
+			}
+
+			wg.Wait()
+
+			if got := atomic.LoadInt64(&torn); got != 0 {
+				t.Fatalf("observed %d inconsistent snapshots", got)
+			}
+		})
+	}
+
+	if traits.TracksMetadata {
+		t.Run("transaction counter", func(t *testing.T) {
+			if acct.TransactionCount() != trx.Value() {
+				t.Fatalf(
+					"transaction counter mismatch, got %d want %d",
+					acct.TransactionCount(),
+					trx.Value(),
+				)
+			}
+		})
+	}
+}
+
+// New constructs a Balance from impl, configured by opts when given, and
+// closes it when the test finishes if the implementation owns resources.
+// It fails the test if opts are given and impl does not accept options.
+func New(t *testing.T, impl balance.Implementation, opts ...balance.Option) balance.Balance {
+	t.Helper()
+	var acct balance.Balance
+	switch {
+	case len(opts) == 0:
+		acct = impl.New()
+	case impl.NewWithOptions != nil:
+		acct = impl.NewWithOptions(opts...)
+	default:
+		t.Fatalf("%s does not accept options", impl.Name)
+	}
+	if c, ok := acct.(io.Closer); ok {
+		t.Cleanup(func() { _ = c.Close() })
+	}
+	return acct
+}
@@ -0,0 +1,15 @@
+/*
+Package balancetest provides the conformance suite every Balance
+implementation in this repository is certified against. Implementations
+living outside the repository can reuse it to prove they honor the same
+contract:
+
+	func TestMyBalance(t *testing.T) {
+		balancetest.Run(t, func() balance.Balance { return mybalance.New() }, balance.Traits{
+			Strategy:           balance.StrategyMutex,
+			TracksMetadata:     true,
+			ConsistentSnapshot: true,
+		})
+	}
+*/
+package balancetest
@@ -0,0 +1,167 @@
+package balancetest
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	balance "github.com/madflojo/atomics-v-rwmutex-examples"
+)
+
+// RunLimits certifies that the Balance produced by factory enforces
+// balance.Limits: the floor holds under concurrent subtracts for a minimum
+// reserve, an overdraft, and both combined, and deposits stop at the
+// ceiling. Known-buggy implementations must break the floor under
+// contention, just as Run expects them to go negative. Transfers must
+// respect the source's floor and the destination's ceiling.
+func RunLimits(t *testing.T, factory balance.OptionFactory, traits balance.Traits) {
+	t.Helper()
+
+	floors := []balance.Limits{
+		{Floor: 100},
+		{Overdraft: 200},
+		{Floor: 50, Overdraft: 75},
+	}
+	for _, limits := range floors {
+		limits := limits
+		name := fmt.Sprintf("concurrent subtract floor %d overdraft %d", limits.Floor, limits.Overdraft)
+		t.Run(name, func(t *testing.T) {
+			concurrentSubtractFloor(t, newLimited(t, factory, limits), limits, traits)
+		})
+	}
+
+	t.Run("ceiling", func(t *testing.T) {
+		limits := balance.Limits{Ceiling: 1_000}
+		acct := newLimited(t, factory, limits)
+
+		acct.Add(900)
+		acct.Add(200)
+		if acct.Balance() != 900 {
+			t.Fatalf("unchecked add exceeded the ceiling, got %d want 900", acct.Balance())
+		}
+
+		checked, ok := acct.(balance.CheckedBalance)
+		if !ok {
+			t.Skip("implementation does not support checked deposits")
+		}
+		if err := checked.AddChecked(101); !errors.Is(err, balance.ErrCeilingExceeded) {
+			t.Fatalf("expected ErrCeilingExceeded, got %v", err)
+		}
+		if err := checked.AddChecked(100); err != nil {
+			t.Fatalf("deposit up to the ceiling failed: %v", err)
+		}
+		if acct.Balance() != 1_000 {
+			t.Fatalf("balance mismatch, got %d want 1000", acct.Balance())
+		}
+	})
+
+	t.Run("transfer", func(t *testing.T) {
+		src := newLimited(t, factory, balance.Limits{Floor: 100})
+		dst := newLimited(t, factory, balance.Limits{Ceiling: 50})
+		if _, ok := src.(balance.Transferer); !ok {
+			t.Skip("implementation does not support transfers")
+		}
+		src.Add(150)
+
+		if err := balance.Transfer(src, dst, 60); !errors.Is(err, balance.ErrInsufficientFunds) {
+			t.Fatalf("expected ErrInsufficientFunds below the source floor, got %v", err)
+		}
+		dst.Add(10)
+		if err := balance.Transfer(src, dst, 45); !errors.Is(err, balance.ErrCeilingExceeded) {
+			t.Fatalf("expected ErrCeilingExceeded above the destination ceiling, got %v", err)
+		}
+		if src.Balance() != 150 || dst.Balance() != 10 {
+			t.Fatalf("rejected transfers moved funds, got %d and %d want 150 and 10", src.Balance(), dst.Balance())
+		}
+		if err := balance.Transfer(src, dst, 40); err != nil {
+			t.Fatalf("unexpected transfer error: %v", err)
+		}
+		if src.Balance() != 110 || dst.Balance() != 50 {
+			t.Fatalf("transfer mismatch, got %d and %d want 110 and 50", src.Balance(), dst.Balance())
+		}
+	})
+}
+
+// concurrentSubtractFloor drains acct with concurrent withdrawals and checks
+// that exactly the funds above limits.Min were paid out.
+func concurrentSubtractFloor(t *testing.T, acct balance.Balance, limits balance.Limits, traits balance.Traits) {
+	const (
+		deposit  = 1_000
+		withdraw = 25
+		workers  = 32
+		iters    = 80
+	)
+
+	acct.Add(deposit)
+	floor := limits.Min()
+
+	var wg sync.WaitGroup
+	var success, fail atomic.Int64
+	var wrongErr atomic.Value
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < iters; i++ {
+				err := acct.Subtract(withdraw)
+				switch {
+				case err == nil:
+					success.Add(1)
+				case errors.Is(err, balance.ErrInsufficientFunds):
+					fail.Add(1)
+				default:
+					wrongErr.Store(err)
+					fail.Add(1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if err, _ := wrongErr.Load().(error); err != nil {
+		t.Fatalf("unexpected subtract error: %v", err)
+	}
+	if got := success.Load() + fail.Load(); got != workers*iters {
+		t.Fatalf("unexpected subtract attempts, got %d want %d", got, workers*iters)
+	}
+
+	if traits.KnownBuggy {
+		if acct.Balance() >= floor {
+			t.Fatalf("expected buggy implementation to break the floor %d, got %d", floor, acct.Balance())
+		}
+		return
+	}
+
+	if acct.Balance() < floor {
+		t.Fatalf("balance broke the floor %d, got %d", floor, acct.Balance())
+	}
+	if got, want := success.Load(), int64((deposit-floor)/withdraw); got != want {
+		t.Fatalf("successful withdrawals mismatch, got %d want %d", got, want)
+	}
+	if got, want := acct.Balance(), deposit-success.Load()*withdraw; got != want {
+		t.Fatalf("balance mismatch, got %d want %d", got, want)
+	}
+
+	var fundsErr *balance.InsufficientFundsError
+	err := acct.Subtract(withdraw)
+	if !errors.As(err, &fundsErr) {
+		t.Fatalf("expected InsufficientFundsError at the floor, got %v", err)
+	}
+	if want := acct.Balance() - floor; fundsErr.Available != want {
+		t.Fatalf("error available mismatch, got %d want %d", fundsErr.Available, want)
+	}
+}
+
+// newLimited constructs a Balance enforcing limits and closes it when the
+// test finishes if it owns resources.
+func newLimited(t *testing.T, factory balance.OptionFactory, limits balance.Limits) balance.Balance {
+	t.Helper()
+	acct := factory(balance.WithLimits(limits))
+	if c, ok := acct.(io.Closer); ok {
+		t.Cleanup(func() { _ = c.Close() })
+	}
+	return acct
+}
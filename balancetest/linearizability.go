@@ -0,0 +1,188 @@
+package balancetest
+
+import (
+	"encoding/binary"
+	"errors"
+	"sort"
+
+	balance "github.com/madflojo/atomics-v-rwmutex-examples"
+)
+
+// Linearizable reports whether history can be explained by applying every
+// operation atomically, one at a time, to a sequential balance that starts
+// at initial. The chosen order must respect real time: an operation that
+// returned before another was invoked must also take effect first.
+//
+// The sequential model accepts every Add (ignoring non-positive amounts),
+// accepts a successful Subtract only if it leaves the balance non-negative,
+// accepts a Subtract failing with ErrInvalidAmount only for a non-positive
+// amount and one failing with ErrInsufficientFunds only if it would have
+// driven the balance negative, and accepts a Balance read only if it
+// returned the model's current value.
+//
+// The search follows the Wing & Gong algorithm with Lowe's memoization of
+// (linearized set, state) pairs. It is exponential in the worst case, so
+// keep histories to a few hundred operations with modest concurrency.
+func Linearizable(initial int64, history []Operation) bool {
+	if len(history) == 0 {
+		return true
+	}
+
+	entries := buildEntries(history)
+	linearized := make([]uint64, (len(history)+63)/64)
+	seen := make(map[string]struct{})
+	state := initial
+
+	type frame struct {
+		entry int
+		state int64
+	}
+	var stack []frame
+
+	entry := entries[0].next
+	for entries[0].next != -1 {
+		e := &entries[entry]
+		if e.call {
+			if next, ok := step(state, history[e.op]); ok {
+				setBit(linearized, e.op)
+				key := cacheKey(linearized, next)
+				if _, dup := seen[key]; !dup {
+					seen[key] = struct{}{}
+					stack = append(stack, frame{entry: entry, state: state})
+					state = next
+					lift(entries, entry)
+					entry = entries[0].next
+					continue
+				}
+				clearBit(linearized, e.op)
+			}
+			entry = e.next
+			continue
+		}
+
+		// A return was reached before its call could be linearized, so the
+		// most recent choice has to be undone.
+		if len(stack) == 0 {
+			return false
+		}
+		top := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		state = top.state
+		clearBit(linearized, entries[top.entry].op)
+		unlift(entries, top.entry)
+		entry = entries[top.entry].next
+	}
+
+	return true
+}
+
+// step applies op to the sequential model.
+func step(state int64, op Operation) (int64, bool) {
+	switch op.Kind {
+	case OpAdd:
+		if op.Amount <= 0 {
+			return state, true
+		}
+		return state + op.Amount, true
+	case OpSubtract:
+		if op.Amount <= 0 {
+			return state, errors.Is(op.Err, balance.ErrInvalidAmount)
+		}
+		next := state - op.Amount
+		if op.Err != nil {
+			return state, next < 0 && errors.Is(op.Err, balance.ErrInsufficientFunds)
+		}
+		if next < 0 {
+			return state, false
+		}
+		return next, true
+	case OpBalance:
+		return state, op.Result == state
+	default:
+		return state, false
+	}
+}
+
+// entry is a node in the doubly linked list of call and return events.
+// Index 0 is a sentinel head.
+type entry struct {
+	op         int
+	call       bool
+	match      int
+	prev, next int
+}
+
+func buildEntries(history []Operation) []entry {
+	type event struct {
+		op   int
+		call bool
+		at   int64
+	}
+
+	events := make([]event, 0, 2*len(history))
+	for i, op := range history {
+		events = append(events, event{op: i, call: true, at: op.Invoke})
+		events = append(events, event{op: i, call: false, at: op.Return})
+	}
+
+	// Calls sort ahead of returns that share a timestamp, treating the two
+	// operations as concurrent rather than inventing an order.
+	sort.SliceStable(events, func(i, j int) bool {
+		if events[i].at != events[j].at {
+			return events[i].at < events[j].at
+		}
+		return events[i].call && !events[j].call
+	})
+
+	entries := make([]entry, len(events)+1)
+	entries[0] = entry{op: -1, prev: -1, next: 1}
+	calls := make([]int, len(history))
+	for i, ev := range events {
+		idx := i + 1
+		next := idx + 1
+		if next > len(events) {
+			next = -1
+		}
+		entries[idx] = entry{op: ev.op, call: ev.call, prev: idx - 1, next: next}
+		if ev.call {
+			calls[ev.op] = idx
+		} else {
+			entries[calls[ev.op]].match = idx
+		}
+	}
+	return entries
+}
+
+// lift unlinks a call entry and its matching return.
+func lift(entries []entry, call int) {
+	for _, idx := range [2]int{call, entries[call].match} {
+		e := entries[idx]
+		entries[e.prev].next = e.next
+		if e.next != -1 {
+			entries[e.next].prev = e.prev
+		}
+	}
+}
+
+// unlift relinks entries removed by lift, in reverse order.
+func unlift(entries []entry, call int) {
+	for _, idx := range [2]int{entries[call].match, call} {
+		e := entries[idx]
+		entries[e.prev].next = idx
+		if e.next != -1 {
+			entries[e.next].prev = idx
+		}
+	}
+}
+
+func setBit(bits []uint64, i int)   { bits[i/64] |= 1 << (i % 64) }
+func clearBit(bits []uint64, i int) { bits[i/64] &^= 1 << (i % 64) }
+
+func cacheKey(bits []uint64, state int64) string {
+	buf := make([]byte, 8*(len(bits)+1))
+	for i, w := range bits {
+		binary.LittleEndian.PutUint64(buf[8*i:], w)
+	}
+	binary.LittleEndian.PutUint64(buf[8*len(bits):], uint64(state))
+	return string(buf)
+}
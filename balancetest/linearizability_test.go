@@ -0,0 +1,121 @@
+package balancetest
+
+import (
+	"testing"
+
+	balance "github.com/madflojo/atomics-v-rwmutex-examples"
+)
+
+func TestLinearizable(t *testing.T) {
+	errFunds := &balance.InsufficientFundsError{Requested: 60, Available: 40}
+
+	testCases := []struct {
+		name    string
+		initial int64
+		history []Operation
+		want    bool
+	}{
+		{
+			name: "empty history",
+			want: true,
+		},
+		{
+			name:    "sequential deposit and withdraw",
+			initial: 0,
+			history: []Operation{
+				{Kind: OpAdd, Amount: 100, Invoke: 1, Return: 2},
+				{Kind: OpSubtract, Amount: 40, Invoke: 3, Return: 4},
+				{Kind: OpBalance, Result: 60, Invoke: 5, Return: 6},
+			},
+			want: true,
+		},
+		{
+			name:    "stale read after completed write",
+			initial: 0,
+			history: []Operation{
+				{Kind: OpAdd, Amount: 100, Invoke: 1, Return: 2},
+				{Kind: OpBalance, Result: 0, Invoke: 3, Return: 4},
+			},
+			want: false,
+		},
+		{
+			name:    "concurrent read may observe either side",
+			initial: 0,
+			history: []Operation{
+				{Kind: OpAdd, Amount: 100, Invoke: 1, Return: 4},
+				{Kind: OpBalance, Result: 0, Invoke: 2, Return: 3},
+			},
+			want: true,
+		},
+		{
+			name:    "concurrent subtracts both succeed past balance",
+			initial: 100,
+			history: []Operation{
+				{Kind: OpSubtract, Amount: 60, Invoke: 1, Return: 3},
+				{Kind: OpSubtract, Amount: 60, Invoke: 2, Return: 4},
+			},
+			want: false,
+		},
+		{
+			name:    "concurrent subtracts exactly one succeeds",
+			initial: 100,
+			history: []Operation{
+				{Kind: OpSubtract, Amount: 60, Invoke: 1, Return: 3},
+				{Kind: OpSubtract, Amount: 60, Err: errFunds, Invoke: 2, Return: 4},
+				{Kind: OpBalance, Result: 40, Invoke: 5, Return: 6},
+			},
+			want: true,
+		},
+		{
+			name:    "spurious insufficient funds",
+			initial: 100,
+			history: []Operation{
+				{Kind: OpSubtract, Amount: 60, Err: errFunds, Invoke: 1, Return: 2},
+			},
+			want: false,
+		},
+		{
+			name:    "failure justified by concurrent withdraw",
+			initial: 100,
+			history: []Operation{
+				{Kind: OpSubtract, Amount: 60, Err: errFunds, Invoke: 1, Return: 4},
+				{Kind: OpSubtract, Amount: 50, Invoke: 2, Return: 3},
+			},
+			want: true,
+		},
+		{
+			name:    "invalid amounts are no-ops",
+			initial: 100,
+			history: []Operation{
+				{Kind: OpAdd, Amount: -50, Invoke: 1, Return: 2},
+				{Kind: OpSubtract, Amount: -50, Err: balance.ErrInvalidAmount, Invoke: 3, Return: 4},
+				{Kind: OpBalance, Result: 100, Invoke: 5, Return: 6},
+			},
+			want: true,
+		},
+		{
+			name:    "invalid subtract reported as insufficient funds",
+			initial: 100,
+			history: []Operation{
+				{Kind: OpSubtract, Amount: -50, Err: errFunds, Invoke: 1, Return: 2},
+			},
+			want: false,
+		},
+		{
+			name:    "invalid subtract reported as success",
+			initial: 100,
+			history: []Operation{
+				{Kind: OpSubtract, Amount: 0, Invoke: 1, Return: 2},
+			},
+			want: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := Linearizable(tc.initial, tc.history); got != tc.want {
+				t.Fatalf("Linearizable() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
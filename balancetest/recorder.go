@@ -0,0 +1,117 @@
+package balancetest
+
+import (
+	"sync"
+	"sync/atomic"
+
+	balance "github.com/madflojo/atomics-v-rwmutex-examples"
+)
+
+// OpKind identifies the Balance call captured in an Operation.
+type OpKind int
+
+const (
+	// OpBalance is a call to Balance.
+	OpBalance OpKind = iota
+	// OpAdd is a call to Add.
+	OpAdd
+	// OpSubtract is a call to Subtract.
+	OpSubtract
+)
+
+// String returns the method name for k.
+func (k OpKind) String() string {
+	switch k {
+	case OpBalance:
+		return "Balance"
+	case OpAdd:
+		return "Add"
+	case OpSubtract:
+		return "Subtract"
+	default:
+		return "Unknown"
+	}
+}
+
+// Operation is a single recorded call along with its observed result.
+type Operation struct {
+	// Kind identifies the method that was called.
+	Kind OpKind
+	// Amount is the argument passed to Add or Subtract.
+	Amount int64
+	// Result is the value returned by Balance.
+	Result int64
+	// Err is the error returned by Subtract.
+	Err error
+	// Invoke and Return are logical timestamps taken immediately before the
+	// call started and after it finished. They come from one shared counter,
+	// so a.Return < b.Invoke means a completed before b began.
+	Invoke, Return int64
+}
+
+// Recorder wraps a Balance and records the invoke and return time of every
+// Balance, Add, and Subtract call. It is safe for concurrent use and
+// satisfies balance.Balance, so it can stand in for the wrapped account.
+type Recorder struct {
+	inner balance.Balance
+	clock atomic.Int64
+
+	mu  sync.Mutex
+	ops []Operation
+}
+
+// NewRecorder returns a Recorder that forwards calls to b.
+func NewRecorder(b balance.Balance) *Recorder {
+	return &Recorder{inner: b}
+}
+
+// Balance records and forwards a Balance call.
+func (r *Recorder) Balance() int64 {
+	invoke := r.clock.Add(1)
+	v := r.inner.Balance()
+	r.record(Operation{Kind: OpBalance, Result: v, Invoke: invoke, Return: r.clock.Add(1)})
+	return v
+}
+
+// TransactionCount forwards to the wrapped Balance without recording.
+func (r *Recorder) TransactionCount() int64 { return r.inner.TransactionCount() }
+
+// LastUpdated forwards to the wrapped Balance without recording.
+func (r *Recorder) LastUpdated() int64 { return r.inner.LastUpdated() }
+
+// Snapshot forwards to the wrapped Balance without recording.
+func (r *Recorder) Snapshot() balance.Snapshot { return r.inner.Snapshot() }
+
+// Add records and forwards an Add call.
+func (r *Recorder) Add(amount int64) {
+	invoke := r.clock.Add(1)
+	r.inner.Add(amount)
+	r.record(Operation{Kind: OpAdd, Amount: amount, Invoke: invoke, Return: r.clock.Add(1)})
+}
+
+// Subtract records and forwards a Subtract call.
+func (r *Recorder) Subtract(amount int64) error {
+	invoke := r.clock.Add(1)
+	err := r.inner.Subtract(amount)
+	r.record(Operation{
+		Kind:   OpSubtract,
+		Amount: amount,
+		Err:    err,
+		Invoke: invoke,
+		Return: r.clock.Add(1),
+	})
+	return err
+}
+
+// History returns a copy of every operation recorded so far.
+func (r *Recorder) History() []Operation {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Operation(nil), r.ops...)
+}
+
+func (r *Recorder) record(op Operation) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ops = append(r.ops, op)
+}
@@ -0,0 +1,104 @@
+package balance
+
+import "fmt"
+
+// OpKind selects what a batched Op does.
+type OpKind int
+
+const (
+	// OpAdd deposits Amount.
+	OpAdd OpKind = iota + 1
+	// OpSubtract withdraws Amount.
+	OpSubtract
+)
+
+// String returns the method name the kind mirrors.
+func (k OpKind) String() string {
+	switch k {
+	case OpAdd:
+		return "Add"
+	case OpSubtract:
+		return "Subtract"
+	default:
+		return fmt.Sprintf("OpKind(%d)", int(k))
+	}
+}
+
+// Op is one step of a batch passed to ApplyBatch.
+type Op struct {
+	// Kind selects a deposit or a withdrawal.
+	Kind OpKind
+	// Amount must be positive.
+	Amount int64
+}
+
+// BatchBalance is implemented by balances that can apply several
+// operations as one atomic unit.
+type BatchBalance interface {
+	Balance
+	// ApplyBatch applies ops in order, all or nothing. Every intermediate
+	// balance must respect the account's Limits; if any op fails, nothing
+	// is applied and the error names the failing op and wraps its cause,
+	// such as *InsufficientFundsError, ErrInvalidAmount, or ErrInvalidOp.
+	// An empty batch is a no-op.
+	ApplyBatch(ops []Op) error
+}
+
+// ApplyBatch applies ops to b as one atomic unit, returning
+// ErrBatchUnsupported if b does not implement BatchBalance. Lock-based
+// implementations validate and commit the batch under their lock; CAS-based
+// ones validate it against a loaded value and commit the result with a
+// single CAS, re-validating on contention. See each implementation's
+// documentation for its guarantee.
+func ApplyBatch(b Balance, ops []Op) error {
+	bb, ok := b.(BatchBalance)
+	if !ok {
+		return ErrBatchUnsupported
+	}
+	return bb.ApplyBatch(ops)
+}
+
+// BatchCounting selects how ApplyBatch advances TransactionCount.
+type BatchCounting int
+
+const (
+	// CountPerOp advances TransactionCount once for every op in the batch,
+	// as if each had been applied on its own. It is the default.
+	CountPerOp BatchCounting = iota
+	// CountPerBatch advances TransactionCount once per committed batch.
+	CountPerBatch
+)
+
+// Transactions returns how much a committed batch of n ops advances
+// TransactionCount.
+func (c BatchCounting) Transactions(n int) int64 {
+	if c == CountPerBatch {
+		return 1
+	}
+	return int64(n)
+}
+
+// Apply returns the balance left after applying ops to current in order,
+// or an error wrapping the first op that is invalid or would take the
+// balance outside l. Implementations call it inside their critical section
+// and commit the result as a single update.
+func (l Limits) Apply(current int64, ops []Op) (int64, error) {
+	next := current
+	for i, op := range ops {
+		var err error
+		switch {
+		case op.Amount <= 0:
+			err = ErrInvalidAmount
+		case op.Kind == OpAdd:
+			next, err = l.Deposit(next, op.Amount)
+		case op.Kind == OpSubtract:
+			next, err = l.Withdraw(next, op.Amount)
+		default:
+			err = ErrInvalidOp
+		}
+		if err != nil {
+			return current, fmt.Errorf("batch op %d (%v %d): %w", i, op.Kind, op.Amount, err)
+		}
+	}
+	return next, nil
+}
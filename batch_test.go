@@ -0,0 +1,203 @@
+package balance_test
+
+import (
+	"errors"
+	"math/rand/v2"
+	"sync"
+	"testing"
+
+	balance "github.com/madflojo/atomics-v-rwmutex-examples"
+	"github.com/madflojo/atomics-v-rwmutex-examples/balancetest"
+)
+
+func TestApplyBatch(t *testing.T) {
+	for _, impl := range balance.Implementations() {
+		impl := impl
+		t.Run(impl.Name, func(t *testing.T) {
+			t.Run("applies ops in order", func(t *testing.T) {
+				acct := balancetest.New(t, impl)
+				acct.Add(10)
+
+				// The withdrawal is only covered by the deposit before it.
+				ops := []balance.Op{
+					{Kind: balance.OpAdd, Amount: 90},
+					{Kind: balance.OpSubtract, Amount: 100},
+					{Kind: balance.OpAdd, Amount: 5},
+				}
+				if err := balance.ApplyBatch(acct, ops); err != nil {
+					t.Fatalf("unexpected batch error: %v", err)
+				}
+				if got := acct.Balance(); got != 5 {
+					t.Fatalf("balance mismatch, got %d want 5", got)
+				}
+				if impl.Traits.TracksMetadata {
+					if got := acct.TransactionCount(); got != 4 {
+						t.Fatalf("transaction count mismatch, got %d want 4", got)
+					}
+				}
+			})
+
+			t.Run("all or nothing", func(t *testing.T) {
+				acct := balancetest.New(t, impl)
+				acct.Add(100)
+				before := acct.Snapshot()
+
+				// The fee fits, but fee plus charge does not.
+				ops := []balance.Op{
+					{Kind: balance.OpSubtract, Amount: 5},
+					{Kind: balance.OpSubtract, Amount: 96},
+				}
+				err := balance.ApplyBatch(acct, ops)
+				var insufficient *balance.InsufficientFundsError
+				if !errors.As(err, &insufficient) {
+					t.Fatalf("expected *InsufficientFundsError, got %v", err)
+				}
+				if insufficient.Requested != 96 || insufficient.Available != 95 {
+					t.Fatalf("unexpected error details: %+v", insufficient)
+				}
+				if got := acct.Snapshot(); got != before {
+					t.Fatalf("failed batch changed state, got %+v want %+v", got, before)
+				}
+			})
+
+			t.Run("rejects invalid ops", func(t *testing.T) {
+				acct := balancetest.New(t, impl)
+				acct.Add(100)
+				before := acct.Snapshot()
+
+				tests := []struct {
+					name string
+					op   balance.Op
+					want error
+				}{
+					{name: "zero amount", op: balance.Op{Kind: balance.OpAdd}, want: balance.ErrInvalidAmount},
+					{name: "negative amount", op: balance.Op{Kind: balance.OpSubtract, Amount: -1}, want: balance.ErrInvalidAmount},
+					{name: "unknown kind", op: balance.Op{Amount: 1}, want: balance.ErrInvalidOp},
+				}
+				for _, tt := range tests {
+					ops := []balance.Op{{Kind: balance.OpAdd, Amount: 1}, tt.op}
+					if err := balance.ApplyBatch(acct, ops); !errors.Is(err, tt.want) {
+						t.Fatalf("%s: expected %v, got %v", tt.name, tt.want, err)
+					}
+				}
+				if err := balance.ApplyBatch(acct, nil); err != nil {
+					t.Fatalf("empty batch: unexpected error %v", err)
+				}
+				if got := acct.Snapshot(); got != before {
+					t.Fatalf("rejected batches changed state, got %+v want %+v", got, before)
+				}
+			})
+
+			if impl.NewWithOptions != nil {
+				t.Run("respects limits", func(t *testing.T) {
+					acct := balancetest.New(t, impl, balance.WithLimits(balance.Limits{Overdraft: 50, Ceiling: 100}))
+
+					// The deposit would pass the ceiling on its own, so
+					// the withdrawal has to come first.
+					ops := []balance.Op{
+						{Kind: balance.OpSubtract, Amount: 50},
+						{Kind: balance.OpAdd, Amount: 150},
+					}
+					if err := balance.ApplyBatch(acct, ops); err != nil {
+						t.Fatalf("unexpected batch error: %v", err)
+					}
+					if got := acct.Balance(); got != 100 {
+						t.Fatalf("balance mismatch, got %d want 100", got)
+					}
+
+					err := balance.ApplyBatch(acct, []balance.Op{
+						{Kind: balance.OpSubtract, Amount: 10},
+						{Kind: balance.OpAdd, Amount: 11},
+					})
+					if !errors.Is(err, balance.ErrCeilingExceeded) {
+						t.Fatalf("expected ErrCeilingExceeded, got %v", err)
+					}
+					if got := acct.Balance(); got != 100 {
+						t.Fatalf("rejected batch changed balance, got %d want 100", got)
+					}
+				})
+			}
+
+			if impl.Traits.TracksMetadata && impl.NewWithOptions != nil {
+				t.Run("counts once per batch", func(t *testing.T) {
+					acct := balancetest.New(t, impl, balance.WithBatchCounting(balance.CountPerBatch))
+					ops := []balance.Op{
+						{Kind: balance.OpAdd, Amount: 10},
+						{Kind: balance.OpSubtract, Amount: 3},
+						{Kind: balance.OpSubtract, Amount: 2},
+					}
+					if err := balance.ApplyBatch(acct, ops); err != nil {
+						t.Fatalf("unexpected batch error: %v", err)
+					}
+					if got := acct.TransactionCount(); got != 1 {
+						t.Fatalf("transaction count mismatch, got %d want 1", got)
+					}
+					if got := acct.Balance(); got != 5 {
+						t.Fatalf("balance mismatch, got %d want 5", got)
+					}
+				})
+			}
+
+			if !impl.Traits.KnownBuggy {
+				t.Run("concurrent batches never overdraw", func(t *testing.T) {
+					const (
+						workers = 8
+						iters   = 200
+						deposit = 1_000
+					)
+
+					acct := balancetest.New(t, impl)
+					acct.Add(deposit)
+
+					// Every batch is a net withdrawal of 10, so at most
+					// deposit/10 can commit.
+					var (
+						wg        sync.WaitGroup
+						mu        sync.Mutex
+						committed int64
+					)
+					for w := 0; w < workers; w++ {
+						wg.Add(1)
+						go func() {
+							defer wg.Done()
+							for i := 0; i < iters; i++ {
+								fee := 1 + rand.Int64N(9)
+								ops := []balance.Op{
+									{Kind: balance.OpSubtract, Amount: fee},
+									{Kind: balance.OpSubtract, Amount: 10 - fee + 5},
+									{Kind: balance.OpAdd, Amount: 5},
+								}
+								if err := balance.ApplyBatch(acct, ops); err == nil {
+									mu.Lock()
+									committed++
+									mu.Unlock()
+								} else if !errors.Is(err, balance.ErrInsufficientFunds) {
+									t.Errorf("unexpected batch error: %v", err)
+									return
+								}
+							}
+						}()
+					}
+					wg.Wait()
+
+					want := deposit - committed*10
+					if got := acct.Balance(); got != want || got < 0 {
+						t.Fatalf("balance mismatch, got %d want %d after %d batches", got, want, committed)
+					}
+					if impl.Traits.TracksMetadata {
+						if got := acct.TransactionCount(); got != 1+committed*3 {
+							t.Fatalf("transaction count mismatch, got %d want %d", got, 1+committed*3)
+						}
+					}
+				})
+			}
+		})
+	}
+
+	t.Run("unsupported balance", func(t *testing.T) {
+		err := balance.ApplyBatch(&stubBalance{}, []balance.Op{{Kind: balance.OpAdd, Amount: 1}})
+		if !errors.Is(err, balance.ErrBatchUnsupported) {
+			t.Fatalf("expected ErrBatchUnsupported, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,19 @@
+package balance
+
+import "time"
+
+// Clock supplies the timestamps recorded in LastUpdated, in nanoseconds
+// since the Unix epoch.
+type Clock interface {
+	Now() int64
+}
+
+// ClockFunc adapts an ordinary function to the Clock interface.
+type ClockFunc func() int64
+
+// Now calls f.
+func (f ClockFunc) Now() int64 { return f() }
+
+// SystemClock reads the wall clock via time.Now. It is the default for
+// every implementation.
+var SystemClock Clock = ClockFunc(func() int64 { return time.Now().UnixNano() })
@@ -0,0 +1,184 @@
+package balance_test
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	balance "github.com/madflojo/atomics-v-rwmutex-examples"
+	"github.com/madflojo/atomics-v-rwmutex-examples/balancetest"
+)
+
+func TestClock(t *testing.T) {
+	for _, impl := range balance.Implementations() {
+		impl := impl
+		t.Run(impl.Name, func(t *testing.T) {
+			if !impl.Traits.TracksMetadata {
+				t.Skip("implementation does not track metadata")
+			}
+			if impl.NewWithOptions == nil {
+				t.Fatalf("implementation tracks metadata but does not accept a clock")
+			}
+
+			clock := balancetest.NewManualClock(1_000)
+			acct := balancetest.New(t, impl, balance.WithClock(clock))
+
+			acct.Add(100)
+			if got := acct.LastUpdated(); got != 1_000 {
+				t.Fatalf("add: last updated mismatch, got %d want %d", got, 1_000)
+			}
+
+			now := clock.Advance(time.Microsecond)
+			if err := acct.Subtract(40); err != nil {
+				t.Fatalf("unexpected subtract error: %v", err)
+			}
+			if got := acct.LastUpdated(); got != now {
+				t.Fatalf("subtract: last updated mismatch, got %d want %d", got, now)
+			}
+
+			clock.Advance(time.Microsecond)
+			if err := acct.Subtract(1_000); err == nil {
+				t.Fatalf("expected insufficient funds error")
+			}
+			if got := acct.LastUpdated(); got != now {
+				t.Fatalf("failed subtract moved last updated, got %d want %d", got, now)
+			}
+
+			now = clock.Advance(time.Microsecond)
+			if err := acct.(balance.CheckedBalance).AddChecked(5); err != nil {
+				t.Fatalf("unexpected add error: %v", err)
+			}
+			if got := acct.LastUpdated(); got != now {
+				t.Fatalf("checked add: last updated mismatch, got %d want %d", got, now)
+			}
+
+			// Each side of a transfer is stamped by its own clock.
+			dstClock := balancetest.NewManualClock(5_000)
+			dst := balancetest.New(t, impl, balance.WithClock(dstClock))
+			now = clock.Advance(time.Microsecond)
+			if err := balance.Transfer(acct, dst, 10); err != nil {
+				t.Fatalf("unexpected transfer error: %v", err)
+			}
+			if got := acct.LastUpdated(); got != now {
+				t.Fatalf("transfer source: last updated mismatch, got %d want %d", got, now)
+			}
+			if got, want := dst.LastUpdated(), dstClock.Now(); got != want {
+				t.Fatalf("transfer destination: last updated mismatch, got %d want %d", got, want)
+			}
+
+			if snap := acct.Snapshot(); snap.LastUpdated != now {
+				t.Fatalf("snapshot: last updated mismatch, got %d want %d", snap.LastUpdated, now)
+			}
+		})
+	}
+}
+
+func TestLastUpdatedMonotonic(t *testing.T) {
+	const (
+		writers    = 8
+		iterations = 2_000
+	)
+
+	for _, impl := range balance.Implementations() {
+		impl := impl
+		t.Run(impl.Name, func(t *testing.T) {
+			if !impl.Traits.TracksMetadata {
+				t.Skip("implementation does not track metadata")
+			}
+
+			// Every reading is unique and later than the one before, so a
+			// timestamp that goes backwards or lags is always detectable.
+			// Yielding after the read widens the window between reading the
+			// clock and storing the timestamp, where stale writes happen.
+			var ticks atomic.Int64
+			clock := balance.ClockFunc(func() int64 {
+				now := ticks.Add(1)
+				runtime.Gosched()
+				return now
+			})
+			acct := balancetest.New(t, impl, balance.WithClock(clock))
+			checked := acct.(balance.CheckedBalance)
+
+			var (
+				wg       sync.WaitGroup
+				stop     = make(chan struct{})
+				failOnce sync.Once
+				failure  string
+			)
+			fail := func(format string, args ...any) {
+				failOnce.Do(func() { failure = fmt.Sprintf(format, args...) })
+			}
+
+			readerDone := make(chan struct{})
+			go func() {
+				defer close(readerDone)
+				var prev int64
+				for {
+					select {
+					case <-stop:
+						return
+					default:
+					}
+					cur := acct.LastUpdated()
+					runtime.Gosched()
+					if cur < prev {
+						fail("reader saw last updated go backwards: %d after %d", cur, prev)
+						return
+					}
+					prev = cur
+				}
+			}()
+
+			for w := 0; w < writers; w++ {
+				wg.Add(1)
+				go func(w int) {
+					defer wg.Done()
+					var prev int64
+					for i := 0; i < iterations; i++ {
+						before := clock.Now()
+						if i%2 == 0 {
+							acct.Add(1)
+						} else if err := checked.AddChecked(1); err != nil {
+							fail("writer %d: unexpected add error: %v", w, err)
+							return
+						}
+
+						cur := acct.LastUpdated()
+						if cur <= before {
+							fail("writer %d: last updated %d lags its completed mutation started after %d", w, cur, before)
+							return
+						}
+						if cur < prev {
+							fail("writer %d: last updated went backwards: %d after %d", w, cur, prev)
+							return
+						}
+						prev = cur
+					}
+				}(w)
+			}
+
+			wg.Wait()
+			close(stop)
+			<-readerDone
+
+			if failure != "" {
+				t.Fatal(failure)
+			}
+		})
+	}
+}
+
+func TestWithNilClockKeepsSystemClock(t *testing.T) {
+	opts := balance.NewOptions(balance.WithClock(nil), nil)
+	if opts.Clock == nil {
+		t.Fatalf("expected the system clock, got nil")
+	}
+
+	before := time.Now().UnixNano()
+	if got := opts.Clock.Now(); got < before {
+		t.Fatalf("system clock went backwards, got %d want >= %d", got, before)
+	}
+}
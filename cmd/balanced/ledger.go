@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+
+	balance "github.com/madflojo/atomics-v-rwmutex-examples"
+)
+
+// maxBody bounds request bodies; an amount needs a few dozen bytes.
+const maxBody = 1 << 10
+
+// ledger is the HTTP handler. It owns one Balance per account, built by
+// factory on the account's first deposit, and refuses to open more than
+// maxAccounts of them unless maxAccounts is zero.
+type ledger struct {
+	factory     balance.Factory
+	maxAccounts int
+	mux         *http.ServeMux
+
+	mu       sync.RWMutex
+	accounts map[string]balance.Balance
+}
+
+// amountRequest is the body of a deposit or withdrawal.
+type amountRequest struct {
+	Amount int64 `json:"amount"`
+}
+
+// accountResponse reports an account's balance. After a deposit or
+// withdrawal it is read once the operation has completed, so a concurrent
+// request on the same account may already be reflected in it.
+type accountResponse struct {
+	ID      string `json:"id"`
+	Balance int64  `json:"balance"`
+}
+
+// errorResponse reports a failed request. Requested and Available are set
+// for insufficient funds.
+type errorResponse struct {
+	Error     string `json:"error"`
+	Requested int64  `json:"requested,omitempty"`
+	Available *int64 `json:"available,omitempty"`
+}
+
+// newLedger returns a ledger with no accounts that opens at most
+// maxAccounts, or any number if maxAccounts is zero.
+func newLedger(factory balance.Factory, maxAccounts int) *ledger {
+	l := &ledger{
+		factory:     factory,
+		maxAccounts: maxAccounts,
+		mux:         http.NewServeMux(),
+		accounts:    make(map[string]balance.Balance),
+	}
+	l.mux.HandleFunc("GET /accounts/{id}", l.read)
+	l.mux.HandleFunc("GET /accounts/{id}/snapshot", l.snapshot)
+	l.mux.HandleFunc("POST /accounts/{id}/deposit", l.deposit)
+	l.mux.HandleFunc("POST /accounts/{id}/withdraw", l.withdraw)
+	return l
+}
+
+// ServeHTTP routes requests to the account endpoints.
+func (l *ledger) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	l.mux.ServeHTTP(w, r)
+}
+
+// Close closes every account whose implementation needs it, such as the
+// actor's owner goroutine.
+func (l *ledger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var err error
+	for _, acct := range l.accounts {
+		if c, ok := acct.(io.Closer); ok {
+			err = errors.Join(err, c.Close())
+		}
+	}
+	return err
+}
+
+// read reports an account's balance.
+func (l *ledger) read(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	acct, ok := l.account(id)
+	if !ok {
+		writeError(w, http.StatusNotFound, errAccountNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, accountResponse{ID: id, Balance: acct.Balance()})
+}
+
+// snapshot reports an account's Snapshot in its versioned JSON form.
+func (l *ledger) snapshot(w http.ResponseWriter, r *http.Request) {
+	acct, ok := l.account(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, errAccountNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, acct.Snapshot())
+}
+
+// deposit adds to an account, creating it on its first successful deposit.
+func (l *ledger) deposit(w http.ResponseWriter, r *http.Request) {
+	amount, ok := readAmount(w, r)
+	if !ok {
+		return
+	}
+
+	id := r.PathValue("id")
+	acct, err := l.depositTo(id, amount)
+	if err != nil {
+		writeError(w, statusFor(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, accountResponse{ID: id, Balance: acct.Balance()})
+}
+
+// withdraw subtracts from an existing account.
+func (l *ledger) withdraw(w http.ResponseWriter, r *http.Request) {
+	amount, ok := readAmount(w, r)
+	if !ok {
+		return
+	}
+
+	id := r.PathValue("id")
+	acct, ok := l.account(id)
+	if !ok {
+		writeError(w, http.StatusNotFound, errAccountNotFound)
+		return
+	}
+	if err := acct.Subtract(amount); err != nil {
+		writeError(w, statusFor(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, accountResponse{ID: id, Balance: acct.Balance()})
+}
+
+// account returns the account named id, if it exists.
+func (l *ledger) account(id string) (balance.Balance, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	acct, ok := l.accounts[id]
+	return acct, ok
+}
+
+// depositTo deposits amount into the account named id. An account that
+// does not exist yet is only added once its first deposit succeeds, so a
+// rejected deposit never creates one, and only while the ledger has room
+// for it.
+func (l *ledger) depositTo(id string, amount int64) (balance.Balance, error) {
+	if acct, ok := l.account(id); ok {
+		return acct, balance.AddChecked(acct, amount)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if acct, ok := l.accounts[id]; ok {
+		return acct, balance.AddChecked(acct, amount)
+	}
+	if l.maxAccounts > 0 && len(l.accounts) >= l.maxAccounts {
+		return nil, errTooManyAccounts
+	}
+	acct := l.factory()
+	if err := balance.AddChecked(acct, amount); err != nil {
+		if c, ok := acct.(io.Closer); ok {
+			_ = c.Close()
+		}
+		return nil, err
+	}
+	l.accounts[id] = acct
+	return acct, nil
+}
+
+var (
+	// errAccountNotFound is reported for reads and withdrawals against an
+	// account that has never received a deposit.
+	errAccountNotFound = errors.New("account not found")
+	// errTooManyAccounts is reported for a first deposit into a new
+	// account once the ledger holds its maximum number of accounts.
+	errTooManyAccounts = errors.New("too many accounts")
+)
+
+// readAmount decodes the request body, writing a 400 and returning false
+// if it is malformed.
+func readAmount(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBody))
+	dec.DisallowUnknownFields()
+	var req amountRequest
+	if err := dec.Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, errors.New("invalid request body: "+err.Error()))
+		return 0, false
+	}
+	return req.Amount, true
+}
+
+// statusFor maps a Balance error to an HTTP status.
+func statusFor(err error) int {
+	switch {
+	case errors.Is(err, balance.ErrInvalidAmount):
+		return http.StatusBadRequest
+	case errors.Is(err, balance.ErrInsufficientFunds),
+		errors.Is(err, balance.ErrOverflow),
+		errors.Is(err, balance.ErrCeilingExceeded):
+		return http.StatusConflict
+	case errors.Is(err, balance.ErrClosed):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, errTooManyAccounts):
+		return http.StatusInsufficientStorage
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// writeError writes err as an errorResponse.
+func writeError(w http.ResponseWriter, status int, err error) {
+	resp := errorResponse{Error: err.Error()}
+	var insufficient *balance.InsufficientFundsError
+	if errors.As(err, &insufficient) {
+		resp.Requested = insufficient.Requested
+		resp.Available = &insufficient.Available
+	}
+	writeJSON(w, status, resp)
+}
+
+// writeJSON writes v with status.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
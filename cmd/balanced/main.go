@@ -0,0 +1,121 @@
+/*
+Command balanced serves balances over HTTP as a tiny JSON ledger:
+
+	balanced -addr :8080 -impl Mutex_Balance_full
+
+Accounts are named by the path and created by their first deposit:
+
+	POST /accounts/{id}/deposit   {"amount": 100}  -> {"id": "alice", "balance": 100}
+	POST /accounts/{id}/withdraw  {"amount": 30}   -> {"id": "alice", "balance": 70}
+	GET  /accounts/{id}                            -> {"id": "alice", "balance": 70}
+	GET  /accounts/{id}/snapshot                   -> {"version": 1, "value": 70, ...}
+
+The balance in a deposit or withdrawal response is read after the
+operation completes, so under concurrent requests to the same account it
+can include theirs too. A withdrawal that would overdraw the account is
+refused with 409 Conflict, and invalid amounts with 400 Bad Request. Once
+-max-accounts accounts exist, a deposit that would open another is refused
+with 507 Insufficient Storage. On SIGINT or SIGTERM the server
+stops accepting connections and waits for in-flight requests before
+exiting. Run with -list to see the implementations -impl accepts.
+*/
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	balance "github.com/madflojo/atomics-v-rwmutex-examples"
+	_ "github.com/madflojo/atomics-v-rwmutex-examples/implementations/all"
+)
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := run(ctx, os.Args[1:], os.Stdout); err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintln(os.Stderr, "balanced:", err)
+		}
+		os.Exit(2)
+	}
+}
+
+// run parses args, serves until ctx is done, then shuts down gracefully.
+func run(ctx context.Context, args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("balanced", flag.ContinueOnError)
+	addr := fs.String("addr", ":8080", "listen address")
+	name := fs.String("impl", "Mutex_Balance_full", "backing Balance implementation")
+	list := fs.Bool("list", false, "list implementations and exit")
+	maxAccounts := fs.Int("max-accounts", 10_000, "most accounts to open, or 0 for no limit")
+	grace := fs.Duration("shutdown-timeout", 10*time.Second, "how long to wait for in-flight requests on shutdown")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *maxAccounts < 0 {
+		return fmt.Errorf("-max-accounts must not be negative, got %d", *maxAccounts)
+	}
+
+	if *list {
+		for _, impl := range balance.Implementations() {
+			fmt.Fprintln(stdout, impl.Name)
+		}
+		return nil
+	}
+
+	impl, ok := balance.Lookup(*name)
+	if !ok {
+		return fmt.Errorf("unknown implementation %q; run with -list to see them", *name)
+	}
+	if impl.Traits.KnownBuggy {
+		fmt.Fprintf(stdout, "warning: %s can overdraw accounts under concurrent withdrawals\n", impl.Name)
+	}
+
+	ln, err := net.Listen("tcp", *addr)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "serving %s on %s\n", impl.Name, ln.Addr())
+
+	ledger := newLedger(impl.New, *maxAccounts)
+	defer func() { _ = ledger.Close() }()
+	return serve(ctx, ln, ledger, *grace)
+}
+
+// serve runs h on ln until ctx is done, then stops accepting connections
+// and waits up to grace for in-flight requests to finish.
+func serve(ctx context.Context, ln net.Listener, h http.Handler, grace time.Duration) error {
+	srv := &http.Server{
+		Handler:           h,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	errc := make(chan error, 1)
+	go func() { errc <- srv.Serve(ln) }()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("shutdown: %w", err)
+	}
+	if err := <-errc; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	balance "github.com/madflojo/atomics-v-rwmutex-examples"
+)
+
+func TestLedger(t *testing.T) {
+	impl, _ := balance.Lookup("Mutex_Balance_full")
+	srv := newTestServer(t, impl)
+
+	if code, _ := get(t, srv, "/accounts/alice"); code != http.StatusNotFound {
+		t.Fatalf("expected 404 for a new account, got %d", code)
+	}
+	if code, _ := post(t, srv, "/accounts/alice/withdraw", 1); code != http.StatusNotFound {
+		t.Fatalf("expected 404 withdrawing from a new account, got %d", code)
+	}
+
+	for _, amount := range []int64{0, -5} {
+		if code, _ := post(t, srv, "/accounts/ghost/deposit", amount); code != http.StatusBadRequest {
+			t.Fatalf("expected 400 for amount %d, got %d", amount, code)
+		}
+	}
+	if code, body := get(t, srv, "/accounts/ghost"); code != http.StatusNotFound {
+		t.Fatalf("expected a rejected deposit not to create the account, got %d %s", code, body)
+	}
+
+	code, body := post(t, srv, "/accounts/alice/deposit", 100)
+	if code != http.StatusOK || decode[accountResponse](t, body).Balance != 100 {
+		t.Fatalf("expected a 100 deposit to succeed, got %d %s", code, body)
+	}
+	code, body = post(t, srv, "/accounts/alice/withdraw", 30)
+	if code != http.StatusOK || decode[accountResponse](t, body).Balance != 70 {
+		t.Fatalf("expected a 30 withdrawal to succeed, got %d %s", code, body)
+	}
+
+	code, body = post(t, srv, "/accounts/alice/withdraw", 500)
+	if code != http.StatusConflict {
+		t.Fatalf("expected 409 for insufficient funds, got %d %s", code, body)
+	}
+	if resp := decode[errorResponse](t, body); resp.Requested != 500 || resp.Available == nil || *resp.Available != 70 {
+		t.Fatalf("expected requested and available amounts, got %s", body)
+	}
+
+	for _, amount := range []int64{0, -5} {
+		if code, body := post(t, srv, "/accounts/alice/deposit", amount); code != http.StatusBadRequest {
+			t.Fatalf("expected 400 for amount %d, got %d %s", amount, code, body)
+		}
+	}
+	resp, err := srv.Client().Post(srv.URL+"/accounts/alice/deposit", "application/json", strings.NewReader(`{"amount":"lots"}`))
+	if err != nil {
+		t.Fatalf("unexpected request error: %v", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected 400 for a malformed body, got %d", resp.StatusCode)
+	}
+
+	code, body = get(t, srv, "/accounts/alice/snapshot")
+	if code != http.StatusOK {
+		t.Fatalf("expected 200 for a snapshot, got %d", code)
+	}
+	var snap balance.Snapshot
+	if err := json.Unmarshal(body, &snap); err != nil {
+		t.Fatalf("unexpected snapshot decode error: %v", err)
+	}
+	if snap.Value != 70 || snap.TransactionCount != 2 {
+		t.Fatalf("unexpected snapshot %+v", snap)
+	}
+
+	if code, _ := get(t, srv, "/accounts/alice/deposit"); code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected 405 for GET on deposit, got %d", code)
+	}
+}
+
+func TestLedgerAccountLimit(t *testing.T) {
+	impl, _ := balance.Lookup("Mutex_Balance_full")
+	ledger := newLedger(impl.New, 2)
+	srv := httptest.NewServer(ledger)
+	t.Cleanup(func() {
+		srv.Close()
+		_ = ledger.Close()
+	})
+
+	for _, id := range []string{"alice", "bob"} {
+		if code, body := post(t, srv, "/accounts/"+id+"/deposit", 10); code != http.StatusOK {
+			t.Fatalf("expected a deposit to open %s, got %d %s", id, code, body)
+		}
+	}
+	if code, body := post(t, srv, "/accounts/carol/deposit", 10); code != http.StatusInsufficientStorage {
+		t.Fatalf("expected 507 for a third account, got %d %s", code, body)
+	}
+	if code, _ := get(t, srv, "/accounts/carol"); code != http.StatusNotFound {
+		t.Fatalf("expected the refused deposit not to create the account, got %d", code)
+	}
+	if code, body := post(t, srv, "/accounts/alice/deposit", 10); code != http.StatusOK {
+		t.Fatalf("expected existing accounts to keep taking deposits, got %d %s", code, body)
+	}
+}
+
+func TestLedgerConcurrentClients(t *testing.T) {
+	const (
+		clients  = 32
+		attempts = 8
+		funds    = 100
+	)
+
+	for _, impl := range balance.Implementations() {
+		impl := impl
+		if impl.Traits.KnownBuggy {
+			continue
+		}
+		t.Run(impl.Name, func(t *testing.T) {
+			srv := newTestServer(t, impl)
+			if code, body := post(t, srv, "/accounts/shared/deposit", funds); code != http.StatusOK {
+				t.Fatalf("unexpected deposit failure: %d %s", code, body)
+			}
+
+			var succeeded, refused atomic.Int64
+			var wg sync.WaitGroup
+			for c := 0; c < clients; c++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for i := 0; i < attempts; i++ {
+						code, body := post(t, srv, "/accounts/shared/withdraw", 1)
+						switch code {
+						case http.StatusOK:
+							succeeded.Add(1)
+							if got := decode[accountResponse](t, body).Balance; got < 0 {
+								t.Errorf("balance went negative: %d", got)
+							}
+						case http.StatusConflict:
+							refused.Add(1)
+						default:
+							t.Errorf("unexpected status %d: %s", code, body)
+						}
+					}
+				}()
+			}
+			wg.Wait()
+
+			if got := succeeded.Load(); got != funds {
+				t.Fatalf("expected exactly %d withdrawals to succeed, got %d", funds, got)
+			}
+			if got := refused.Load(); got != clients*attempts-funds {
+				t.Fatalf("expected %d refusals, got %d", clients*attempts-funds, got)
+			}
+			_, body := get(t, srv, "/accounts/shared")
+			if got := decode[accountResponse](t, body).Balance; got != 0 {
+				t.Fatalf("expected the account drained to 0, got %d", got)
+			}
+		})
+	}
+}
+
+func TestServeShutsDownGracefully(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected listen error: %v", err)
+	}
+
+	// The handler holds a request open until shutdown has begun, so the
+	// response only arrives if Shutdown waits for it.
+	started, finish := make(chan struct{}), make(chan struct{})
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-finish
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- serve(ctx, ln, h, 5*time.Second) }()
+
+	url := "http://" + ln.Addr().String()
+	respc := make(chan *http.Response, 1)
+	go func() {
+		resp, err := http.Get(url)
+		if err != nil {
+			t.Errorf("in-flight request failed: %v", err)
+			respc <- nil
+			return
+		}
+		respc <- resp
+	}()
+	<-started
+	cancel()
+
+	select {
+	case err := <-done:
+		t.Fatalf("serve returned before the in-flight request finished: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(finish)
+
+	if resp := <-respc; resp != nil {
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusNoContent {
+			t.Fatalf("expected 204 from the in-flight request, got %d", resp.StatusCode)
+		}
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("unexpected serve error: %v", err)
+	}
+	if _, err := http.Get(url); err == nil {
+		t.Fatalf("expected connections to be refused after shutdown")
+	}
+}
+
+func TestRunFlags(t *testing.T) {
+	var out bytes.Buffer
+	if err := run(context.Background(), []string{"-list"}, &out); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, impl := range balance.Implementations() {
+		if !strings.Contains(out.String(), impl.Name+"\n") {
+			t.Fatalf("expected %s in -list output:\n%s", impl.Name, out.String())
+		}
+	}
+
+	if err := run(context.Background(), []string{"-impl", "nope"}, &out); err == nil {
+		t.Fatalf("expected an unknown implementation to fail")
+	}
+	if err := run(context.Background(), []string{"-max-accounts", "-1"}, &out); err == nil {
+		t.Fatalf("expected a negative account limit to fail")
+	}
+}
+
+// newTestServer serves a ledger backed by impl for the rest of the test.
+func newTestServer(t *testing.T, impl balance.Implementation) *httptest.Server {
+	t.Helper()
+	ledger := newLedger(impl.New, 0)
+	srv := httptest.NewServer(ledger)
+	t.Cleanup(func() {
+		srv.Close()
+		_ = ledger.Close()
+	})
+	return srv
+}
+
+// get issues a GET and returns the status and body.
+func get(t *testing.T, srv *httptest.Server, path string) (int, []byte) {
+	t.Helper()
+	return do(t, srv, http.MethodGet, path, "")
+}
+
+// post issues a POST with an amount body and returns the status and body.
+func post(t *testing.T, srv *httptest.Server, path string, amount int64) (int, []byte) {
+	t.Helper()
+	return do(t, srv, http.MethodPost, path, fmt.Sprintf(`{"amount":%d}`, amount))
+}
+
+// do issues a request and returns the status and body.
+func do(t *testing.T, srv *httptest.Server, method, path, body string) (int, []byte) {
+	t.Helper()
+	req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Errorf("unexpected request error: %v", err)
+		return 0, nil
+	}
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Errorf("unexpected response error: %v", err)
+		return 0, nil
+	}
+	defer resp.Body.Close()
+
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(resp.Body); err != nil {
+		t.Errorf("unexpected read error: %v", err)
+	}
+	return resp.StatusCode, buf.Bytes()
+}
+
+// decode unmarshals body into a T.
+func decode[T any](t *testing.T, body []byte) T {
+	t.Helper()
+	var v T
+	if err := json.Unmarshal(body, &v); err != nil {
+		t.Errorf("unexpected decode error: %v: %s", err, body)
+	}
+	return v
+}
@@ -0,0 +1,160 @@
+package balance_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	balance "github.com/madflojo/atomics-v-rwmutex-examples"
+	"github.com/madflojo/atomics-v-rwmutex-examples/balancetest"
+)
+
+func TestContextBalance(t *testing.T) {
+	for _, impl := range balance.Implementations() {
+		impl := impl
+		t.Run(impl.Name, func(t *testing.T) {
+			acct, ok := balancetest.New(t, impl).(balance.ContextBalance)
+			if !ok {
+				t.Fatalf("implementation does not satisfy ContextBalance")
+			}
+			ctx := context.Background()
+
+			t.Run("live context", func(t *testing.T) {
+				if err := acct.AddContext(ctx, 100); err != nil {
+					t.Fatalf("unexpected add error: %v", err)
+				}
+				if err := acct.SubtractContext(ctx, 40); err != nil {
+					t.Fatalf("unexpected subtract error: %v", err)
+				}
+				if acct.Balance() != 60 {
+					t.Fatalf("balance mismatch, got %d want 60", acct.Balance())
+				}
+				if err := acct.SubtractContext(ctx, 1_000); !errors.Is(err, balance.ErrInsufficientFunds) {
+					t.Fatalf("expected ErrInsufficientFunds, got %v", err)
+				}
+				if err := acct.AddContext(ctx, 0); !errors.Is(err, balance.ErrInvalidAmount) {
+					t.Fatalf("expected ErrInvalidAmount from add, got %v", err)
+				}
+				if err := acct.SubtractContext(ctx, -1); !errors.Is(err, balance.ErrInvalidAmount) {
+					t.Fatalf("expected ErrInvalidAmount from subtract, got %v", err)
+				}
+			})
+
+			t.Run("done context never mutates", func(t *testing.T) {
+				cancelled, cancel := context.WithCancel(ctx)
+				cancel()
+
+				before := acct.Snapshot()
+				if err := acct.AddContext(cancelled, 10); !errors.Is(err, context.Canceled) {
+					t.Fatalf("expected context.Canceled from add, got %v", err)
+				}
+				if err := acct.SubtractContext(cancelled, 10); !errors.Is(err, context.Canceled) {
+					t.Fatalf("expected context.Canceled from subtract, got %v", err)
+				}
+				if after := acct.Snapshot(); after != before {
+					t.Fatalf("cancelled operations changed state, got %+v want %+v", after, before)
+				}
+			})
+
+			t.Run("deadline while blocked", func(t *testing.T) {
+				if !impl.Traits.OrderedEvents || impl.NewWithOptions == nil {
+					t.Skip("lock-free: observers run after the CAS, so nothing can block the loop")
+				}
+
+				// The observer runs inside the critical section, so blocking
+				// it holds the lock, or the owner goroutine, while
+				// SubtractContext waits for it.
+				locked, release := make(chan struct{}), make(chan struct{})
+				var once sync.Once
+				block := balance.ObserverFunc(func(balance.Event) {
+					once.Do(func() {
+						close(locked)
+						<-release
+					})
+				})
+				acct := balancetest.New(t, impl, balance.WithObserver(block)).(balance.ContextBalance)
+
+				done := make(chan struct{})
+				go func() {
+					defer close(done)
+					acct.Add(100)
+				}()
+				<-locked
+
+				deadline, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+				defer cancel()
+				err := acct.SubtractContext(deadline, 10)
+				close(release)
+				<-done
+				if !errors.Is(err, context.DeadlineExceeded) {
+					t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+				}
+				if got := acct.Balance(); got != 100 {
+					t.Fatalf("expected the abandoned withdrawal to leave 100, got %d", got)
+				}
+				if impl.Traits.TracksMetadata && acct.TransactionCount() != 1 {
+					t.Fatalf("expected only the deposit to count, got %d", acct.TransactionCount())
+				}
+			})
+
+			t.Run("cancel under contention", func(t *testing.T) {
+				const workers = 16
+
+				// Fund far more than the workers can drain before cancel lands.
+				acct.Add(1 << 40)
+				start := acct.Balance()
+
+				runCtx, cancel := context.WithCancel(ctx)
+				var wg sync.WaitGroup
+				var applied int64
+				var unexpected atomic.Value
+
+				for w := 0; w < workers; w++ {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						for {
+							err := acct.SubtractContext(runCtx, 1)
+							switch {
+							case err == nil:
+								atomic.AddInt64(&applied, 1)
+							case errors.Is(err, context.Canceled):
+								return
+							default:
+								unexpected.Store(err)
+								return
+							}
+						}
+					}()
+				}
+
+				time.Sleep(10 * time.Millisecond)
+				cancel()
+
+				done := make(chan struct{})
+				go func() {
+					wg.Wait()
+					close(done)
+				}()
+				select {
+				case <-done:
+				case <-time.After(5 * time.Second):
+					t.Fatalf("workers did not stop after cancellation")
+				}
+
+				if err, _ := unexpected.Load().(error); err != nil {
+					t.Fatalf("unexpected subtract error: %v", err)
+				}
+				if impl.Traits.KnownBuggy {
+					return
+				}
+				if got, want := acct.Balance(), start-atomic.LoadInt64(&applied); got != want {
+					t.Fatalf("balance mismatch after cancellation, got %d want %d", got, want)
+				}
+			})
+		})
+	}
+}
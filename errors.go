@@ -0,0 +1,80 @@
+package balance
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrInsufficientFunds indicates a withdrawal would push the balance
+	// below its floor, zero unless configured with Limits. Implementations
+	// return an *InsufficientFundsError that matches it with errors.Is.
+	ErrInsufficientFunds = errors.New("insufficient funds")
+
+	// ErrInvalidAmount indicates a zero or negative amount was passed to an
+	// operation that only accepts positive amounts.
+	ErrInvalidAmount = errors.New("amount must be positive")
+
+	// ErrInvalidOp indicates a batched Op with an unknown Kind.
+	ErrInvalidOp = errors.New("invalid batch operation")
+
+	// ErrOverflow indicates a deposit would overflow int64.
+	ErrOverflow = errors.New("balance overflow")
+
+	// ErrCeilingExceeded indicates a deposit would push the balance above
+	// the ceiling configured with Limits.
+	ErrCeilingExceeded = errors.New("balance ceiling exceeded")
+
+	// ErrInvalidLimits indicates a Limits value that cannot be enforced,
+	// such as a negative overdraft or a ceiling below the floor.
+	ErrInvalidLimits = errors.New("invalid balance limits")
+
+	// ErrIncompatibleBalance indicates a transfer between two different
+	// implementations, which cannot be coordinated atomically.
+	ErrIncompatibleBalance = errors.New("incompatible balance implementations")
+
+	// ErrSameAccount indicates a transfer whose source and destination are
+	// the same account.
+	ErrSameAccount = errors.New("cannot transfer to the same account")
+
+	// ErrClosed indicates the balance was closed before the request was
+	// served.
+	ErrClosed = errors.New("balance closed")
+
+	// ErrTransferUnsupported indicates the source balance does not
+	// implement Transferer, or PairReader for ReadPair.
+	ErrTransferUnsupported = errors.New("balance does not support transfers")
+
+	// ErrBatchUnsupported indicates the balance does not implement
+	// BatchBalance.
+	ErrBatchUnsupported = errors.New("balance does not support batches")
+
+	// ErrInvalidSnapshot indicates serialized state that cannot be decoded,
+	// or that cannot be restored under the account's Limits.
+	ErrInvalidSnapshot = errors.New("invalid balance snapshot")
+
+	// ErrSnapshotVersion indicates serialized state written in a format
+	// version this package does not read.
+	ErrSnapshotVersion = errors.New("unsupported balance snapshot version")
+)
+
+// InsufficientFundsError reports a rejected withdrawal along with the
+// amounts involved. It matches ErrInsufficientFunds with errors.Is.
+type InsufficientFundsError struct {
+	// Requested is the amount the caller tried to withdraw.
+	Requested int64
+	// Available is how much could have been withdrawn when the withdrawal
+	// was rejected: the observed balance less the account's floor. With
+	// the default Limits it is the observed balance.
+	Available int64
+}
+
+// Error implements the error interface.
+func (e *InsufficientFundsError) Error() string {
+	return fmt.Sprintf("insufficient funds: requested %d, available %d", e.Requested, e.Available)
+}
+
+// Is reports whether target is ErrInsufficientFunds.
+func (e *InsufficientFundsError) Is(target error) bool {
+	return target == ErrInsufficientFunds
+}
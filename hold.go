@@ -0,0 +1,75 @@
+package balance
+
+import (
+	"math"
+
+	"github.com/madflojo/atomics-v-rwmutex-examples/internal/arith"
+)
+
+// Holder is implemented by balances that can reserve funds inside the same
+// lock, CAS, or owner goroutine that applies every other update. Held funds
+// stay part of Balance, so a ceiling still counts them, but withdrawals,
+// batches, transfers out, and further reservations may only spend the
+// available amount, Balance less Held. Reserving and settling are each one
+// atomic step, so funds are never missing from both amounts. Holds are not
+// part of a Snapshot.
+type Holder interface {
+	Balance
+
+	// Available returns Balance less Held, read as one consistent pair.
+	Available() int64
+
+	// Held returns the total currently reserved.
+	Held() int64
+
+	// Reserve moves amount from the available balance to the held one. It
+	// returns ErrInvalidAmount if amount is zero or negative and an
+	// *InsufficientFundsError if the available balance would fall below
+	// the floor.
+	Reserve(amount int64) error
+
+	// Settle ends a reservation of release, withdrawing capture of it from
+	// the balance and returning the rest to the available amount. It
+	// returns ErrInvalidAmount unless 0 <= capture <= release <= Held and
+	// release is positive. Settle never fails on limits: the withdrawn
+	// funds were already reserved and nothing is deposited.
+	Settle(release, capture int64) error
+}
+
+// Holding returns l with its minimum raised by held, so a withdrawal or
+// batch checked against it never spends reserved funds. The ceiling is
+// unchanged and still applies to the whole balance.
+func (l Limits) Holding(held int64) Limits {
+	if held == 0 {
+		return l
+	}
+	floor, ok := arith.Add(l.Min(), held)
+	if !ok {
+		floor = math.MaxInt64
+	}
+	return Limits{Floor: floor, Ceiling: l.Ceiling}
+}
+
+// Reserve returns held plus amount, or an *InsufficientFundsError if value
+// less the new held amount would fall below Min.
+func (l Limits) Reserve(value, held, amount int64) (int64, error) {
+	if _, err := l.Holding(held).Withdraw(value, amount); err != nil {
+		return held, err
+	}
+	next, ok := arith.Add(held, amount)
+	if !ok {
+		return held, ErrOverflow
+	}
+	return next, nil
+}
+
+// Settle returns value less capture and held less release for a hold being
+// settled, or ErrInvalidAmount unless 0 <= capture <= release <= held and
+// release is positive. The available amount never shrinks, so no limit can
+// be crossed.
+func Settle(value, held, release, capture int64) (int64, int64, error) {
+	if release <= 0 || capture < 0 || capture > release || release > held {
+		return value, held, ErrInvalidAmount
+	}
+	return value - capture, held - release, nil
+}
@@ -0,0 +1,23 @@
+/*
+Package holds adds card-style authorize, capture and release on top of any
+Balance:
+
+	acct := holds.Wrap(full.New(), 15*time.Minute)
+
+	id, err := acct.Hold(100)     // authorize: 100 leaves the available balance
+	err = acct.Capture(id, 80)    // settle 80, return the other 20
+	// or: err = acct.Release(id) // cancel, return all 100
+
+Wrap takes a balance.Holder, which every implementation except the two
+atomics/cas variants is. The Holder tracks held funds inside the same lock,
+CAS, or owner goroutine as the value, with the available balance being the
+value less what is held, so placing, capturing, and releasing a hold are
+each one atomic step and the funds are never missing from both. Limits
+apply to the whole value: a ceiling counts held funds, and releasing a hold
+never fails because nothing is deposited. The available balance inherits
+the implementation's guarantee: it never goes below the floor unless the
+implementation is known to be buggy. This package adds hold IDs and
+expiry on top. Balance and Snapshot report the whole value, held funds
+included; Available reports what can still be spent.
+*/
+package holds
@@ -0,0 +1,209 @@
+package holds
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+	"time"
+
+	balance "github.com/madflojo/atomics-v-rwmutex-examples"
+)
+
+var (
+	// ErrHoldNotFound is returned for a hold that never existed or was
+	// already captured, released, or expired.
+	ErrHoldNotFound = errors.New("hold not found")
+	// ErrCaptureExceedsHold is returned when a capture asks for more than
+	// the hold reserved. The hold is left untouched.
+	ErrCaptureExceedsHold = errors.New("capture exceeds held amount")
+)
+
+// HoldID identifies a hold. IDs are never reused by a Balance.
+type HoldID uint64
+
+// hold is an outstanding reservation.
+type hold struct {
+	id      HoldID
+	amount  int64
+	expires int64
+}
+
+// Balance wraps a balance.Holder with hold IDs and expiry. It still
+// satisfies balance.Balance. Balance and Snapshot report the whole value,
+// held funds included, as the wrapped Holder does; Available reports what
+// can still be spent.
+type Balance struct {
+	inner balance.Holder
+	clock balance.Clock
+	ttl   int64
+
+	// mu guards the bookkeeping below. The funds themselves are moved by
+	// the wrapped Holder, in one atomic step per hold.
+	mu     sync.Mutex
+	nextID HoldID
+	holds  map[HoldID]*list.Element
+	// order lists holds by expiry. Every hold has the same TTL, so this is
+	// also creation order.
+	order *list.List
+}
+
+// Wrap returns b with holds that expire ttl after they are placed. Options
+// such as balance.WithClock control how expiry is measured. Wrap panics if
+// ttl is not positive.
+func Wrap(b balance.Holder, ttl time.Duration, opts ...balance.Option) *Balance {
+	if ttl <= 0 {
+		panic("holds: Wrap called with non-positive ttl")
+	}
+
+	o := balance.NewOptions(opts...)
+	return &Balance{
+		inner: b,
+		clock: o.Clock,
+		ttl:   int64(ttl),
+		holds: make(map[HoldID]*list.Element),
+		order: list.New(),
+	}
+}
+
+// Unwrap returns the wrapped Holder.
+func (b *Balance) Unwrap() balance.Holder { return b.inner }
+
+// Balance returns the wrapped balance's value, which includes held funds.
+// Releasing an expired hold does not change it.
+func (b *Balance) Balance() int64 { return b.inner.Balance() }
+
+// TransactionCount returns the wrapped balance's transaction count. Placing
+// a hold and settling one each count as a mutation.
+func (b *Balance) TransactionCount() int64 { return b.inner.TransactionCount() }
+
+// LastUpdated returns the wrapped balance's last update timestamp.
+func (b *Balance) LastUpdated() int64 { return b.inner.LastUpdated() }
+
+// Snapshot returns the wrapped balance's snapshot after releasing any holds
+// that have expired, so its TransactionCount includes those releases. Its
+// Value includes held funds, like Balance.
+func (b *Balance) Snapshot() balance.Snapshot {
+	b.expire()
+	return b.inner.Snapshot()
+}
+
+// Add deposits amount into the available balance.
+func (b *Balance) Add(amount int64) { b.inner.Add(amount) }
+
+// Subtract withdraws amount from the available balance after releasing any
+// holds that have expired, so their funds can be spent.
+func (b *Balance) Subtract(amount int64) error {
+	b.expire()
+	return b.inner.Subtract(amount)
+}
+
+// Available returns the funds not reserved by a hold, after releasing any
+// holds that have expired.
+func (b *Balance) Available() int64 {
+	b.expire()
+	return b.inner.Available()
+}
+
+// Held returns the total reserved by outstanding holds after releasing any
+// that have expired.
+func (b *Balance) Held() int64 {
+	b.expire()
+	return b.inner.Held()
+}
+
+// Hold reserves amount, moving it out of the available balance until it is
+// captured, released, or expires. It returns balance.ErrInvalidAmount for
+// non-positive amounts and whatever the wrapped Reserve returns, such as
+// *balance.InsufficientFundsError, when the funds are not available.
+func (b *Balance) Hold(amount int64) (HoldID, error) {
+	if amount <= 0 {
+		return 0, balance.ErrInvalidAmount
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.expireLocked()
+	if err := b.inner.Reserve(amount); err != nil {
+		return 0, err
+	}
+
+	b.nextID++
+	h := &hold{id: b.nextID, amount: amount, expires: b.clock.Now() + b.ttl}
+	b.holds[h.id] = b.order.PushBack(h)
+	return h.id, nil
+}
+
+// Capture settles amount from hold id and closes it, returning any
+// remainder to the available balance in the same step. It returns
+// ErrHoldNotFound if the hold is no longer outstanding,
+// ErrCaptureExceedsHold if amount is more than was held, and
+// balance.ErrInvalidAmount for non-positive amounts.
+func (b *Balance) Capture(id HoldID, amount int64) error {
+	if amount <= 0 {
+		return balance.ErrInvalidAmount
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.expireLocked()
+	el, ok := b.holds[id]
+	if !ok {
+		return ErrHoldNotFound
+	}
+	h := el.Value.(*hold)
+	if amount > h.amount {
+		return ErrCaptureExceedsHold
+	}
+	if err := b.inner.Settle(h.amount, amount); err != nil {
+		return err
+	}
+	b.remove(el)
+	return nil
+}
+
+// Release cancels hold id and returns its full amount to the available
+// balance. It returns ErrHoldNotFound if the hold is no longer outstanding.
+func (b *Balance) Release(id HoldID) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.expireLocked()
+	el, ok := b.holds[id]
+	if !ok {
+		return ErrHoldNotFound
+	}
+	if err := b.inner.Settle(el.Value.(*hold).amount, 0); err != nil {
+		return err
+	}
+	b.remove(el)
+	return nil
+}
+
+// expire releases every hold whose TTL has passed.
+func (b *Balance) expire() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.expireLocked()
+}
+
+// expireLocked is expire for a caller that holds mu. Releasing a hold never
+// fails on limits, so it can only stop early if the wrapped Holder refuses,
+// for example after Close; the rest are retried on the next call.
+func (b *Balance) expireLocked() {
+	now := b.clock.Now()
+	for el := b.order.Front(); el != nil; el = b.order.Front() {
+		h := el.Value.(*hold)
+		if now < h.expires {
+			break
+		}
+		if err := b.inner.Settle(h.amount, 0); err != nil {
+			break
+		}
+		b.remove(el)
+	}
+}
+
+// remove drops the hold at el. The caller must hold mu.
+func (b *Balance) remove(el *list.Element) {
+	delete(b.holds, el.Value.(*hold).id)
+	b.order.Remove(el)
+}
@@ -0,0 +1,311 @@
+package holds_test
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	balance "github.com/madflojo/atomics-v-rwmutex-examples"
+	"github.com/madflojo/atomics-v-rwmutex-examples/balancetest"
+	"github.com/madflojo/atomics-v-rwmutex-examples/holds"
+	_ "github.com/madflojo/atomics-v-rwmutex-examples/implementations/all"
+)
+
+func TestHolds(t *testing.T) {
+	for _, impl := range balance.Implementations() {
+		impl := impl
+		t.Run(impl.Name, func(t *testing.T) {
+			t.Run("capture and release", func(t *testing.T) {
+				acct := holds.Wrap(newHolder(t, impl), time.Hour)
+				acct.Add(100)
+
+				captured, err := acct.Hold(50)
+				if err != nil {
+					t.Fatalf("unexpected hold error: %v", err)
+				}
+				released, err := acct.Hold(20)
+				if err != nil {
+					t.Fatalf("unexpected hold error: %v", err)
+				}
+				assertHolds(t, acct, 30, 70)
+
+				if err := acct.Capture(captured, 60); !errors.Is(err, holds.ErrCaptureExceedsHold) {
+					t.Fatalf("expected ErrCaptureExceedsHold, got %v", err)
+				}
+				if err := acct.Capture(captured, 35); err != nil {
+					t.Fatalf("unexpected capture error: %v", err)
+				}
+				assertHolds(t, acct, 45, 20)
+
+				if err := acct.Release(released); err != nil {
+					t.Fatalf("unexpected release error: %v", err)
+				}
+				assertHolds(t, acct, 65, 0)
+
+				if err := acct.Capture(captured, 1); !errors.Is(err, holds.ErrHoldNotFound) {
+					t.Fatalf("expected ErrHoldNotFound after capture, got %v", err)
+				}
+				if err := acct.Release(released); !errors.Is(err, holds.ErrHoldNotFound) {
+					t.Fatalf("expected ErrHoldNotFound after release, got %v", err)
+				}
+			})
+
+			t.Run("rejects invalid and unfunded holds", func(t *testing.T) {
+				acct := holds.Wrap(newHolder(t, impl), time.Hour)
+				acct.Add(10)
+
+				if _, err := acct.Hold(0); !errors.Is(err, balance.ErrInvalidAmount) {
+					t.Fatalf("expected ErrInvalidAmount, got %v", err)
+				}
+				if _, err := acct.Hold(11); !errors.Is(err, balance.ErrInsufficientFunds) {
+					t.Fatalf("expected ErrInsufficientFunds, got %v", err)
+				}
+				id, err := acct.Hold(10)
+				if err != nil {
+					t.Fatalf("unexpected hold error: %v", err)
+				}
+				if err := acct.Capture(id, 0); !errors.Is(err, balance.ErrInvalidAmount) {
+					t.Fatalf("expected ErrInvalidAmount, got %v", err)
+				}
+				assertHolds(t, acct, 0, 10)
+			})
+
+			t.Run("holds expire after the ttl", func(t *testing.T) {
+				clock := balancetest.NewManualClock(0)
+				acct := holds.Wrap(newHolder(t, impl), time.Minute, balance.WithClock(clock))
+				acct.Add(100)
+
+				first, err := acct.Hold(40)
+				if err != nil {
+					t.Fatalf("unexpected hold error: %v", err)
+				}
+				clock.Advance(30 * time.Second)
+				second, err := acct.Hold(25)
+				if err != nil {
+					t.Fatalf("unexpected hold error: %v", err)
+				}
+
+				clock.Advance(30 * time.Second)
+				assertHolds(t, acct, 75, 25)
+				if err := acct.Capture(first, 40); !errors.Is(err, holds.ErrHoldNotFound) {
+					t.Fatalf("expected ErrHoldNotFound for an expired hold, got %v", err)
+				}
+				if err := acct.Capture(second, 25); err != nil {
+					t.Fatalf("unexpected capture error: %v", err)
+				}
+				assertHolds(t, acct, 75, 0)
+			})
+
+			t.Run("withdrawals release expired holds", func(t *testing.T) {
+				clock := balancetest.NewManualClock(0)
+				acct := holds.Wrap(newHolder(t, impl), time.Minute, balance.WithClock(clock))
+				acct.Add(100)
+				if _, err := acct.Hold(100); err != nil {
+					t.Fatalf("unexpected hold error: %v", err)
+				}
+
+				clock.Advance(time.Minute)
+				if err := acct.Subtract(50); err != nil {
+					t.Fatalf("expected the expired hold to be released, got %v", err)
+				}
+				assertHolds(t, acct, 50, 0)
+			})
+
+			t.Run("ceiling counts held funds", func(t *testing.T) {
+				if impl.NewWithOptions == nil {
+					t.Skip("implementation does not accept options")
+				}
+				clock := balancetest.NewManualClock(0)
+				ceiling := balance.WithLimits(balance.Limits{Ceiling: 100})
+				acct := holds.Wrap(newHolder(t, impl, ceiling), time.Minute, balance.WithClock(clock))
+				acct.Add(100)
+
+				released, err := acct.Hold(60)
+				if err != nil {
+					t.Fatalf("unexpected hold error: %v", err)
+				}
+				clock.Advance(30 * time.Second)
+				captured, err := acct.Hold(30)
+				if err != nil {
+					t.Fatalf("unexpected hold error: %v", err)
+				}
+				if err := balance.AddChecked(acct.Unwrap(), 1); !errors.Is(err, balance.ErrCeilingExceeded) {
+					t.Fatalf("expected ErrCeilingExceeded with held funds at the ceiling, got %v", err)
+				}
+				assertHolds(t, acct, 10, 90)
+
+				// Releasing and capturing deposit nothing, so the ceiling
+				// cannot refuse them.
+				if err := acct.Capture(captured, 10); err != nil {
+					t.Fatalf("unexpected capture error: %v", err)
+				}
+				assertHolds(t, acct, 30, 60)
+				if err := acct.Release(released); err != nil {
+					t.Fatalf("unexpected release error: %v", err)
+				}
+				assertHolds(t, acct, 90, 0)
+
+				if _, err := acct.Hold(50); err != nil {
+					t.Fatalf("unexpected hold error: %v", err)
+				}
+				clock.Advance(time.Minute)
+				assertHolds(t, acct, 90, 0)
+			})
+
+			t.Run("withdrawals cannot spend held funds", func(t *testing.T) {
+				acct := holds.Wrap(newHolder(t, impl), time.Hour)
+				acct.Add(100)
+				if _, err := acct.Hold(70); err != nil {
+					t.Fatalf("unexpected hold error: %v", err)
+				}
+
+				if err := acct.Subtract(31); !errors.Is(err, balance.ErrInsufficientFunds) {
+					t.Fatalf("expected ErrInsufficientFunds, got %v", err)
+				}
+				if batch, ok := acct.Unwrap().(balance.BatchBalance); ok {
+					err := batch.ApplyBatch([]balance.Op{
+						{Kind: balance.OpAdd, Amount: 5},
+						{Kind: balance.OpSubtract, Amount: 36},
+					})
+					if !errors.Is(err, balance.ErrInsufficientFunds) {
+						t.Fatalf("expected ErrInsufficientFunds from the batch, got %v", err)
+					}
+				}
+				if from, ok := acct.Unwrap().(balance.Transferer); ok {
+					to := newHolder(t, impl)
+					if err := from.TransferTo(to, 31); !errors.Is(err, balance.ErrInsufficientFunds) {
+						t.Fatalf("expected ErrInsufficientFunds from the transfer, got %v", err)
+					}
+				}
+				if err := acct.Subtract(30); err != nil {
+					t.Fatalf("unexpected subtract error: %v", err)
+				}
+				assertHolds(t, acct, 0, 70)
+			})
+
+			t.Run("concurrent holds never overdraw", func(t *testing.T) {
+				if impl.Traits.KnownBuggy {
+					t.Skip("known buggy implementation can overdraw")
+				}
+
+				const (
+					initial    = 1_000
+					workers    = 16
+					iterations = 500
+				)
+
+				acct := holds.Wrap(newHolder(t, impl), time.Hour)
+				acct.Add(initial)
+
+				var (
+					wg       sync.WaitGroup
+					captured atomic.Int64
+					spent    atomic.Int64
+					negative atomic.Int64
+					stop     = make(chan struct{})
+				)
+
+				readerDone := make(chan struct{})
+				go func() {
+					defer close(readerDone)
+					for {
+						select {
+						case <-stop:
+							return
+						default:
+						}
+						if v := acct.Available(); v < 0 {
+							negative.Store(v)
+						}
+					}
+				}()
+
+				for w := 0; w < workers; w++ {
+					wg.Add(1)
+					go func(seed int64) {
+						defer wg.Done()
+						rng := rand.New(rand.NewSource(seed))
+						for i := 0; i < iterations; i++ {
+							amount := rng.Int63n(50) + 1
+							if rng.Intn(4) == 0 {
+								// Plain withdrawals race the holds for
+								// the same available funds.
+								if err := acct.Subtract(amount); err == nil {
+									spent.Add(amount)
+								} else if !errors.Is(err, balance.ErrInsufficientFunds) {
+									t.Errorf("unexpected subtract error: %v", err)
+								}
+								continue
+							}
+							id, err := acct.Hold(amount)
+							if err != nil {
+								if !errors.Is(err, balance.ErrInsufficientFunds) {
+									t.Errorf("unexpected hold error: %v", err)
+								}
+								continue
+							}
+							if rng.Intn(2) == 0 {
+								if err := acct.Release(id); err != nil {
+									t.Errorf("unexpected release error: %v", err)
+								}
+								continue
+							}
+							take := rng.Int63n(amount) + 1
+							if err := acct.Capture(id, take); err != nil {
+								t.Errorf("unexpected capture error: %v", err)
+								continue
+							}
+							captured.Add(take)
+						}
+					}(int64(w))
+				}
+
+				wg.Wait()
+				close(stop)
+				<-readerDone
+
+				if v := negative.Load(); v != 0 {
+					t.Fatalf("available balance went negative: %d", v)
+				}
+				if got, want := acct.Available()+acct.Held()+captured.Load()+spent.Load(), int64(initial); got != want {
+					t.Fatalf("funds not conserved, got %d want %d", got, want)
+				}
+				if acct.Held() != 0 {
+					t.Fatalf("expected no outstanding holds, got %d held", acct.Held())
+				}
+			})
+		})
+	}
+}
+
+// assertHolds fails the test unless acct reports the given available and
+// held amounts, and a value of their sum from both Balance and Snapshot.
+func assertHolds(t *testing.T, acct *holds.Balance, available, held int64) {
+	t.Helper()
+	if got := acct.Balance(); got != available+held {
+		t.Fatalf("balance mismatch, got %d want %d", got, available+held)
+	}
+	if got := acct.Snapshot().Value; got != available+held {
+		t.Fatalf("snapshot value mismatch, got %d want %d", got, available+held)
+	}
+	if got := acct.Available(); got != available {
+		t.Fatalf("available mismatch, got %d want %d", got, available)
+	}
+	if got := acct.Held(); got != held {
+		t.Fatalf("held mismatch, got %d want %d", got, held)
+	}
+}
+
+// newHolder returns impl as a balance.Holder, skipping implementations that
+// cannot hold funds.
+func newHolder(t *testing.T, impl balance.Implementation, opts ...balance.Option) balance.Holder {
+	t.Helper()
+	h, ok := balancetest.New(t, impl, opts...).(balance.Holder)
+	if !ok {
+		t.Skip(impl.Name + " does not implement balance.Holder")
+	}
+	return h
+}
@@ -0,0 +1,64 @@
+package idempotency
+
+import balance "github.com/madflojo/atomics-v-rwmutex-examples"
+
+const (
+	opAdd      = "add"
+	opSubtract = "subtract"
+)
+
+// Balance wraps any balance.Balance with keyed, retry-safe Add and Subtract.
+// It still satisfies balance.Balance; the unkeyed methods pass straight
+// through to the wrapped value.
+type Balance struct {
+	inner balance.Balance
+	store *Store
+}
+
+// Wrap returns b with keyed operations whose results are remembered in
+// store.
+func Wrap(b balance.Balance, store *Store) *Balance {
+	return &Balance{inner: b, store: store}
+}
+
+// Unwrap returns the wrapped Balance.
+func (b *Balance) Unwrap() balance.Balance { return b.inner }
+
+// Balance returns the wrapped balance's value.
+func (b *Balance) Balance() int64 { return b.inner.Balance() }
+
+// TransactionCount returns the wrapped balance's transaction count.
+func (b *Balance) TransactionCount() int64 { return b.inner.TransactionCount() }
+
+// LastUpdated returns the wrapped balance's last update timestamp.
+func (b *Balance) LastUpdated() int64 { return b.inner.LastUpdated() }
+
+// Snapshot returns the wrapped balance's snapshot.
+func (b *Balance) Snapshot() balance.Snapshot { return b.inner.Snapshot() }
+
+// Add deposits amount without a key, so retries are not deduplicated.
+func (b *Balance) Add(amount int64) { b.inner.Add(amount) }
+
+// Subtract withdraws amount without a key, so retries are not
+// deduplicated.
+func (b *Balance) Subtract(amount int64) error { return b.inner.Subtract(amount) }
+
+// AddWithKey deposits amount once per key. Replays return the first
+// result without depositing again. Unlike Add it reports
+// balance.ErrInvalidAmount for non-positive amounts, and
+// balance.ErrOverflow when the wrapped Balance implements
+// balance.CheckedBalance.
+func (b *Balance) AddWithKey(key string, amount int64) error {
+	return b.store.do(key, request{op: opAdd, amount: amount}, func() error {
+		return balance.AddChecked(b.inner, amount)
+	})
+}
+
+// SubtractWithKey withdraws amount once per key. Replays return the first
+// result, including a *balance.InsufficientFundsError, without withdrawing
+// again even if funds have since arrived.
+func (b *Balance) SubtractWithKey(key string, amount int64) error {
+	return b.store.do(key, request{op: opSubtract, amount: amount}, func() error {
+		return b.inner.Subtract(amount)
+	})
+}
@@ -0,0 +1,19 @@
+/*
+Package idempotency makes Add and Subtract safe to retry. Callers attach a
+key to each operation; the first submission of a key is applied and its
+result remembered, and every replay of that key returns the same result
+without touching the balance again:
+
+	store := idempotency.NewStore(10_000, 24*time.Hour)
+	acct := idempotency.Wrap(full.New(), store)
+
+	err := acct.AddWithKey("payment-42", 100) // applied
+	err = acct.AddWithKey("payment-42", 100)  // replayed, balance unchanged
+
+Results are kept in a Store bounded by capacity and expire a fixed TTL
+after they complete. A full Store refuses new keys with ErrStoreFull until
+a result expires, rather than forget one early. Keys are not namespaced,
+so give every account its own Store or make keys unique across the
+accounts sharing one.
+*/
+package idempotency
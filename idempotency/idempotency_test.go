@@ -0,0 +1,238 @@
+package idempotency_test
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	balance "github.com/madflojo/atomics-v-rwmutex-examples"
+	"github.com/madflojo/atomics-v-rwmutex-examples/balancetest"
+	"github.com/madflojo/atomics-v-rwmutex-examples/idempotency"
+	_ "github.com/madflojo/atomics-v-rwmutex-examples/implementations/all"
+)
+
+func TestIdempotency(t *testing.T) {
+	for _, impl := range balance.Implementations() {
+		impl := impl
+		t.Run(impl.Name, func(t *testing.T) {
+			t.Run("replay does not mutate", func(t *testing.T) {
+				acct := idempotency.Wrap(balancetest.New(t, impl), idempotency.NewStore(16, time.Hour))
+
+				for i := 0; i < 3; i++ {
+					if err := acct.AddWithKey("deposit", 100); err != nil {
+						t.Fatalf("attempt %d: unexpected add error: %v", i, err)
+					}
+					if err := acct.SubtractWithKey("withdraw", 30); err != nil {
+						t.Fatalf("attempt %d: unexpected subtract error: %v", i, err)
+					}
+				}
+				if acct.Balance() != 70 {
+					t.Fatalf("balance mismatch, got %d want 70", acct.Balance())
+				}
+				if impl.Traits.TracksMetadata && acct.TransactionCount() != 2 {
+					t.Fatalf("transaction count mismatch, got %d want 2", acct.TransactionCount())
+				}
+			})
+
+			t.Run("replay returns the original error", func(t *testing.T) {
+				acct := idempotency.Wrap(balancetest.New(t, impl), idempotency.NewStore(16, time.Hour))
+
+				first := acct.SubtractWithKey("overdraw", 50)
+				var insufficient *balance.InsufficientFundsError
+				if !errors.As(first, &insufficient) {
+					t.Fatalf("expected InsufficientFundsError, got %v", first)
+				}
+
+				acct.Add(500)
+				replay := acct.SubtractWithKey("overdraw", 50)
+				if !errors.Is(replay, balance.ErrInsufficientFunds) || replay != first {
+					t.Fatalf("expected the original error on replay, got %v want %v", replay, first)
+				}
+				if acct.Balance() != 500 {
+					t.Fatalf("replayed failure mutated balance, got %d want 500", acct.Balance())
+				}
+
+				if err := acct.AddWithKey("zero", 0); !errors.Is(err, balance.ErrInvalidAmount) {
+					t.Fatalf("expected ErrInvalidAmount, got %v", err)
+				}
+			})
+
+			t.Run("key reused for a different operation", func(t *testing.T) {
+				acct := idempotency.Wrap(balancetest.New(t, impl), idempotency.NewStore(16, time.Hour))
+
+				if err := acct.AddWithKey("k", 10); err != nil {
+					t.Fatalf("unexpected add error: %v", err)
+				}
+				if err := acct.AddWithKey("k", 11); !errors.Is(err, idempotency.ErrKeyReused) {
+					t.Fatalf("expected ErrKeyReused for a different amount, got %v", err)
+				}
+				if err := acct.SubtractWithKey("k", 10); !errors.Is(err, idempotency.ErrKeyReused) {
+					t.Fatalf("expected ErrKeyReused for a different operation, got %v", err)
+				}
+				if acct.Balance() != 10 {
+					t.Fatalf("balance mismatch, got %d want 10", acct.Balance())
+				}
+			})
+
+			t.Run("concurrent duplicates apply once", func(t *testing.T) {
+				const (
+					keys       = 20
+					duplicates = 25
+				)
+
+				acct := idempotency.Wrap(balancetest.New(t, impl), idempotency.NewStore(keys, time.Hour))
+				var wg sync.WaitGroup
+				errs := make(chan error, keys*duplicates)
+				for k := 0; k < keys; k++ {
+					key := fmt.Sprintf("payment-%d", k)
+					for d := 0; d < duplicates; d++ {
+						wg.Add(1)
+						go func() {
+							defer wg.Done()
+							errs <- acct.AddWithKey(key, 7)
+						}()
+					}
+				}
+				wg.Wait()
+				close(errs)
+
+				for err := range errs {
+					if err != nil {
+						t.Fatalf("unexpected add error: %v", err)
+					}
+				}
+				if got, want := acct.Balance(), int64(keys*7); got != want {
+					t.Fatalf("balance mismatch, got %d want %d", got, want)
+				}
+			})
+		})
+	}
+}
+
+func TestIdempotencyStore(t *testing.T) {
+	impl, ok := balance.Lookup("Mutex_Balance_full")
+	if !ok {
+		t.Fatalf("Mutex_Balance_full is not registered")
+	}
+
+	t.Run("results expire after the ttl", func(t *testing.T) {
+		clock := balancetest.NewManualClock(0)
+		acct := idempotency.Wrap(balancetest.New(t, impl), idempotency.NewStore(16, time.Minute, balance.WithClock(clock)))
+
+		if err := acct.AddWithKey("k", 5); err != nil {
+			t.Fatalf("unexpected add error: %v", err)
+		}
+		clock.Advance(time.Minute - 1)
+		if err := acct.AddWithKey("k", 5); err != nil {
+			t.Fatalf("unexpected replay error: %v", err)
+		}
+		if acct.Balance() != 5 {
+			t.Fatalf("replay before expiry mutated balance, got %d want 5", acct.Balance())
+		}
+
+		clock.Advance(1)
+		if err := acct.AddWithKey("k", 5); err != nil {
+			t.Fatalf("unexpected add error: %v", err)
+		}
+		if acct.Balance() != 10 {
+			t.Fatalf("expired key was not applied again, got %d want 10", acct.Balance())
+		}
+	})
+
+	t.Run("full store refuses new keys until a result expires", func(t *testing.T) {
+		clock := balancetest.NewManualClock(0)
+		store := idempotency.NewStore(2, time.Minute, balance.WithClock(clock))
+		acct := idempotency.Wrap(balancetest.New(t, impl), store)
+
+		for _, key := range []string{"a", "b"} {
+			clock.Advance(time.Second)
+			if err := acct.AddWithKey(key, 1); err != nil {
+				t.Fatalf("unexpected add error for %q: %v", key, err)
+			}
+		}
+		if err := acct.AddWithKey("c", 1); !errors.Is(err, idempotency.ErrStoreFull) {
+			t.Fatalf("expected ErrStoreFull, got %v", err)
+		}
+
+		// "a" expires first and frees its slot; "b" is still remembered.
+		clock.Advance(time.Minute - time.Second)
+		if err := acct.AddWithKey("c", 1); err != nil {
+			t.Fatalf("unexpected add error: %v", err)
+		}
+		if err := acct.AddWithKey("b", 1); err != nil {
+			t.Fatalf("unexpected replay error: %v", err)
+		}
+		if store.Len() != 2 {
+			t.Fatalf("store exceeded capacity, got %d want 2", store.Len())
+		}
+		if acct.Balance() != 3 {
+			t.Fatalf("balance mismatch, got %d want 3", acct.Balance())
+		}
+	})
+
+	t.Run("panicking operation still records a result", func(t *testing.T) {
+		store := idempotency.NewStore(1, time.Hour)
+		acct := idempotency.Wrap(&panickingBalance{}, store)
+
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("expected the panic to reach the caller")
+				}
+			}()
+			_ = acct.AddWithKey("k", 1)
+		}()
+		if err := acct.AddWithKey("k", 1); !errors.Is(err, idempotency.ErrPanicked) {
+			t.Fatalf("expected ErrPanicked on replay, got %v", err)
+		}
+	})
+
+	t.Run("full of in-flight operations", func(t *testing.T) {
+		store := idempotency.NewStore(1, time.Hour)
+		release := make(chan struct{})
+		blocked := idempotency.Wrap(&blockingBalance{release: release}, store)
+
+		done := make(chan error, 1)
+		go func() { done <- blocked.AddWithKey("slow", 1) }()
+
+		// Wait until the slow deposit holds the only slot.
+		for store.Len() == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		if err := blocked.AddWithKey("other", 1); !errors.Is(err, idempotency.ErrStoreFull) {
+			t.Fatalf("expected ErrStoreFull, got %v", err)
+		}
+
+		close(release)
+		if err := <-done; err != nil {
+			t.Fatalf("unexpected add error: %v", err)
+		}
+	})
+}
+
+// nopBalance is a minimal Balance whose mutations do nothing.
+type nopBalance struct{}
+
+func (*nopBalance) Balance() int64             { return 0 }
+func (*nopBalance) TransactionCount() int64    { return 0 }
+func (*nopBalance) LastUpdated() int64         { return 0 }
+func (*nopBalance) Snapshot() balance.Snapshot { return balance.Snapshot{} }
+func (*nopBalance) Add(int64)                  {}
+func (*nopBalance) Subtract(int64) error       { return nil }
+
+// blockingBalance is a Balance whose Add blocks until release is closed.
+type blockingBalance struct {
+	nopBalance
+	release chan struct{}
+}
+
+func (b *blockingBalance) Add(int64) { <-b.release }
+
+// panickingBalance is a Balance whose Add panics.
+type panickingBalance struct {
+	nopBalance
+}
+
+func (*panickingBalance) Add(int64) { panic("add failed") }
@@ -0,0 +1,145 @@
+package idempotency
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+	"time"
+
+	balance "github.com/madflojo/atomics-v-rwmutex-examples"
+)
+
+var (
+	// ErrKeyReused is returned when a key is replayed with a different
+	// operation or amount than it was first submitted with.
+	ErrKeyReused = errors.New("idempotency key reused for a different operation")
+	// ErrStoreFull is returned when every slot holds an operation that is
+	// still in flight or a result that has not expired, so no key can be
+	// added without forgetting a result early.
+	ErrStoreFull = errors.New("idempotency store is full")
+	// ErrPanicked is recorded for a key whose operation panicked. Whether
+	// the balance changed is unknown, so replays return it instead of
+	// running the operation again.
+	ErrPanicked = errors.New("idempotent operation panicked")
+)
+
+// request identifies what a key was first submitted for, so a replay with
+// different arguments is rejected instead of silently answered.
+type request struct {
+	op     string
+	amount int64
+}
+
+// entry remembers one key. done is closed once err is set, and duplicates
+// submitted in the meantime wait on it.
+type entry struct {
+	key     string
+	req     request
+	done    chan struct{}
+	err     error
+	expires int64
+	// el is the entry's place in Store.order; nil while in flight.
+	el *list.Element
+}
+
+// Store remembers the outcome of keyed operations for a fixed TTL. It holds
+// at most capacity keys. Expired results are dropped as new keys arrive,
+// and a key that finds the store full of in-flight operations and
+// unexpired results is refused with ErrStoreFull rather than forgetting a
+// result before its TTL. It is safe for concurrent use.
+type Store struct {
+	mu       sync.Mutex
+	clock    balance.Clock
+	ttl      int64
+	capacity int
+	entries  map[string]*entry
+	// order lists completed entries in the order they completed, which is
+	// also the order they expire in.
+	order *list.List
+}
+
+// NewStore returns a Store that keeps up to capacity results for ttl after
+// each completes. Options such as balance.WithClock control how expiry is
+// measured. NewStore panics if capacity or ttl is not positive.
+func NewStore(capacity int, ttl time.Duration, opts ...balance.Option) *Store {
+	if capacity <= 0 {
+		panic("idempotency: NewStore called with non-positive capacity")
+	}
+	if ttl <= 0 {
+		panic("idempotency: NewStore called with non-positive ttl")
+	}
+
+	o := balance.NewOptions(opts...)
+	return &Store{
+		clock:    o.Clock,
+		ttl:      int64(ttl),
+		capacity: capacity,
+		entries:  make(map[string]*entry, capacity),
+		order:    list.New(),
+	}
+}
+
+// Len reports how many keys are held, including in-flight operations and
+// expired results that have not been dropped yet.
+func (s *Store) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.entries)
+}
+
+// do runs fn once per key. The first caller runs fn and records its result;
+// concurrent and later callers with the same key wait for that result and
+// return it. A key replayed with a different request returns ErrKeyReused.
+// If fn panics, ErrPanicked is recorded for the key and the panic carries
+// on.
+func (s *Store) do(key string, req request, fn func() error) (err error) {
+	s.mu.Lock()
+	now := s.clock.Now()
+	s.expire(now)
+	if e, ok := s.entries[key]; ok {
+		s.mu.Unlock()
+		if e.req != req {
+			return ErrKeyReused
+		}
+		<-e.done
+		return e.err
+	}
+	if len(s.entries) >= s.capacity {
+		s.mu.Unlock()
+		return ErrStoreFull
+	}
+	e := &entry{key: key, req: req, done: make(chan struct{})}
+	s.entries[key] = e
+	s.mu.Unlock()
+
+	// Complete the entry even if fn panics, so duplicates waiting on it
+	// are released and the slot can expire.
+	err = ErrPanicked
+	defer func() { s.complete(e, err) }()
+	err = fn()
+	return err
+}
+
+// complete records err as e's result and starts its TTL.
+func (s *Store) complete(e *entry, err error) {
+	s.mu.Lock()
+	e.err = err
+	e.expires = s.clock.Now() + s.ttl
+	e.el = s.order.PushBack(e)
+	s.mu.Unlock()
+	close(e.done)
+}
+
+// expire drops every result that completed more than ttl ago. Results
+// expire in the order they completed, so it only looks at the front of
+// order. The caller must hold mu.
+func (s *Store) expire(now int64) {
+	for el := s.order.Front(); el != nil; el = s.order.Front() {
+		e := el.Value.(*entry)
+		if now < e.expires {
+			return
+		}
+		delete(s.entries, e.key)
+		s.order.Remove(el)
+	}
+}
@@ -0,0 +1,508 @@
+package actor
+
+import (
+	"context"
+	"runtime"
+	"sync"
+
+	balance "github.com/madflojo/atomics-v-rwmutex-examples"
+	"github.com/madflojo/atomics-v-rwmutex-examples/internal/lockorder"
+)
+
+// opKind selects what the owner goroutine does with a request.
+type opKind int
+
+const (
+	opSnapshot opKind = iota
+	opAdd
+	opAddChecked
+	opSubtract
+	opBatch
+	opAcquire
+	opReserve
+	opSettle
+)
+
+// request is sent to the owner goroutine.
+type request struct {
+	kind   opKind
+	amount int64
+	// capture carries the captured part of the hold for opSettle, whose
+	// amount is the hold being released.
+	capture int64
+	// ops carries the operations for opBatch.
+	ops   []balance.Op
+	reply chan response
+}
+
+// response carries the owner's answer back to the caller.
+type response struct {
+	snap balance.Snapshot
+	held int64
+	err  error
+	// owned and release answer opAcquire: the owner lends its state and
+	// parks until release is closed.
+	owned   *account
+	release chan struct{}
+}
+
+// account is the state owned by the owner goroutine.
+type account struct {
+	balance.Snapshot
+	// held is the part of Value reserved by opReserve.
+	held int64
+}
+
+// mailbox is everything the owner goroutine needs. It is kept separate from
+// ActorBalance so an abandoned balance can become unreachable and be
+// stopped by its cleanup.
+type mailbox struct {
+	requests chan request
+	stop     chan struct{}
+	done     chan struct{}
+	once     sync.Once
+	// clock stamps LastUpdated.
+	clock balance.Clock
+	// limits bounds the value; the zero value is a floor of zero.
+	limits balance.Limits
+	// batch selects how opBatch advances TransactionCount.
+	batch balance.BatchCounting
+	// observers is notified of every mutation while the state is owned.
+	observers balance.Observers
+	// final and finalHeld hold the state at shutdown; they are written
+	// before done closes.
+	final     balance.Snapshot
+	finalHeld int64
+}
+
+// ActorBalance forwards every call to a dedicated owner goroutine.
+//
+// Observers registered with balance.WithObserver are notified by the owner
+// goroutine, or by a transfer while it holds the lent-out state, so they see
+// events in mutation order.
+type ActorBalance struct {
+	m *mailbox
+}
+
+// New starts the owner goroutine and returns a zeroed ActorBalance. Call
+// Close to stop the goroutine when the balance is no longer needed.
+func New() *ActorBalance {
+	return NewWithOptions()
+}
+
+// NewWithOptions is New configured by opts, such as balance.WithClock,
+// balance.WithLimits, and balance.WithBatchCounting.
+func NewWithOptions(opts ...balance.Option) *ActorBalance {
+	o := balance.NewOptions(opts...)
+	m := &mailbox{
+		requests:  make(chan request),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+		clock:     o.Clock,
+		limits:    o.Limits,
+		batch:     o.BatchCounting,
+		observers: o.Observers,
+	}
+	go m.run()
+
+	b := &ActorBalance{m: m}
+	runtime.AddCleanup(b, (*mailbox).shutdown, m)
+	return b
+}
+
+func init() {
+	balance.Register(balance.Implementation{
+		Name: "Actor_Balance",
+		New:  func() balance.Balance { return New() },
+		NewWithOptions: func(opts ...balance.Option) balance.Balance {
+			return NewWithOptions(opts...)
+		},
+		Traits: balance.Traits{
+			Strategy:           balance.StrategyActor,
+			TracksMetadata:     true,
+			ConsistentSnapshot: true,
+			OrderedEvents:      true,
+		},
+	})
+}
+
+// Balance returns the current value.
+func (b *ActorBalance) Balance() int64 {
+	return b.Snapshot().Value
+}
+
+// TransactionCount returns how many mutations have executed.
+func (b *ActorBalance) TransactionCount() int64 {
+	return b.Snapshot().TransactionCount
+}
+
+// LastUpdated returns the timestamp of the latest mutation.
+func (b *ActorBalance) LastUpdated() int64 {
+	return b.Snapshot().LastUpdated
+}
+
+// Snapshot asks the owner for its state, so the result is always
+// consistent. After Close it returns the final state.
+func (b *ActorBalance) Snapshot() balance.Snapshot {
+	resp, err := b.send(opSnapshot, 0)
+	if err != nil {
+		return b.m.final
+	}
+	return resp.snap
+}
+
+// Add asks the owner to increment the balance and waits for it to apply.
+// Deposits made after Close, or that would exceed a configured ceiling, are
+// dropped.
+func (b *ActorBalance) Add(amount int64) {
+	if amount <= 0 {
+		return
+	}
+
+	kind := opAdd
+	if b.m.limits.HasCeiling() {
+		kind = opAddChecked
+	}
+	_, _ = b.send(kind, amount)
+}
+
+// AddChecked asks the owner to increment the balance, returning
+// balance.ErrOverflow if the result would not fit,
+// balance.ErrCeilingExceeded if it would exceed the ceiling, or
+// balance.ErrClosed after Close.
+func (b *ActorBalance) AddChecked(amount int64) error {
+	if amount <= 0 {
+		return balance.ErrInvalidAmount
+	}
+
+	resp, err := b.send(opAddChecked, amount)
+	if err != nil {
+		return err
+	}
+	return resp.err
+}
+
+// AddContext behaves like AddChecked but gives up if ctx is done before the
+// owner accepts the request.
+func (b *ActorBalance) AddContext(ctx context.Context, amount int64) error {
+	if amount <= 0 {
+		return balance.ErrInvalidAmount
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	resp, err := b.sendContext(ctx, opAddChecked, amount)
+	if err != nil {
+		return err
+	}
+	return resp.err
+}
+
+// Subtract asks the owner to decrement the balance. It returns
+// ErrInsufficientFunds if the balance would go below the floor, or
+// balance.ErrClosed after Close.
+func (b *ActorBalance) Subtract(amount int64) error {
+	if amount <= 0 {
+		return balance.ErrInvalidAmount
+	}
+
+	resp, err := b.send(opSubtract, amount)
+	if err != nil {
+		return err
+	}
+	return resp.err
+}
+
+// SubtractContext behaves like Subtract but gives up if ctx is done before
+// the owner accepts the request.
+func (b *ActorBalance) SubtractContext(ctx context.Context, amount int64) error {
+	if amount <= 0 {
+		return balance.ErrInvalidAmount
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	resp, err := b.sendContext(ctx, opSubtract, amount)
+	if err != nil {
+		return err
+	}
+	return resp.err
+}
+
+// ApplyBatch sends ops to the owner as one request, which validates and
+// applies them together, so no reader sees part of the batch. It returns
+// balance.ErrClosed after Close.
+func (b *ActorBalance) ApplyBatch(ops []balance.Op) error {
+	if len(ops) == 0 {
+		return nil
+	}
+
+	req := request{kind: opBatch, ops: ops, reply: make(chan response, 1)}
+	select {
+	case b.m.requests <- req:
+		return (<-req.reply).err
+	case <-b.m.done:
+		return balance.ErrClosed
+	}
+}
+
+// TransferTo moves amount into dst, which must also be an *ActorBalance.
+// Both owners are asked to lend out their state, in address order so
+// opposing transfers cannot deadlock, and stay parked until the move is
+// complete, so no reader sees the funds in flight. It returns
+// balance.ErrOverflow or balance.ErrCeilingExceeded if the deposit would
+// overflow dst or exceed its ceiling.
+func (b *ActorBalance) TransferTo(dst balance.Balance, amount int64) error {
+	if amount <= 0 {
+		return balance.ErrInvalidAmount
+	}
+
+	to, ok := dst.(*ActorBalance)
+	if !ok {
+		return balance.ErrIncompatibleBalance
+	}
+	if to == b {
+		return balance.ErrSameAccount
+	}
+
+	first, second := lockorder.Order(b, to)
+	firstResp, err := first.send(opAcquire, 0)
+	if err != nil {
+		return err
+	}
+	defer close(firstResp.release)
+	secondResp, err := second.send(opAcquire, 0)
+	if err != nil {
+		return err
+	}
+	defer close(secondResp.release)
+
+	from, into := firstResp.owned, secondResp.owned
+	if first != b {
+		from, into = into, from
+	}
+
+	debited, err := b.m.limits.Holding(from.held).Withdraw(from.Value, amount)
+	if err != nil {
+		b.m.notify(from, balance.EventTransferOut, amount, from.Value, err)
+		return err
+	}
+	credited, err := to.m.limits.Deposit(into.Value, amount)
+	if err != nil {
+		to.m.notify(into, balance.EventTransferIn, amount, into.Value, err)
+		return err
+	}
+
+	from.Value = debited
+	from.TransactionCount++
+	from.LastUpdated = max(b.m.clock.Now(), from.LastUpdated)
+	into.Value = credited
+	into.TransactionCount++
+	into.LastUpdated = max(to.m.clock.Now(), into.LastUpdated)
+	b.m.notify(from, balance.EventTransferOut, amount, debited+amount, nil)
+	to.m.notify(into, balance.EventTransferIn, amount, credited-amount, nil)
+	return nil
+}
+
+// BalanceWith returns the value of the receiver and of other, which must
+// also be an *ActorBalance. Both owners lend out their state, in address
+// order as for TransferTo, and stay parked until both values are read, so
+// no transfer can land between the two reads.
+func (b *ActorBalance) BalanceWith(other balance.Balance) (int64, int64, error) {
+	o, ok := other.(*ActorBalance)
+	if !ok {
+		return 0, 0, balance.ErrIncompatibleBalance
+	}
+	if o == b {
+		return 0, 0, balance.ErrSameAccount
+	}
+
+	first, second := lockorder.Order(b, o)
+	firstResp, err := first.send(opAcquire, 0)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer close(firstResp.release)
+	secondResp, err := second.send(opAcquire, 0)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer close(secondResp.release)
+
+	mine, theirs := firstResp.owned.Value, secondResp.owned.Value
+	if first != b {
+		mine, theirs = theirs, mine
+	}
+	return mine, theirs, nil
+}
+
+// Available asks the owner for its value less held funds.
+func (b *ActorBalance) Available() int64 {
+	resp, err := b.send(opSnapshot, 0)
+	if err != nil {
+		return b.m.final.Value - b.m.finalHeld
+	}
+	return resp.snap.Value - resp.held
+}
+
+// Held asks the owner for the amount reserved by outstanding holds.
+func (b *ActorBalance) Held() int64 {
+	resp, err := b.send(opSnapshot, 0)
+	if err != nil {
+		return b.m.finalHeld
+	}
+	return resp.held
+}
+
+// Reserve asks the owner to move amount from the available balance to the
+// held one. It returns ErrInsufficientFunds if the available balance would
+// go below the floor, or balance.ErrClosed after Close.
+func (b *ActorBalance) Reserve(amount int64) error {
+	if amount <= 0 {
+		return balance.ErrInvalidAmount
+	}
+
+	resp, err := b.send(opReserve, amount)
+	if err != nil {
+		return err
+	}
+	return resp.err
+}
+
+// Settle asks the owner to end a reservation of release, withdrawing
+// capture of it and returning the rest to the available balance. It
+// returns balance.ErrClosed after Close.
+func (b *ActorBalance) Settle(release, capture int64) error {
+	req := request{kind: opSettle, amount: release, capture: capture, reply: make(chan response, 1)}
+	select {
+	case b.m.requests <- req:
+		return (<-req.reply).err
+	case <-b.m.done:
+		return balance.ErrClosed
+	}
+}
+
+// Close stops the owner goroutine and waits for it to exit. It is safe to
+// call more than once and always returns nil.
+func (b *ActorBalance) Close() error {
+	b.m.shutdown()
+	<-b.m.done
+	return nil
+}
+
+// send delivers a request and waits for the reply, or returns
+// balance.ErrClosed if the owner has stopped.
+func (b *ActorBalance) send(kind opKind, amount int64) (response, error) {
+	return b.sendContext(context.Background(), kind, amount)
+}
+
+// sendContext is send bounded by ctx. Cancellation only applies while
+// waiting for the owner to accept the request; once accepted, the request
+// is applied and its real outcome is returned.
+func (b *ActorBalance) sendContext(
+	ctx context.Context,
+	kind opKind,
+	amount int64,
+) (response, error) {
+	req := request{kind: kind, amount: amount, reply: make(chan response, 1)}
+	select {
+	case b.m.requests <- req:
+		return <-req.reply, nil
+	case <-b.m.done:
+		return response{}, balance.ErrClosed
+	case <-ctx.Done():
+		return response{}, ctx.Err()
+	}
+}
+
+// shutdown signals the owner goroutine to stop.
+func (m *mailbox) shutdown() {
+	m.once.Do(func() { close(m.stop) })
+}
+
+// run owns the state and serves requests one at a time until stopped.
+func (m *mailbox) run() {
+	var state account
+	for {
+		select {
+		case req := <-m.requests:
+			if req.kind == opAcquire {
+				release := make(chan struct{})
+				req.reply <- response{owned: &state, release: release}
+				<-release
+				continue
+			}
+			req.reply <- m.apply(&state, req)
+		case <-m.stop:
+			m.final, m.finalHeld = state.Snapshot, state.held
+			close(m.done)
+			return
+		}
+	}
+}
+
+// apply executes req against state and reports it to the observers.
+func (m *mailbox) apply(state *account, req request) response {
+	var (
+		kind   balance.EventKind
+		amount       = req.amount
+		next   int64 = state.Value
+		held         = state.held
+		trx    int64 = 1
+		err    error
+	)
+	switch req.kind {
+	case opAdd:
+		kind, next = balance.EventAdd, state.Value+req.amount
+	case opAddChecked:
+		kind = balance.EventAdd
+		next, err = m.limits.Deposit(state.Value, req.amount)
+	case opSubtract:
+		kind = balance.EventSubtract
+		next, err = m.limits.Holding(state.held).Withdraw(state.Value, req.amount)
+	case opBatch:
+		kind, trx = balance.EventBatch, m.batch.Transactions(len(req.ops))
+		next, err = m.limits.Holding(state.held).Apply(state.Value, req.ops)
+	case opReserve:
+		kind = balance.EventHold
+		held, err = m.limits.Reserve(state.Value, state.held, req.amount)
+	case opSettle:
+		kind, amount = balance.EventSettle, req.capture
+		next, held, err = balance.Settle(state.Value, state.held, req.amount, req.capture)
+		if err != nil {
+			return response{snap: state.Snapshot, held: state.held, err: err}
+		}
+	default:
+		return response{snap: state.Snapshot, held: state.held}
+	}
+
+	old := state.Value
+	if err == nil {
+		state.Value = next
+		state.held = held
+		state.TransactionCount += trx
+		state.LastUpdated = m.clock.Now()
+	}
+	m.notify(state, kind, amount, old, err)
+	return response{snap: state.Snapshot, held: state.held, err: err}
+}
+
+// notify reports a mutation that moved state from old, or that was rejected
+// with err, to the registered observers. It must only run while state is
+// owned: on the owner goroutine or while the state is lent out.
+func (m *mailbox) notify(state *account, kind balance.EventKind, amount, old int64, err error) {
+	if !m.observers.Enabled() {
+		return
+	}
+	m.observers.Notify(balance.Event{
+		Kind:        kind,
+		Amount:      amount,
+		Old:         old,
+		New:         state.Value,
+		Transaction: state.TransactionCount,
+		Timestamp:   state.LastUpdated,
+		Err:         err,
+	})
+}
@@ -0,0 +1,11 @@
+/*
+Package actor implements a balance owned by a single goroutine. Every
+method sends a request struct over a channel and waits for the owner to
+reply, so the state is never shared: "share memory by communicating".
+
+Snapshot is consistent: the owner goroutine answers it from state nobody
+else can touch. Close stops the owner; afterwards reads return the final
+state, Add is dropped, and Subtract returns balance.ErrClosed. Balances
+that are never closed are stopped once they become unreachable.
+*/
+package actor
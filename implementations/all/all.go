@@ -0,0 +1,17 @@
+package all
+
+import (
+	// Register every implementation.
+	_ "github.com/madflojo/atomics-v-rwmutex-examples/implementations/actor"
+	_ "github.com/madflojo/atomics-v-rwmutex-examples/implementations/atomics/bugs/full"
+	_ "github.com/madflojo/atomics-v-rwmutex-examples/implementations/atomics/bugs/simple"
+	_ "github.com/madflojo/atomics-v-rwmutex-examples/implementations/atomics/cas/full"
+	_ "github.com/madflojo/atomics-v-rwmutex-examples/implementations/atomics/cas/simple"
+	_ "github.com/madflojo/atomics-v-rwmutex-examples/implementations/atomics/cow"
+	_ "github.com/madflojo/atomics-v-rwmutex-examples/implementations/mutex/full"
+	_ "github.com/madflojo/atomics-v-rwmutex-examples/implementations/mutex/simple"
+	_ "github.com/madflojo/atomics-v-rwmutex-examples/implementations/rwmutex/full"
+	_ "github.com/madflojo/atomics-v-rwmutex-examples/implementations/rwmutex/simple"
+	_ "github.com/madflojo/atomics-v-rwmutex-examples/implementations/seqlock"
+	_ "github.com/madflojo/atomics-v-rwmutex-examples/implementations/sharded"
+)
@@ -0,0 +1,8 @@
+/*
+Package all registers every balance implementation in this repository with
+the root balance registry. Import it for its side effects to enumerate the
+variants through balance.Implementations:
+
+	import _ "github.com/madflojo/atomics-v-rwmutex-examples/implementations/all"
+*/
+package all
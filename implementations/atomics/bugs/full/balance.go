@@ -1,16 +1,27 @@
 package full
 
 import (
-	"errors"
+	"context"
 	"sync/atomic"
 	"time"
+
+	balance "github.com/madflojo/atomics-v-rwmutex-examples"
+	"github.com/madflojo/atomics-v-rwmutex-examples/internal/monotonic"
 )
 
-// ErrInsufficientFunds signals that a subtract would create a negative balance.
-var ErrInsufficientFunds = errors.New("insufficient funds")
+// ErrInsufficientFunds signals that a subtract would take the balance
+// below its floor.
+//
+// Deprecated: Use balance.ErrInsufficientFunds, which it is an alias of.
+var ErrInsufficientFunds = balance.ErrInsufficientFunds
 
 // AtomicBugsFullBalance mirrors the feature set of the other full implementations
 // but intentionally omits CAS protection to highlight logic races.
+//
+// Observers registered with balance.WithObserver are notified after the
+// atomic add that applied the mutation, outside any critical section. Each
+// event's Old and New are the values that add moved between, but events from
+// concurrent mutations can reach observers out of order.
 type AtomicBugsFullBalance struct {
 	// value stores the running balance.
 	value atomic.Int64
@@ -18,11 +29,44 @@ type AtomicBugsFullBalance struct {
 	trx atomic.Int64
 	// updated records the timestamp of the last mutation in nanoseconds.
 	updated atomic.Int64
+	// held is the part of value reserved by Reserve. It is loaded and
+	// updated separately from value, so holds race like withdrawals do.
+	held atomic.Int64
+	// clock stamps updated; nil means balance.SystemClock.
+	clock balance.Clock
+	// limits bounds value; the zero value is a floor of zero.
+	limits balance.Limits
+	// batch selects how ApplyBatch advances trx.
+	batch balance.BatchCounting
+	// observers is notified after every atomic add.
+	observers balance.Observers
 }
 
 // New constructs a zeroed AtomicBugsFullBalance.
 func New() *AtomicBugsFullBalance {
-	return &AtomicBugsFullBalance{}
+	return NewWithOptions()
+}
+
+// NewWithOptions is New configured by opts, such as balance.WithClock,
+// balance.WithLimits, and balance.WithBatchCounting.
+func NewWithOptions(opts ...balance.Option) *AtomicBugsFullBalance {
+	o := balance.NewOptions(opts...)
+	return &AtomicBugsFullBalance{clock: o.Clock, limits: o.Limits, batch: o.BatchCounting, observers: o.Observers}
+}
+
+func init() {
+	balance.Register(balance.Implementation{
+		Name: "Atomic_Balance_bugs_full",
+		New:  func() balance.Balance { return New() },
+		NewWithOptions: func(opts ...balance.Option) balance.Balance {
+			return NewWithOptions(opts...)
+		},
+		Traits: balance.Traits{
+			Strategy:       balance.StrategyAtomic,
+			TracksMetadata: true,
+			KnownBuggy:     true,
+		},
+	})
 }
 
 // Balance returns the current value.
@@ -35,29 +79,263 @@ func (b *AtomicBugsFullBalance) TransactionCount() int64 {
 	return b.trx.Load()
 }
 
-// LastUpdated returns the timestamp for the most recent mutation.
+// LastUpdated returns the timestamp for the most recent mutation. Writers only
+// ever advance it, so it never goes backwards even when a slower writer
+// finishes last.
 func (b *AtomicBugsFullBalance) LastUpdated() int64 {
 	return b.updated.Load()
 }
 
-// Add increments the balance and metadata without locking.
+// Snapshot loads each field independently. It is not consistent: a
+// concurrent writer can land between the loads, so Value may not match
+// TransactionCount or LastUpdated.
+func (b *AtomicBugsFullBalance) Snapshot() balance.Snapshot {
+	return balance.Snapshot{
+		Value:            b.value.Load(),
+		TransactionCount: b.trx.Load(),
+		LastUpdated:      b.updated.Load(),
+	}
+}
+
+// Add increments the balance and metadata without locking. With a ceiling
+// configured it takes the AddChecked path and drops deposits that would
+// exceed it.
 func (b *AtomicBugsFullBalance) Add(amount int64) {
-	b.value.Add(amount)
-	b.trx.Add(1)
-	b.updated.Store(time.Now().UnixNano())
+	if amount <= 0 {
+		return
+	}
+	if b.limits.HasCeiling() {
+		_ = b.AddChecked(amount)
+		return
+	}
+
+	b.deposit(amount, balance.EventAdd)
+}
+
+// AddChecked increments the balance and metadata or returns
+// balance.ErrOverflow or balance.ErrCeilingExceeded. Like Subtract, the
+// check is not CAS-protected, so concurrent deposits can still overflow or
+// exceed the ceiling.
+func (b *AtomicBugsFullBalance) AddChecked(amount int64) error {
+	if amount <= 0 {
+		return balance.ErrInvalidAmount
+	}
+
+	return b.add(amount, balance.EventAdd)
+}
+
+// AddContext checks ctx once and then behaves like AddChecked, races
+// included.
+func (b *AtomicBugsFullBalance) AddContext(ctx context.Context, amount int64) error {
+	if amount <= 0 {
+		return balance.ErrInvalidAmount
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return b.AddChecked(amount)
 }
 
 // Subtract decrements the balance but intentionally lacks CAS protection,
-// making it vulnerable to lost updates.
+// making it vulnerable to lost updates that break the floor.
 func (b *AtomicBugsFullBalance) Subtract(amount int64) error {
+	if amount <= 0 {
+		return balance.ErrInvalidAmount
+	}
+
+	return b.subtract(amount, balance.EventSubtract)
+}
+
+// SubtractContext checks ctx once and then behaves like Subtract, races
+// included.
+func (b *AtomicBugsFullBalance) SubtractContext(ctx context.Context, amount int64) error {
+	if amount <= 0 {
+		return balance.ErrInvalidAmount
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return b.Subtract(amount)
+}
+
+// ApplyBatch validates ops against a single load and applies the net change
+// with one atomic add, then records metadata. Like Subtract, the check is
+// not CAS-protected, so concurrent writers can still break the floor.
+func (b *AtomicBugsFullBalance) ApplyBatch(ops []balance.Op) error {
+	if len(ops) == 0 {
+		return nil
+	}
+
+	current := b.value.Load()
+	// Widen the window between the check and the add, as Subtract does, so
+	// the race shows up in tests and benchmarks.
+	time.Sleep(100 * time.Microsecond)
+	next, err := b.limits.Holding(b.held.Load()).Apply(current, ops)
+	if err != nil {
+		b.reject(balance.EventBatch, 0, current, err)
+		return err
+	}
+
+	delta := next - current
+	after := b.value.Add(delta)
+	b.record(balance.EventBatch, 0, after-delta, after, b.batch.Transactions(len(ops)))
+	return nil
+}
+
+// TransferTo withdraws amount and deposits it into dst, which must also be a
+// *AtomicBugsFullBalance. The withdrawal inherits the race in Subtract, so
+// concurrent transfers can overdraw the source. Funds are never created or
+// lost, but a reader can observe the debit before the credit. If the deposit
+// would overflow dst or exceed its ceiling, the withdrawal is refunded and
+// the deposit's error is returned.
+func (b *AtomicBugsFullBalance) TransferTo(dst balance.Balance, amount int64) error {
+	if amount <= 0 {
+		return balance.ErrInvalidAmount
+	}
+
+	to, ok := dst.(*AtomicBugsFullBalance)
+	if !ok {
+		return balance.ErrIncompatibleBalance
+	}
+	if to == b {
+		return balance.ErrSameAccount
+	}
+
+	if err := b.subtract(amount, balance.EventTransferOut); err != nil {
+		return err
+	}
+	if err := to.add(amount, balance.EventTransferIn); err != nil {
+		// Refund the withdrawal so no funds are lost, bypassing the
+		// ceiling: the funds were already here.
+		b.deposit(amount, balance.EventTransferIn)
+		return err
+	}
+	return nil
+}
+
+// Available returns the value less held funds from two separate loads,
+// which a concurrent hold can land between.
+func (b *AtomicBugsFullBalance) Available() int64 {
+	return b.value.Load() - b.held.Load()
+}
+
+// Held returns the amount reserved by outstanding holds.
+func (b *AtomicBugsFullBalance) Held() int64 {
+	return b.held.Load()
+}
+
+// Reserve checks the hold against stale loads and then adds it to the held
+// amount, so like Subtract it can overdraw the available balance under
+// contention.
+func (b *AtomicBugsFullBalance) Reserve(amount int64) error {
+	if amount <= 0 {
+		return balance.ErrInvalidAmount
+	}
+
+	current, held := b.value.Load(), b.held.Load()
+	time.Sleep(100 * time.Microsecond)
+	if _, err := b.limits.Reserve(current, held, amount); err != nil {
+		b.reject(balance.EventHold, amount, current, err)
+		return err
+	}
+
+	b.held.Add(amount)
+	b.record(balance.EventHold, amount, current, current, 1)
+	return nil
+}
+
+// Settle checks the amounts against stale loads and then releases the hold
+// and withdraws capture with two separate atomic adds, so a reader can see
+// one without the other.
+func (b *AtomicBugsFullBalance) Settle(release, capture int64) error {
+	if _, _, err := balance.Settle(b.value.Load(), b.held.Load(), release, capture); err != nil {
+		return err
+	}
+
+	b.held.Add(-release)
+	next := b.value.Add(-capture)
+	b.record(balance.EventSettle, capture, next+capture, next, 1)
+	return nil
+}
+
+// add checks the deposit against a stale load and then applies it,
+// reporting it to the observers as kind.
+func (b *AtomicBugsFullBalance) add(amount int64, kind balance.EventKind) error {
+	current := b.value.Load()
+	if _, err := b.limits.Deposit(current, amount); err != nil {
+		b.reject(kind, amount, current, err)
+		return err
+	}
+
+	b.deposit(amount, kind)
+	return nil
+}
+
+// subtract checks the withdrawal against a stale load and then applies it,
+// reporting it to the observers as kind.
+func (b *AtomicBugsFullBalance) subtract(amount int64, kind balance.EventKind) error {
 	current := b.value.Load()
 	time.Sleep(100 * time.Microsecond)
-	if current-amount < 0 {
-		return ErrInsufficientFunds
+	if _, err := b.limits.Holding(b.held.Load()).Withdraw(current, amount); err != nil {
+		b.reject(kind, amount, current, err)
+		return err
 	}
 
-	b.value.Add(-amount)
-	b.trx.Add(1)
-	b.updated.Store(time.Now().UnixNano())
+	next := b.value.Add(-amount)
+	b.record(kind, amount, next+amount, next, 1)
 	return nil
 }
+
+// deposit adds amount and records metadata without checking limits,
+// reporting it to the observers as kind.
+func (b *AtomicBugsFullBalance) deposit(amount int64, kind balance.EventKind) {
+	next := b.value.Add(amount)
+	b.record(kind, amount, next-amount, next, 1)
+}
+
+// record advances trx by n and updated to now for a mutation that moved
+// value from old to next, then reports it to the observers.
+func (b *AtomicBugsFullBalance) record(kind balance.EventKind, amount, old, next, n int64) {
+	trx := b.trx.Add(n)
+	now := b.now()
+	monotonic.Advance(&b.updated, now)
+	if !b.observers.Enabled() {
+		return
+	}
+	b.observers.Notify(balance.Event{
+		Kind:        kind,
+		Amount:      amount,
+		Old:         old,
+		New:         next,
+		Transaction: trx,
+		Timestamp:   now,
+	})
+}
+
+// reject reports a mutation that was rejected with err while value was
+// current to the observers.
+func (b *AtomicBugsFullBalance) reject(kind balance.EventKind, amount, current int64, err error) {
+	if !b.observers.Enabled() {
+		return
+	}
+	b.observers.Notify(balance.Event{
+		Kind:        kind,
+		Amount:      amount,
+		Old:         current,
+		New:         current,
+		Transaction: b.trx.Load(),
+		Timestamp:   b.updated.Load(),
+		Err:         err,
+	})
+}
+
+// now reads the configured clock, falling back to the system clock for a
+// zero-value AtomicBugsFullBalance.
+func (b *AtomicBugsFullBalance) now() int64 {
+	if b.clock == nil {
+		return balance.SystemClock.Now()
+	}
+	return b.clock.Now()
+}
@@ -2,5 +2,9 @@
 Package full implements the intentionally buggy atomic balance that also
 tracks transaction counts and timestamps so it can be compared against
 other “full” implementations feature-for-feature.
+
+Snapshot is not consistent: value, transaction count, and timestamp are
+independent atomics, so a snapshot can pair a value with the metadata of a
+different mutation.
 */
 package full
@@ -1,24 +1,64 @@
 package simple
 
 import (
-	"errors"
+	"context"
 	"sync/atomic"
 	"time"
+
+	balance "github.com/madflojo/atomics-v-rwmutex-examples"
 )
 
-// ErrInsufficientFunds indicates a subtraction would push the balance below zero.
-var ErrInsufficientFunds = errors.New("insufficient funds")
+// ErrInsufficientFunds indicates a subtraction would push the balance
+// below its floor.
+//
+// Deprecated: Use balance.ErrInsufficientFunds, which it is an alias of.
+var ErrInsufficientFunds = balance.ErrInsufficientFunds
 
 // AtomicBugsSimpleBalance is an intentionally incorrect atomic balance that only
 // tracks its value, making it easy to demonstrate race conditions.
+//
+// Observers registered with balance.WithObserver are notified after the
+// atomic add that applied the mutation, outside any critical section. Each
+// event's Old and New are the values that add moved between, but events from
+// concurrent mutations can reach observers out of order.
 type AtomicBugsSimpleBalance struct {
 	// value stores the raw account balance.
 	value atomic.Int64
+	// held is the part of value reserved by Reserve. It is loaded and
+	// updated separately from value, so holds race like withdrawals do.
+	held atomic.Int64
+	// limits bounds value; the zero value is a floor of zero.
+	limits balance.Limits
+	// observers is notified after every atomic add.
+	observers balance.Observers
 }
 
 // New creates a zeroed AtomicBugsSimpleBalance.
 func New() *AtomicBugsSimpleBalance {
-	return &AtomicBugsSimpleBalance{}
+	return NewWithOptions()
+}
+
+// NewWithOptions is New configured by opts, such as balance.WithLimits.
+// The simple variant does not record timestamps, so balance.WithClock has
+// no effect.
+func NewWithOptions(opts ...balance.Option) *AtomicBugsSimpleBalance {
+	o := balance.NewOptions(opts...)
+	return &AtomicBugsSimpleBalance{limits: o.Limits, observers: o.Observers}
+}
+
+func init() {
+	balance.Register(balance.Implementation{
+		Name: "Atomic_Balance_bugs_simple",
+		New:  func() balance.Balance { return New() },
+		NewWithOptions: func(opts ...balance.Option) balance.Balance {
+			return NewWithOptions(opts...)
+		},
+		Traits: balance.Traits{
+			Strategy:           balance.StrategyAtomic,
+			ConsistentSnapshot: true,
+			KnownBuggy:         true,
+		},
+	})
 }
 
 // Balance returns the current value.
@@ -37,20 +77,214 @@ func (b *AtomicBugsSimpleBalance) LastUpdated() int64 {
 	return 0
 }
 
-// Add increments the value atomically.
+// Snapshot returns the current value. Only the value is tracked, so the
+// snapshot is trivially consistent.
+func (b *AtomicBugsSimpleBalance) Snapshot() balance.Snapshot {
+	return balance.Snapshot{Value: b.value.Load()}
+}
+
+// Add increments the value atomically. With a ceiling configured it takes
+// the AddChecked path and drops deposits that would exceed it.
 func (b *AtomicBugsSimpleBalance) Add(amount int64) {
-	b.value.Add(amount)
+	if amount <= 0 {
+		return
+	}
+	if b.limits.HasCeiling() {
+		_ = b.AddChecked(amount)
+		return
+	}
+
+	b.deposit(amount, balance.EventAdd)
+}
+
+// AddChecked increments the value or returns balance.ErrOverflow or
+// balance.ErrCeilingExceeded. Like Subtract, the check is not
+// CAS-protected, so concurrent deposits can still overflow or exceed the
+// ceiling.
+func (b *AtomicBugsSimpleBalance) AddChecked(amount int64) error {
+	if amount <= 0 {
+		return balance.ErrInvalidAmount
+	}
+
+	return b.add(amount, balance.EventAdd)
+}
+
+// AddContext checks ctx once and then behaves like AddChecked, races
+// included.
+func (b *AtomicBugsSimpleBalance) AddContext(ctx context.Context, amount int64) error {
+	if amount <= 0 {
+		return balance.ErrInvalidAmount
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return b.AddChecked(amount)
 }
 
 // Subtract decrements the value without CAS protection, intentionally
-// leaving room for lost updates under contention.
+// leaving room for lost updates under contention that break the floor.
 func (b *AtomicBugsSimpleBalance) Subtract(amount int64) error {
+	if amount <= 0 {
+		return balance.ErrInvalidAmount
+	}
+
+	return b.subtract(amount, balance.EventSubtract)
+}
+
+// SubtractContext checks ctx once and then behaves like Subtract, races
+// included.
+func (b *AtomicBugsSimpleBalance) SubtractContext(ctx context.Context, amount int64) error {
+	if amount <= 0 {
+		return balance.ErrInvalidAmount
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return b.Subtract(amount)
+}
+
+// ApplyBatch validates ops against a single load and applies the net change
+// with one atomic add. Like Subtract, the check is not CAS-protected, so
+// concurrent writers can still break the floor.
+func (b *AtomicBugsSimpleBalance) ApplyBatch(ops []balance.Op) error {
+	if len(ops) == 0 {
+		return nil
+	}
+
 	current := b.value.Load()
+	// Widen the window between the check and the add, as Subtract does, so
+	// the race shows up in tests and benchmarks.
 	time.Sleep(100 * time.Microsecond)
-	if current-amount < 0 {
-		return ErrInsufficientFunds
+	next, err := b.limits.Holding(b.held.Load()).Apply(current, ops)
+	if err != nil {
+		b.notify(balance.EventBatch, 0, current, current, err)
+		return err
 	}
 
-	b.value.Add(-amount)
+	delta := next - current
+	after := b.value.Add(delta)
+	b.notify(balance.EventBatch, 0, after-delta, after, nil)
 	return nil
 }
+
+// TransferTo withdraws amount and deposits it into dst, which must also be a
+// *AtomicBugsSimpleBalance. The withdrawal inherits the race in Subtract, so
+// concurrent transfers can overdraw the source. Funds are never created or
+// lost, but a reader can observe the debit before the credit. If the deposit
+// would overflow dst or exceed its ceiling, the withdrawal is refunded and
+// the deposit's error is returned.
+func (b *AtomicBugsSimpleBalance) TransferTo(dst balance.Balance, amount int64) error {
+	if amount <= 0 {
+		return balance.ErrInvalidAmount
+	}
+
+	to, ok := dst.(*AtomicBugsSimpleBalance)
+	if !ok {
+		return balance.ErrIncompatibleBalance
+	}
+	if to == b {
+		return balance.ErrSameAccount
+	}
+
+	if err := b.subtract(amount, balance.EventTransferOut); err != nil {
+		return err
+	}
+	if err := to.add(amount, balance.EventTransferIn); err != nil {
+		// Refund the withdrawal so no funds are lost, bypassing the
+		// ceiling: the funds were already here.
+		b.deposit(amount, balance.EventTransferIn)
+		return err
+	}
+	return nil
+}
+
+// Available returns the value less held funds from two separate loads,
+// which a concurrent hold can land between.
+func (b *AtomicBugsSimpleBalance) Available() int64 {
+	return b.value.Load() - b.held.Load()
+}
+
+// Held returns the amount reserved by outstanding holds.
+func (b *AtomicBugsSimpleBalance) Held() int64 {
+	return b.held.Load()
+}
+
+// Reserve checks the hold against stale loads and then adds it to the held
+// amount, so like Subtract it can overdraw the available balance under
+// contention.
+func (b *AtomicBugsSimpleBalance) Reserve(amount int64) error {
+	if amount <= 0 {
+		return balance.ErrInvalidAmount
+	}
+
+	current, held := b.value.Load(), b.held.Load()
+	time.Sleep(100 * time.Microsecond)
+	if _, err := b.limits.Reserve(current, held, amount); err != nil {
+		b.notify(balance.EventHold, amount, current, current, err)
+		return err
+	}
+
+	b.held.Add(amount)
+	b.notify(balance.EventHold, amount, current, current, nil)
+	return nil
+}
+
+// Settle checks the amounts against stale loads and then releases the hold
+// and withdraws capture with two separate atomic adds, so a reader can see
+// one without the other.
+func (b *AtomicBugsSimpleBalance) Settle(release, capture int64) error {
+	if _, _, err := balance.Settle(b.value.Load(), b.held.Load(), release, capture); err != nil {
+		return err
+	}
+
+	b.held.Add(-release)
+	next := b.value.Add(-capture)
+	b.notify(balance.EventSettle, capture, next+capture, next, nil)
+	return nil
+}
+
+// add checks the deposit against a stale load and then applies it,
+// reporting it to the observers as kind.
+func (b *AtomicBugsSimpleBalance) add(amount int64, kind balance.EventKind) error {
+	current := b.value.Load()
+	if _, err := b.limits.Deposit(current, amount); err != nil {
+		b.notify(kind, amount, current, current, err)
+		return err
+	}
+
+	b.deposit(amount, kind)
+	return nil
+}
+
+// subtract checks the withdrawal against a stale load and then applies it,
+// reporting it to the observers as kind.
+func (b *AtomicBugsSimpleBalance) subtract(amount int64, kind balance.EventKind) error {
+	current := b.value.Load()
+	time.Sleep(100 * time.Microsecond)
+	if _, err := b.limits.Holding(b.held.Load()).Withdraw(current, amount); err != nil {
+		b.notify(kind, amount, current, current, err)
+		return err
+	}
+
+	next := b.value.Add(-amount)
+	b.notify(kind, amount, next+amount, next, nil)
+	return nil
+}
+
+// deposit adds amount without checking limits, reporting it to the
+// observers as kind.
+func (b *AtomicBugsSimpleBalance) deposit(amount int64, kind balance.EventKind) {
+	next := b.value.Add(amount)
+	b.notify(kind, amount, next-amount, next, nil)
+}
+
+// notify reports a mutation that moved value from old to next, or that was
+// rejected with err, to the registered observers.
+func (b *AtomicBugsSimpleBalance) notify(kind balance.EventKind, amount, old, next int64, err error) {
+	if !b.observers.Enabled() {
+		return
+	}
+	b.observers.Notify(balance.Event{Kind: kind, Amount: amount, Old: old, New: next, Err: err})
+}
@@ -2,5 +2,7 @@
 Package simple provides the intentionally incorrect atomic balance that
 only tracks the raw value. It is designed to highlight how missing CAS
 protection fails under contention.
+
+Snapshot only carries the value, so it is trivially consistent.
 */
 package simple
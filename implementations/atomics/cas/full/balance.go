@@ -1,28 +1,99 @@
 package full
 
 import (
+	"context"
 	"errors"
+	"runtime"
 	"sync/atomic"
-	"time"
+
+	balance "github.com/madflojo/atomics-v-rwmutex-examples"
+	"github.com/madflojo/atomics-v-rwmutex-examples/internal/contention"
+	"github.com/madflojo/atomics-v-rwmutex-examples/internal/mcas"
+	"github.com/madflojo/atomics-v-rwmutex-examples/internal/monotonic"
 )
 
-// ErrInsufficientFunds indicates the balance would drop below zero.
-var ErrInsufficientFunds = errors.New("insufficient funds")
+// ErrInsufficientFunds indicates the balance would drop below its floor.
+//
+// Deprecated: Use balance.ErrInsufficientFunds, which it is an alias of.
+var ErrInsufficientFunds = balance.ErrInsufficientFunds
 
 // AtomicCASFullBalance stores balance metadata while protecting every
 // update via CAS loops.
+//
+// Observers registered with balance.WithObserver are notified after the CAS
+// that applied the mutation and its metadata update, outside any critical
+// section, so events from concurrent mutations can reach observers out of
+// order. Each event's Old and New are exact. Transaction is unique, but the
+// counter is bumped after the CAS, so two concurrent mutations can take
+// their numbers in the opposite order to their CASes. Timestamp is the
+// mutation's own clock reading, which can be earlier than the LastUpdated
+// a concurrent writer has already advanced to.
+//
+// It does not implement balance.Holder. A withdrawal would have to check
+// the held amount in the same step that changes the value, which takes an
+// mcas.Update on every Subtract instead of a single CAS, and the CAS loops
+// are what this variant exists to measure.
 type AtomicCASFullBalance struct {
-	// value holds the running balance.
-	value atomic.Int64
+	// value holds the running balance. It is an mcas.Word so transfers can
+	// update it together with the destination's.
+	value mcas.Word
 	// trx counts successful mutations.
 	trx atomic.Int64
+	// claimed runs ahead of trx by the transactions of every mutation that
+	// has started but not yet been recorded or rejected, so a reader that
+	// sees the two equal knows none is part-way through. See stable.
+	claimed atomic.Int64
 	// updated records the timestamp of the latest mutation.
 	updated atomic.Int64
+	// clock stamps updated; nil means balance.SystemClock.
+	clock balance.Clock
+	// limits bounds value; the zero value is a floor of zero.
+	limits balance.Limits
+	// batch selects how ApplyBatch advances trx.
+	batch balance.BatchCounting
+	// observers is notified after every successful CAS.
+	observers balance.Observers
+	// stats counts CAS retries; it is nil unless built with balance.WithStats.
+	stats *contention.Counters
 }
 
 // New creates a zeroed AtomicCASFullBalance.
 func New() *AtomicCASFullBalance {
-	return &AtomicCASFullBalance{}
+	return NewWithOptions()
+}
+
+// NewWithOptions is New configured by opts, such as balance.WithClock,
+// balance.WithLimits, and balance.WithBatchCounting. An overdraft reaching
+// into the range mcas reserves is cut short at mcas.MinValue.
+func NewWithOptions(opts ...balance.Option) *AtomicCASFullBalance {
+	o := balance.NewOptions(opts...)
+	return &AtomicCASFullBalance{clock: o.Clock, limits: mcas.Limits(o.Limits), batch: o.BatchCounting, observers: o.Observers, stats: contention.New(o.Stats)}
+}
+
+// Restore returns an AtomicCASFullBalance configured by opts that resumes
+// from s, for example a checkpoint or another implementation's Snapshot.
+// It fails with an error wrapping balance.ErrInvalidSnapshot when s does
+// not fit the configured limits.
+func Restore(s balance.Snapshot, opts ...balance.Option) (*AtomicCASFullBalance, error) {
+	b := NewWithOptions(opts...)
+	if err := b.restore(s); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func init() {
+	balance.Register(balance.Implementation{
+		Name: "Atomic_Balance_CAS_full",
+		New:  func() balance.Balance { return New() },
+		NewWithOptions: func(opts ...balance.Option) balance.Balance {
+			return NewWithOptions(opts...)
+		},
+		Traits: balance.Traits{
+			Strategy:       balance.StrategyCAS,
+			TracksMetadata: true,
+		},
+	})
 }
 
 // Balance returns the current value.
@@ -35,31 +106,347 @@ func (b *AtomicCASFullBalance) TransactionCount() int64 {
 	return b.trx.Load()
 }
 
-// LastUpdated returns the timestamp for the latest mutation.
+// LastUpdated returns the timestamp for the latest mutation. Writers only
+// ever advance it, so it never goes backwards even when a slower writer
+// finishes last.
 func (b *AtomicCASFullBalance) LastUpdated() int64 {
 	return b.updated.Load()
 }
 
-// Add increments the value and metadata.
+// Snapshot loads each field independently. It is not consistent: the CAS
+// only protects the value, and the transaction count and timestamp are
+// updated afterwards, so a concurrent writer can land between the loads.
+// MarshalBinary and MarshalJSON wait for a consistent read instead.
+func (b *AtomicCASFullBalance) Snapshot() balance.Snapshot {
+	return balance.Snapshot{
+		Value:            b.value.Load(),
+		TransactionCount: b.trx.Load(),
+		LastUpdated:      b.updated.Load(),
+	}
+}
+
+// MarshalBinary encodes a consistent Snapshot in the versioned format of
+// balance.Snapshot.MarshalBinary. Unlike Snapshot it waits for a moment
+// when no mutation is part-way through, so under constant writes it can
+// retry for a while.
+func (b *AtomicCASFullBalance) MarshalBinary() ([]byte, error) {
+	return b.stable().MarshalBinary()
+}
+
+// UnmarshalBinary replaces the state with one written by MarshalBinary,
+// keeping the configured clock, limits and observers. The fields are
+// stored one at a time, so it must not run concurrently with other
+// methods.
+func (b *AtomicCASFullBalance) UnmarshalBinary(data []byte) error {
+	var s balance.Snapshot
+	if err := s.UnmarshalBinary(data); err != nil {
+		return err
+	}
+	return b.restore(s)
+}
+
+// MarshalJSON encodes a consistent Snapshot, read as for MarshalBinary, in
+// the versioned format of balance.Snapshot.MarshalJSON.
+func (b *AtomicCASFullBalance) MarshalJSON() ([]byte, error) {
+	return b.stable().MarshalJSON()
+}
+
+// UnmarshalJSON replaces the state with one written by MarshalJSON, with
+// the same restrictions as UnmarshalBinary.
+func (b *AtomicCASFullBalance) UnmarshalJSON(data []byte) error {
+	var s balance.Snapshot
+	if err := s.UnmarshalJSON(data); err != nil {
+		return err
+	}
+	return b.restore(s)
+}
+
+// stable returns a Snapshot whose fields belong together. It only accepts
+// a read made while trx and claimed were equal, so no mutation was between
+// its CAS and its record, and no new one started before the read ended.
+// record advances updated before trx, so updated is settled too.
+func (b *AtomicCASFullBalance) stable() balance.Snapshot {
+	for {
+		trx := b.trx.Load()
+		claimed := b.claimed.Load()
+		if claimed == trx {
+			s := balance.Snapshot{
+				Value:            b.value.Load(),
+				TransactionCount: trx,
+				LastUpdated:      b.updated.Load(),
+			}
+			if b.claimed.Load() == claimed {
+				return s
+			}
+		}
+		runtime.Gosched()
+	}
+}
+
+// restore replaces the state with s after checking it against the limits.
+// The stores are not one atomic step, so it must not run concurrently with
+// other methods.
+func (b *AtomicCASFullBalance) restore(s balance.Snapshot) error {
+	if err := s.Check(b.limits); err != nil {
+		return err
+	}
+
+	b.value.Store(s.Value)
+	b.trx.Store(s.TransactionCount)
+	b.claimed.Store(s.TransactionCount)
+	b.updated.Store(s.LastUpdated)
+	return nil
+}
+
+// Add increments the value and metadata. With a ceiling configured it takes
+// the AddChecked path and drops deposits that would exceed it.
 func (b *AtomicCASFullBalance) Add(amount int64) {
-	b.value.Add(amount)
-	b.trx.Add(1)
-	b.updated.Store(time.Now().UnixNano())
+	if amount <= 0 {
+		return
+	}
+	if b.limits.HasCeiling() {
+		_ = b.AddChecked(amount)
+		return
+	}
+
+	b.deposit(amount, balance.EventAdd)
+}
+
+// AddChecked increments the value via CAS and records metadata, or returns
+// balance.ErrOverflow or balance.ErrCeilingExceeded.
+func (b *AtomicCASFullBalance) AddChecked(amount int64) error {
+	return b.AddContext(context.Background(), amount)
+}
+
+// AddContext behaves like AddChecked but checks ctx before every CAS
+// attempt, so a contended loop can be abandoned.
+func (b *AtomicCASFullBalance) AddContext(ctx context.Context, amount int64) error {
+	if amount <= 0 {
+		return balance.ErrInvalidAmount
+	}
+
+	return b.add(ctx, amount, balance.EventAdd)
 }
 
 // Subtract decrements the value via CAS and records metadata updates.
+// Concurrent withdrawals can never take it below the floor.
 func (b *AtomicCASFullBalance) Subtract(amount int64) error {
+	if amount <= 0 {
+		return balance.ErrInvalidAmount
+	}
+
+	return b.subtract(context.Background(), amount, balance.EventSubtract)
+}
+
+// SubtractContext behaves like Subtract but checks ctx before every CAS
+// attempt, so a contended loop can be abandoned.
+func (b *AtomicCASFullBalance) SubtractContext(ctx context.Context, amount int64) error {
+	if amount <= 0 {
+		return balance.ErrInvalidAmount
+	}
+
+	return b.subtract(ctx, amount, balance.EventSubtract)
+}
+
+// ApplyBatch validates ops against the current value and commits the result
+// with a single CAS, re-validating the whole batch if another writer got
+// there first. Metadata is recorded after the CAS, as for single updates.
+func (b *AtomicCASFullBalance) ApplyBatch(ops []balance.Op) error {
+	if len(ops) == 0 {
+		return nil
+	}
+
+	n := b.batch.Transactions(len(ops))
+	b.claimed.Add(n)
 	for {
 		current := b.value.Load()
-		next := current - amount
-		if next < 0 {
-			return ErrInsufficientFunds
+		next, err := b.limits.Apply(current, ops)
+		if err != nil {
+			b.claimed.Add(-n)
+			b.reject(balance.EventBatch, 0, current, err)
+			return err
 		}
 
 		if b.value.CompareAndSwap(current, next) {
-			b.trx.Add(1)
-			b.updated.Store(time.Now().UnixNano())
+			b.record(balance.EventBatch, 0, current, next, n)
 			return nil
 		}
+		b.stats.Retry()
+	}
+}
+
+// Stats reports how many CAS attempts lost to a concurrent writer and were
+// retried. It is zero unless the balance was built with balance.WithStats.
+func (b *AtomicCASFullBalance) Stats() balance.Stats {
+	return b.stats.Stats()
+}
+
+// TransferTo moves amount into dst, which must also be an
+// *AtomicCASFullBalance. Both values change in one mcas.Update, so the
+// source never goes below its floor and no reader sees the funds in
+// flight; each account's metadata is recorded afterwards, as for single
+// updates. It returns balance.ErrOverflow or balance.ErrCeilingExceeded if
+// the deposit would overflow dst or exceed its ceiling.
+func (b *AtomicCASFullBalance) TransferTo(dst balance.Balance, amount int64) error {
+	if amount <= 0 {
+		return balance.ErrInvalidAmount
+	}
+
+	to, ok := dst.(*AtomicCASFullBalance)
+	if !ok {
+		return balance.ErrIncompatibleBalance
+	}
+	if to == b {
+		return balance.ErrSameAccount
+	}
+
+	b.claimed.Add(1)
+	to.claimed.Add(1)
+	old, next, err := mcas.Update(&b.value, &to.value, func(from, into int64) (int64, int64, error) {
+		debited, err := b.limits.Withdraw(from, amount)
+		if err != nil {
+			return from, into, err
+		}
+		credited, err := to.limits.Deposit(into, amount)
+		if err != nil {
+			return from, into, err
+		}
+		return debited, credited, nil
+	})
+	if err != nil {
+		b.claimed.Add(-1)
+		to.claimed.Add(-1)
+	}
+	if errors.Is(err, balance.ErrInsufficientFunds) {
+		b.reject(balance.EventTransferOut, amount, old[0], err)
+		return err
+	}
+	if err != nil {
+		to.reject(balance.EventTransferIn, amount, old[1], err)
+		return err
+	}
+	b.record(balance.EventTransferOut, amount, old[0], next[0], 1)
+	to.record(balance.EventTransferIn, amount, old[1], next[1], 1)
+	return nil
+}
+
+// BalanceWith returns the value of the receiver and of other, which must
+// also be an *AtomicCASFullBalance, from a single mcas.Load2.
+func (b *AtomicCASFullBalance) BalanceWith(other balance.Balance) (int64, int64, error) {
+	o, ok := other.(*AtomicCASFullBalance)
+	if !ok {
+		return 0, 0, balance.ErrIncompatibleBalance
+	}
+	if o == b {
+		return 0, 0, balance.ErrSameAccount
+	}
+
+	mine, theirs := mcas.Load2(&b.value, &o.value)
+	return mine, theirs, nil
+}
+
+// add deposits amount via CAS and records metadata, reporting it to the
+// observers as kind.
+func (b *AtomicCASFullBalance) add(ctx context.Context, amount int64, kind balance.EventKind) error {
+	b.claimed.Add(1)
+	for {
+		if err := ctx.Err(); err != nil {
+			b.claimed.Add(-1)
+			return err
+		}
+
+		current := b.value.Load()
+		next, err := b.limits.Deposit(current, amount)
+		if err != nil {
+			b.claimed.Add(-1)
+			b.reject(kind, amount, current, err)
+			return err
+		}
+
+		if b.value.CompareAndSwap(current, next) {
+			b.record(kind, amount, current, next, 1)
+			return nil
+		}
+		b.stats.Retry()
+	}
+}
+
+// subtract withdraws amount via CAS and records metadata, reporting it to
+// the observers as kind.
+func (b *AtomicCASFullBalance) subtract(ctx context.Context, amount int64, kind balance.EventKind) error {
+	b.claimed.Add(1)
+	for {
+		if err := ctx.Err(); err != nil {
+			b.claimed.Add(-1)
+			return err
+		}
+
+		current := b.value.Load()
+		next, err := b.limits.Withdraw(current, amount)
+		if err != nil {
+			b.claimed.Add(-1)
+			b.reject(kind, amount, current, err)
+			return err
+		}
+
+		if b.value.CompareAndSwap(current, next) {
+			b.record(kind, amount, current, next, 1)
+			return nil
+		}
+		b.stats.Retry()
+	}
+}
+
+// deposit adds amount and records metadata without checking limits,
+// reporting it to the observers as kind.
+func (b *AtomicCASFullBalance) deposit(amount int64, kind balance.EventKind) {
+	b.claimed.Add(1)
+	next := b.value.Add(amount)
+	b.record(kind, amount, next-amount, next, 1)
+}
+
+// record advances updated to now and trx by n for a mutation that moved
+// value from old to next, then reports it to the observers. trx goes last
+// so it only catches up with claimed once the mutation is fully recorded.
+func (b *AtomicCASFullBalance) record(kind balance.EventKind, amount, old, next, n int64) {
+	now := b.now()
+	monotonic.Advance(&b.updated, now)
+	trx := b.trx.Add(n)
+	if !b.observers.Enabled() {
+		return
+	}
+	b.observers.Notify(balance.Event{
+		Kind:        kind,
+		Amount:      amount,
+		Old:         old,
+		New:         next,
+		Transaction: trx,
+		Timestamp:   now,
+	})
+}
+
+// reject reports a mutation that was rejected with err while value was
+// current to the observers.
+func (b *AtomicCASFullBalance) reject(kind balance.EventKind, amount, current int64, err error) {
+	if !b.observers.Enabled() {
+		return
+	}
+	b.observers.Notify(balance.Event{
+		Kind:        kind,
+		Amount:      amount,
+		Old:         current,
+		New:         current,
+		Transaction: b.trx.Load(),
+		Timestamp:   b.updated.Load(),
+		Err:         err,
+	})
+}
+
+// now reads the configured clock, falling back to the system clock for a
+// zero-value AtomicCASFullBalance.
+func (b *AtomicCASFullBalance) now() int64 {
+	if b.clock == nil {
+		return balance.SystemClock.Now()
 	}
+	return b.clock.Now()
 }
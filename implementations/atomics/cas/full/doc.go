@@ -2,5 +2,9 @@
 Package full contains the CAS-protected balance that mirrors the “full”
 feature set (value, transaction count, timestamp) using only atomic
 operations.
+
+Snapshot is not consistent: the CAS protects only the value, while the
+transaction count and timestamp are bumped separately afterwards, so a
+snapshot can pair a value with the metadata of a different mutation.
 */
 package full
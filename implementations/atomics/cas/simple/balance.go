@@ -1,23 +1,67 @@
 package simple
 
 import (
-	"errors"
+	"context"
 	"sync/atomic"
+
+	balance "github.com/madflojo/atomics-v-rwmutex-examples"
+	"github.com/madflojo/atomics-v-rwmutex-examples/internal/contention"
 )
 
-// ErrInsufficientFunds indicates the balance would become negative.
-var ErrInsufficientFunds = errors.New("insufficient funds")
+// ErrInsufficientFunds indicates the balance would fall below its floor.
+//
+// Deprecated: Use balance.ErrInsufficientFunds, which it is an alias of.
+var ErrInsufficientFunds = balance.ErrInsufficientFunds
 
 // AtomicCASSimpleBalance keeps only the balance value while using CAS to
 // ensure atomic read-modify-write semantics.
+//
+// Observers registered with balance.WithObserver are notified after the CAS
+// or atomic add that applied the mutation, outside any critical section.
+// Each event's Old and New are exact, but events from concurrent mutations
+// can reach observers out of order.
+//
+// It does not implement balance.Transferer or balance.Holder. Moving funds
+// atomically between two accounts, or checking a withdrawal against held
+// funds, takes more than one CAS, and this variant is the single-CAS
+// baseline the benchmarks compare against; atomics/cas/full supports
+// transfers.
 type AtomicCASSimpleBalance struct {
 	// value stores the running balance.
 	value atomic.Int64
+	// limits bounds value; the zero value is a floor of zero.
+	limits balance.Limits
+	// observers is notified after every successful CAS.
+	observers balance.Observers
+	// stats counts CAS retries; it is nil unless built with balance.WithStats.
+	stats *contention.Counters
 }
 
 // New returns a zeroed AtomicCASSimpleBalance.
 func New() *AtomicCASSimpleBalance {
-	return &AtomicCASSimpleBalance{}
+	return NewWithOptions()
+}
+
+// NewWithOptions is New configured by opts, such as balance.WithLimits.
+// The simple variant does not record timestamps, so balance.WithClock has
+// no effect.
+func NewWithOptions(opts ...balance.Option) *AtomicCASSimpleBalance {
+	o := balance.NewOptions(opts...)
+	return &AtomicCASSimpleBalance{limits: o.Limits, observers: o.Observers, stats: contention.New(o.Stats)}
+}
+
+func init() {
+	balance.Register(balance.Implementation{
+		Name: "Atomic_Balance_CAS_simple",
+		New:  func() balance.Balance { return New() },
+		NewWithOptions: func(opts ...balance.Option) balance.Balance {
+			return NewWithOptions(opts...)
+		},
+		Traits: balance.Traits{
+			Strategy:           balance.StrategyCAS,
+			ConsistentSnapshot: true,
+		},
+	})
 }
 
 // Balance returns the current value.
@@ -36,22 +80,149 @@ func (b *AtomicCASSimpleBalance) LastUpdated() int64 {
 	return 0
 }
 
-// Add increments the balance using atomic addition.
+// Snapshot returns the current value. Only the value is tracked, so the
+// snapshot is trivially consistent.
+func (b *AtomicCASSimpleBalance) Snapshot() balance.Snapshot {
+	return balance.Snapshot{Value: b.value.Load()}
+}
+
+// Add increments the balance using atomic addition. With a ceiling
+// configured it takes the AddChecked path and drops deposits that would
+// exceed it.
 func (b *AtomicCASSimpleBalance) Add(amount int64) {
-	b.value.Add(amount)
+	if amount <= 0 {
+		return
+	}
+	if b.limits.HasCeiling() {
+		_ = b.AddChecked(amount)
+		return
+	}
+
+	b.deposit(amount, balance.EventAdd)
+}
+
+// AddChecked increments the balance via CAS or returns balance.ErrOverflow
+// or balance.ErrCeilingExceeded.
+func (b *AtomicCASSimpleBalance) AddChecked(amount int64) error {
+	return b.AddContext(context.Background(), amount)
 }
 
-// Subtract decrements the balance while guaranteeing the update via CAS.
+// AddContext behaves like AddChecked but checks ctx before every CAS
+// attempt, so a contended loop can be abandoned.
+func (b *AtomicCASSimpleBalance) AddContext(ctx context.Context, amount int64) error {
+	if amount <= 0 {
+		return balance.ErrInvalidAmount
+	}
+
+	return b.add(ctx, amount, balance.EventAdd)
+}
+
+// Subtract decrements the balance while guaranteeing the update via CAS,
+// so concurrent withdrawals can never take it below the floor.
 func (b *AtomicCASSimpleBalance) Subtract(amount int64) error {
+	if amount <= 0 {
+		return balance.ErrInvalidAmount
+	}
+
+	return b.subtract(context.Background(), amount, balance.EventSubtract)
+}
+
+// SubtractContext behaves like Subtract but checks ctx before every CAS
+// attempt, so a contended loop can be abandoned.
+func (b *AtomicCASSimpleBalance) SubtractContext(ctx context.Context, amount int64) error {
+	if amount <= 0 {
+		return balance.ErrInvalidAmount
+	}
+
+	return b.subtract(ctx, amount, balance.EventSubtract)
+}
+
+// ApplyBatch validates ops against the current value and commits the result
+// with a single CAS, re-validating the whole batch if another writer got
+// there first.
+func (b *AtomicCASSimpleBalance) ApplyBatch(ops []balance.Op) error {
+	if len(ops) == 0 {
+		return nil
+	}
+
 	for {
 		current := b.value.Load()
-		next := current - amount
-		if next < 0 {
-			return ErrInsufficientFunds
+		next, err := b.limits.Apply(current, ops)
+		if err != nil {
+			b.notify(balance.EventBatch, 0, current, current, err)
+			return err
 		}
 
 		if b.value.CompareAndSwap(current, next) {
+			b.notify(balance.EventBatch, 0, current, next, nil)
 			return nil
 		}
+		b.stats.Retry()
+	}
+}
+
+// Stats reports how many CAS attempts lost to a concurrent writer and were
+// retried. It is zero unless the balance was built with balance.WithStats.
+func (b *AtomicCASSimpleBalance) Stats() balance.Stats {
+	return b.stats.Stats()
+}
+
+// add deposits amount via CAS, reporting it to the observers as kind.
+func (b *AtomicCASSimpleBalance) add(ctx context.Context, amount int64, kind balance.EventKind) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		current := b.value.Load()
+		next, err := b.limits.Deposit(current, amount)
+		if err != nil {
+			b.notify(kind, amount, current, current, err)
+			return err
+		}
+
+		if b.value.CompareAndSwap(current, next) {
+			b.notify(kind, amount, current, next, nil)
+			return nil
+		}
+		b.stats.Retry()
+	}
+}
+
+// subtract withdraws amount via CAS, reporting it to the observers as kind.
+func (b *AtomicCASSimpleBalance) subtract(ctx context.Context, amount int64, kind balance.EventKind) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		current := b.value.Load()
+		next, err := b.limits.Withdraw(current, amount)
+		if err != nil {
+			b.notify(kind, amount, current, current, err)
+			return err
+		}
+
+		if b.value.CompareAndSwap(current, next) {
+			b.notify(kind, amount, current, next, nil)
+			return nil
+		}
+		b.stats.Retry()
+	}
+}
+
+// deposit adds amount without checking limits, reporting it to the
+// observers as kind.
+func (b *AtomicCASSimpleBalance) deposit(amount int64, kind balance.EventKind) {
+	next := b.value.Add(amount)
+	b.notify(kind, amount, next-amount, next, nil)
+}
+
+// notify reports a mutation that moved value from old to next, or that was
+// rejected with err, to the registered observers.
+func (b *AtomicCASSimpleBalance) notify(kind balance.EventKind, amount, old, next int64, err error) {
+	if !b.observers.Enabled() {
+		return
 	}
+	b.observers.Notify(balance.Event{Kind: kind, Amount: amount, Old: old, New: next, Err: err})
 }
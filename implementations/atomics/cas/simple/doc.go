@@ -2,5 +2,7 @@
 Package simple provides the lean CAS-protected balance that only manages
 an atomic value. It skips metadata like transaction counts to keep the
 implementation minimal.
+
+Snapshot only carries the value, so it is trivially consistent.
 */
 package simple
@@ -0,0 +1,415 @@
+package cow
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+
+	balance "github.com/madflojo/atomics-v-rwmutex-examples"
+	"github.com/madflojo/atomics-v-rwmutex-examples/internal/contention"
+)
+
+// state is an immutable view of the account. A new state is allocated for
+// every mutation and never modified once published.
+type state struct {
+	// value holds the running balance.
+	value int64
+	// trx counts successful mutations.
+	trx int64
+	// updated records the timestamp of the latest mutation.
+	updated int64
+	// held is the part of value reserved by Reserve.
+	held int64
+	// pair is set on a frozen copy published while a transfer is in
+	// flight; see pair.
+	pair *pair
+}
+
+// AtomicCOWBalance publishes immutable state snapshots through an
+// atomic.Pointer.
+//
+// Observers registered with balance.WithObserver are notified after the CAS
+// that published the new state, outside any critical section, so events from
+// concurrent mutations can reach observers out of order. Every field of an
+// event comes from the states the CAS moved between, so Transaction is exact
+// and sorting on it restores mutation order.
+type AtomicCOWBalance struct {
+	// state points at the current immutable state; it is never nil.
+	state atomic.Pointer[state]
+	// clock stamps updated.
+	clock balance.Clock
+	// limits bounds value; the zero value is a floor of zero.
+	limits balance.Limits
+	// batch selects how ApplyBatch advances trx.
+	batch balance.BatchCounting
+	// observers is notified after every successful CAS.
+	observers balance.Observers
+	// stats counts CAS retries; it is nil unless built with balance.WithStats.
+	stats *contention.Counters
+}
+
+// New creates a zeroed AtomicCOWBalance.
+func New() *AtomicCOWBalance {
+	return NewWithOptions()
+}
+
+// NewWithOptions is New configured by opts, such as balance.WithClock,
+// balance.WithLimits, and balance.WithBatchCounting.
+func NewWithOptions(opts ...balance.Option) *AtomicCOWBalance {
+	o := balance.NewOptions(opts...)
+	b := &AtomicCOWBalance{clock: o.Clock, limits: o.Limits, batch: o.BatchCounting, observers: o.Observers, stats: contention.New(o.Stats)}
+	b.state.Store(&state{})
+	return b
+}
+
+func init() {
+	balance.Register(balance.Implementation{
+		Name: "Atomic_Balance_COW",
+		New:  func() balance.Balance { return New() },
+		NewWithOptions: func(opts ...balance.Option) balance.Balance {
+			return NewWithOptions(opts...)
+		},
+		Traits: balance.Traits{
+			Strategy:           balance.StrategyCopyOnWrite,
+			TracksMetadata:     true,
+			ConsistentSnapshot: true,
+		},
+	})
+}
+
+// Balance returns the current value.
+func (b *AtomicCOWBalance) Balance() int64 {
+	return b.load().value
+}
+
+// TransactionCount reports completed mutations.
+func (b *AtomicCOWBalance) TransactionCount() int64 {
+	return b.load().trx
+}
+
+// LastUpdated returns the timestamp for the latest mutation.
+func (b *AtomicCOWBalance) LastUpdated() int64 {
+	return b.load().updated
+}
+
+// Snapshot loads a single state pointer, so the result is always
+// consistent.
+func (b *AtomicCOWBalance) Snapshot() balance.Snapshot {
+	s := b.load()
+	return balance.Snapshot{Value: s.value, TransactionCount: s.trx, LastUpdated: s.updated}
+}
+
+// Add publishes a new state with the increased value and metadata. With a
+// ceiling configured it takes the AddChecked path and drops deposits that
+// would exceed it.
+func (b *AtomicCOWBalance) Add(amount int64) {
+	if amount <= 0 {
+		return
+	}
+	if b.limits.HasCeiling() {
+		_ = b.AddChecked(amount)
+		return
+	}
+
+	b.deposit(amount, balance.EventAdd)
+}
+
+// AddChecked publishes a new state with the increased value and metadata,
+// or returns balance.ErrOverflow or balance.ErrCeilingExceeded.
+func (b *AtomicCOWBalance) AddChecked(amount int64) error {
+	return b.AddContext(context.Background(), amount)
+}
+
+// AddContext behaves like AddChecked but checks ctx before every CAS
+// attempt, so a contended loop can be abandoned.
+func (b *AtomicCOWBalance) AddContext(ctx context.Context, amount int64) error {
+	if amount <= 0 {
+		return balance.ErrInvalidAmount
+	}
+
+	return b.update(ctx, amount, balance.EventAdd, balance.Limits.Deposit)
+}
+
+// Subtract publishes a new state with the decreased value and metadata or
+// returns ErrInsufficientFunds if it would go below the floor.
+func (b *AtomicCOWBalance) Subtract(amount int64) error {
+	if amount <= 0 {
+		return balance.ErrInvalidAmount
+	}
+
+	return b.update(context.Background(), amount, balance.EventSubtract, balance.Limits.Withdraw)
+}
+
+// SubtractContext behaves like Subtract but checks ctx before every CAS
+// attempt, so a contended loop can be abandoned.
+func (b *AtomicCOWBalance) SubtractContext(ctx context.Context, amount int64) error {
+	if amount <= 0 {
+		return balance.ErrInvalidAmount
+	}
+
+	return b.update(ctx, amount, balance.EventSubtract, balance.Limits.Withdraw)
+}
+
+// ApplyBatch validates ops against the current state and publishes the
+// result as one new state, so readers see either none or all of the batch.
+func (b *AtomicCOWBalance) ApplyBatch(ops []balance.Op) error {
+	if len(ops) == 0 {
+		return nil
+	}
+
+	now := b.clock.Now()
+	for {
+		current := b.current()
+		value, err := b.limits.Holding(current.held).Apply(current.value, ops)
+		if err != nil {
+			b.notify(balance.EventBatch, 0, current, current, err)
+			return err
+		}
+
+		next := &state{
+			value:   value,
+			trx:     current.trx + b.batch.Transactions(len(ops)),
+			updated: max(now, current.updated),
+			held:    current.held,
+		}
+
+		if b.state.CompareAndSwap(current, next) {
+			b.notify(balance.EventBatch, 0, current, next, nil)
+			return nil
+		}
+		b.stats.Retry()
+	}
+}
+
+// Stats reports how many CAS attempts lost to a concurrent writer and were
+// retried. It is zero unless the balance was built with balance.WithStats.
+func (b *AtomicCOWBalance) Stats() balance.Stats {
+	return b.stats.Stats()
+}
+
+// TransferTo moves amount into dst, which must also be an *AtomicCOWBalance.
+// Both accounts' new states are published by one swap, so the source never
+// goes below its floor and no reader sees the funds in flight. It returns
+// balance.ErrOverflow or balance.ErrCeilingExceeded if the deposit would
+// overflow dst or exceed its ceiling.
+func (b *AtomicCOWBalance) TransferTo(dst balance.Balance, amount int64) error {
+	if amount <= 0 {
+		return balance.ErrInvalidAmount
+	}
+
+	to, ok := dst.(*AtomicCOWBalance)
+	if !ok {
+		return balance.ErrIncompatibleBalance
+	}
+	if to == b {
+		return balance.ErrSameAccount
+	}
+
+	fromNow, toNow := b.clock.Now(), to.clock.Now()
+	old, next, err := swap(b, to, func(from, into *state) (*state, *state, error) {
+		debited, err := b.limits.Holding(from.held).Withdraw(from.value, amount)
+		if err != nil {
+			return nil, nil, err
+		}
+		credited, err := to.limits.Deposit(into.value, amount)
+		if err != nil {
+			return nil, nil, err
+		}
+		return &state{value: debited, trx: from.trx + 1, updated: max(fromNow, from.updated), held: from.held},
+			&state{value: credited, trx: into.trx + 1, updated: max(toNow, into.updated), held: into.held},
+			nil
+	})
+	if errors.Is(err, balance.ErrInsufficientFunds) {
+		b.notify(balance.EventTransferOut, amount, old[0], old[0], err)
+		return err
+	}
+	if err != nil {
+		to.notify(balance.EventTransferIn, amount, old[1], old[1], err)
+		return err
+	}
+	b.notify(balance.EventTransferOut, amount, old[0], next[0], nil)
+	to.notify(balance.EventTransferIn, amount, old[1], next[1], nil)
+	return nil
+}
+
+// BalanceWith returns the value of the receiver and of other, which must
+// also be an *AtomicCOWBalance, from states published by one swap.
+func (b *AtomicCOWBalance) BalanceWith(other balance.Balance) (int64, int64, error) {
+	o, ok := other.(*AtomicCOWBalance)
+	if !ok {
+		return 0, 0, balance.ErrIncompatibleBalance
+	}
+	if o == b {
+		return 0, 0, balance.ErrSameAccount
+	}
+
+	old, _, _ := swap(b, o, func(mine, theirs *state) (*state, *state, error) {
+		return mine.thaw(), theirs.thaw(), nil
+	})
+	return old[0].value, old[1].value, nil
+}
+
+// Available returns the value less held funds from a single state.
+func (b *AtomicCOWBalance) Available() int64 {
+	s := b.load()
+	return s.value - s.held
+}
+
+// Held returns the amount reserved by outstanding holds.
+func (b *AtomicCOWBalance) Held() int64 {
+	return b.load().held
+}
+
+// Reserve publishes a new state with amount moved from the available
+// balance to the held one, or returns ErrInsufficientFunds.
+func (b *AtomicCOWBalance) Reserve(amount int64) error {
+	if amount <= 0 {
+		return balance.ErrInvalidAmount
+	}
+
+	return b.hold(balance.EventHold, amount, func(current *state) (int64, int64, error) {
+		held, err := b.limits.Reserve(current.value, current.held, amount)
+		return current.value, held, err
+	})
+}
+
+// Settle publishes a new state that ends a reservation of release,
+// withdrawing capture of it and returning the rest to the available
+// balance.
+func (b *AtomicCOWBalance) Settle(release, capture int64) error {
+	return b.hold(balance.EventSettle, capture, func(current *state) (int64, int64, error) {
+		return balance.Settle(current.value, current.held, release, capture)
+	})
+}
+
+// update publishes a new state whose value is apply(limits, current value,
+// amount), with limits raised by any held funds, and reports it to the
+// observers as kind, or returns apply's error.
+func (b *AtomicCOWBalance) update(
+	ctx context.Context,
+	amount int64,
+	kind balance.EventKind,
+	apply func(l balance.Limits, current, amount int64) (int64, error),
+) error {
+	now := b.clock.Now()
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		current := b.current()
+		value, err := apply(b.limits.Holding(current.held), current.value, amount)
+		if err != nil {
+			b.notify(kind, amount, current, current, err)
+			return err
+		}
+
+		next := &state{
+			value:   value,
+			trx:     current.trx + 1,
+			updated: max(now, current.updated),
+			held:    current.held,
+		}
+
+		if b.state.CompareAndSwap(current, next) {
+			b.notify(kind, amount, current, next, nil)
+			return nil
+		}
+		b.stats.Retry()
+	}
+}
+
+// hold publishes a new state with the value and held amount returned by
+// apply and reports it to the observers as kind, or returns apply's error.
+// Invalid amounts are returned without being reported.
+func (b *AtomicCOWBalance) hold(
+	kind balance.EventKind,
+	amount int64,
+	apply func(current *state) (int64, int64, error),
+) error {
+	now := b.clock.Now()
+	for {
+		current := b.current()
+		value, held, err := apply(current)
+		if errors.Is(err, balance.ErrInvalidAmount) {
+			return err
+		}
+		if err != nil {
+			b.notify(kind, amount, current, current, err)
+			return err
+		}
+
+		next := &state{
+			value:   value,
+			trx:     current.trx + 1,
+			updated: max(now, current.updated),
+			held:    held,
+		}
+
+		if b.state.CompareAndSwap(current, next) {
+			b.notify(kind, amount, current, next, nil)
+			return nil
+		}
+		b.stats.Retry()
+	}
+}
+
+// deposit publishes a new state with the increased value and metadata
+// without checking limits, reporting it to the observers as kind.
+func (b *AtomicCOWBalance) deposit(amount int64, kind balance.EventKind) {
+	now := b.clock.Now()
+	for {
+		current := b.current()
+		next := &state{
+			value:   current.value + amount,
+			trx:     current.trx + 1,
+			updated: max(now, current.updated),
+			held:    current.held,
+		}
+
+		if b.state.CompareAndSwap(current, next) {
+			b.notify(kind, amount, current, next, nil)
+			return
+		}
+		b.stats.Retry()
+	}
+}
+
+// load returns the current state, resolving a frozen one through its
+// transfer.
+func (b *AtomicCOWBalance) load() *state {
+	s := b.state.Load()
+	if s.pair != nil {
+		return s.pair.view(b, s)
+	}
+	return s
+}
+
+// current returns the published state for a writer to replace, first
+// helping any transfer that has frozen it out of the way.
+func (b *AtomicCOWBalance) current() *state {
+	for {
+		s := b.state.Load()
+		if s.pair == nil {
+			return s
+		}
+		s.pair.help()
+	}
+}
+
+// notify reports a mutation that moved the state from old to next, or that
+// was rejected with err, to the registered observers.
+func (b *AtomicCOWBalance) notify(kind balance.EventKind, amount int64, old, next *state, err error) {
+	if !b.observers.Enabled() {
+		return
+	}
+	b.observers.Notify(balance.Event{
+		Kind:        kind,
+		Amount:      amount,
+		Old:         old.value,
+		New:         next.value,
+		Transaction: next.trx,
+		Timestamp:   next.updated,
+		Err:         err,
+	})
+}
@@ -0,0 +1,173 @@
+package cow
+
+import (
+	"runtime"
+	"sync/atomic"
+
+	"github.com/madflojo/atomics-v-rwmutex-examples/internal/lockorder"
+)
+
+// pair is an update to two accounts in flight. It publishes a frozen copy
+// of each account's state that points back at the pair, in address order,
+// and once both are frozen the first goroutine to decide it wins. Readers
+// resolve a frozen state through its pair instead of waiting; writers help
+// by deciding a pair whose accounts are both frozen, or abandoning one
+// that is still freezing, which then starts over.
+type pair struct {
+	// accts are the accounts being updated, in address order.
+	accts [2]*AtomicCOWBalance
+	// frozen holds each account's frozen state. An entry is fixed once
+	// the account publishes it.
+	frozen [2]atomic.Pointer[state]
+	// fn computes the new states. It may run on several goroutines, so it
+	// must not have side effects.
+	fn func(old [2]*state) ([2]*state, error)
+	// ready is set once both accounts are frozen.
+	ready atomic.Bool
+	// decision is nil until the pair is decided.
+	decision atomic.Pointer[decision]
+}
+
+// decision is the outcome of a pair. It is immutable once published.
+type decision struct {
+	// next holds the accounts' new states unless err or abandoned is set.
+	next [2]*state
+	// err is fn's refusal; both accounts keep their old state.
+	err error
+	// abandoned is set by a writer that needed an account before both
+	// were frozen.
+	abandoned bool
+}
+
+// abandon is the decision writers publish for a pair they cut short.
+var abandon = &decision{abandoned: true}
+
+// swap replaces the states of a and b with fn's results as one atomic
+// step and returns the states it moved between. If fn returns an error,
+// neither account changes and the error is returned with next unset. fn
+// may run more than once and on other goroutines, so it must not have side
+// effects, and it must return new states.
+func swap(a, b *AtomicCOWBalance, fn func(a, b *state) (*state, *state, error)) (old, next [2]*state, err error) {
+	swapped := lockorder.Less(b, a)
+	ordered := func(v [2]*state) [2]*state {
+		if swapped {
+			v[0], v[1] = v[1], v[0]
+		}
+		return v
+	}
+
+	accts := [2]*AtomicCOWBalance{a, b}
+	if swapped {
+		accts[0], accts[1] = b, a
+	}
+	for {
+		p := &pair{accts: accts}
+		p.fn = func(old [2]*state) ([2]*state, error) {
+			old = ordered(old)
+			na, nb, err := fn(old[0], old[1])
+			return ordered([2]*state{na, nb}), err
+		}
+		p.run()
+
+		d := p.decision.Load()
+		if !d.abandoned {
+			return ordered([2]*state{p.frozen[0].Load(), p.frozen[1].Load()}), ordered(d.next), d.err
+		}
+		runtime.Gosched()
+	}
+}
+
+// run freezes the accounts in address order, decides the pair, and
+// unfreezes them. Only the goroutine that created p calls it.
+func (p *pair) run() {
+	for i := range p.accts {
+		if !p.freeze(i) {
+			p.finish()
+			return
+		}
+	}
+	p.ready.Store(true)
+	p.decide()
+	p.finish()
+}
+
+// freeze publishes a frozen copy of account i's state, helping any other
+// pair out of the way. It returns false if p was abandoned first.
+func (p *pair) freeze(i int) bool {
+	acct := p.accts[i]
+	for {
+		if p.decision.Load() != nil {
+			return false
+		}
+		current := acct.state.Load()
+		if current.pair != nil {
+			current.pair.help()
+			continue
+		}
+		frozen := current.thaw()
+		frozen.pair = p
+		p.frozen[i].Store(frozen)
+		if acct.state.CompareAndSwap(current, frozen) {
+			return true
+		}
+	}
+}
+
+// decide applies fn to the frozen states and publishes the decision
+// unless another goroutine already has. The caller must have seen ready
+// set.
+func (p *pair) decide() {
+	if p.decision.Load() != nil {
+		return
+	}
+	next, err := p.fn([2]*state{p.frozen[0].Load(), p.frozen[1].Load()})
+	p.decision.CompareAndSwap(nil, &decision{next: next, err: err})
+}
+
+// help moves p out of a writer's way: it decides p if both accounts are
+// frozen, abandons it otherwise, and unfreezes them.
+func (p *pair) help() {
+	if p.decision.Load() == nil {
+		if p.ready.Load() {
+			p.decide()
+		} else {
+			p.decision.CompareAndSwap(nil, abandon)
+		}
+	}
+	p.finish()
+}
+
+// finish replaces every frozen state p still has published with its
+// decided state. p must be decided.
+func (p *pair) finish() {
+	d := p.decision.Load()
+	for i, acct := range p.accts {
+		current := acct.state.Load()
+		if current.pair != p {
+			continue
+		}
+		next := current.thaw()
+		if !d.abandoned && d.err == nil {
+			next = d.next[i]
+		}
+		acct.state.CompareAndSwap(current, next)
+	}
+}
+
+// view returns the state acct has as of now, given that it published
+// frozen for p: the decided state once p commits, the frozen one before.
+func (p *pair) view(acct *AtomicCOWBalance, frozen *state) *state {
+	d := p.decision.Load()
+	if d == nil || d.abandoned || d.err != nil {
+		return frozen
+	}
+	if p.accts[0] == acct {
+		return d.next[0]
+	}
+	return d.next[1]
+}
+
+// thaw returns an unfrozen copy of s.
+func (s *state) thaw() *state {
+	return &state{value: s.value, trx: s.trx, updated: s.updated, held: s.held}
+}
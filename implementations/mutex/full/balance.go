@@ -1,64 +1,433 @@
 package full
 
 import (
-	"errors"
+	"context"
 	"sync"
-	"time"
+
+	balance "github.com/madflojo/atomics-v-rwmutex-examples"
+	"github.com/madflojo/atomics-v-rwmutex-examples/internal/contention"
+	"github.com/madflojo/atomics-v-rwmutex-examples/internal/lockorder"
 )
 
-// ErrInsufficientFunds indicates the balance would go negative.
-var ErrInsufficientFunds = errors.New("insufficient funds")
+// ErrInsufficientFunds indicates the balance would go below its floor.
+//
+// Deprecated: Use balance.ErrInsufficientFunds, which it is an alias of.
+var ErrInsufficientFunds = balance.ErrInsufficientFunds
 
 // MutexFullBalance protects balance metadata with a standard Mutex.
+//
+// Observers registered with balance.WithObserver are notified while the
+// lock is held, so they see events in mutation order.
 type MutexFullBalance struct {
 	mu      sync.Mutex
 	value   int64
 	trx     int64
 	updated int64
+	// held is the part of value reserved by Reserve.
+	held int64
+	// clock stamps updated; nil means balance.SystemClock.
+	clock balance.Clock
+	// limits bounds value; the zero value is a floor of zero.
+	limits balance.Limits
+	// batch selects how ApplyBatch advances trx.
+	batch balance.BatchCounting
+	// observers is notified of every mutation while mu is held.
+	observers balance.Observers
+	// stats counts lock waits; it is nil unless built with balance.WithStats.
+	stats *contention.Counters
 }
 
 // New returns a zeroed MutexFullBalance.
-func New() *MutexFullBalance { return &MutexFullBalance{} }
+func New() *MutexFullBalance {
+	return NewWithOptions()
+}
+
+// NewWithOptions is New configured by opts, such as balance.WithClock,
+// balance.WithLimits, and balance.WithBatchCounting.
+func NewWithOptions(opts ...balance.Option) *MutexFullBalance {
+	o := balance.NewOptions(opts...)
+	return &MutexFullBalance{clock: o.Clock, limits: o.Limits, batch: o.BatchCounting, observers: o.Observers, stats: contention.New(o.Stats)}
+}
+
+// Restore returns a MutexFullBalance configured by opts that starts from
+// the state in s, such as a Snapshot taken from another implementation. It
+// returns an error wrapping balance.ErrInvalidSnapshot if s does not fit
+// the configured limits.
+func Restore(s balance.Snapshot, opts ...balance.Option) (*MutexFullBalance, error) {
+	b := NewWithOptions(opts...)
+	if err := b.restore(s); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func init() {
+	balance.Register(balance.Implementation{
+		Name: "Mutex_Balance_full",
+		New:  func() balance.Balance { return New() },
+		NewWithOptions: func(opts ...balance.Option) balance.Balance {
+			return NewWithOptions(opts...)
+		},
+		Traits: balance.Traits{
+			Strategy:           balance.StrategyMutex,
+			TracksMetadata:     true,
+			ConsistentSnapshot: true,
+			OrderedEvents:      true,
+		},
+	})
+}
 
 // Balance returns the current value under a lock.
 func (b *MutexFullBalance) Balance() int64 {
-	b.mu.Lock()
+	b.lock()
 	defer b.mu.Unlock()
 	return b.value
 }
 
 // TransactionCount returns how many mutations have executed.
 func (b *MutexFullBalance) TransactionCount() int64 {
-	b.mu.Lock()
+	b.lock()
 	defer b.mu.Unlock()
 	return b.trx
 }
 
 // LastUpdated returns the timestamp of the latest mutation.
 func (b *MutexFullBalance) LastUpdated() int64 {
-	b.mu.Lock()
+	b.lock()
 	defer b.mu.Unlock()
 	return b.updated
 }
 
-// Add increments the balance and records metadata.
+// Snapshot returns every field under a single lock, so the result is
+// always consistent.
+func (b *MutexFullBalance) Snapshot() balance.Snapshot {
+	b.lock()
+	defer b.mu.Unlock()
+	return balance.Snapshot{Value: b.value, TransactionCount: b.trx, LastUpdated: b.updated}
+}
+
+// MarshalBinary encodes a consistent Snapshot in the versioned format of
+// balance.Snapshot.MarshalBinary.
+func (b *MutexFullBalance) MarshalBinary() ([]byte, error) {
+	return b.Snapshot().MarshalBinary()
+}
+
+// UnmarshalBinary replaces the state with one written by MarshalBinary,
+// keeping the configured clock, limits, observers and held funds. It fails
+// with an error wrapping balance.ErrInvalidSnapshot if the new value would
+// leave the held funds below the floor.
+func (b *MutexFullBalance) UnmarshalBinary(data []byte) error {
+	var s balance.Snapshot
+	if err := s.UnmarshalBinary(data); err != nil {
+		return err
+	}
+	return b.restore(s)
+}
+
+// MarshalJSON encodes a consistent Snapshot in the versioned format of
+// balance.Snapshot.MarshalJSON.
+func (b *MutexFullBalance) MarshalJSON() ([]byte, error) {
+	return b.Snapshot().MarshalJSON()
+}
+
+// UnmarshalJSON replaces the state with one written by MarshalJSON,
+// keeping the configured clock, limits, observers and held funds, and
+// failing like UnmarshalBinary.
+func (b *MutexFullBalance) UnmarshalJSON(data []byte) error {
+	var s balance.Snapshot
+	if err := s.UnmarshalJSON(data); err != nil {
+		return err
+	}
+	return b.restore(s)
+}
+
+// restore replaces the state with s after checking it against the limits.
+// Outstanding holds stay in place, so s must also cover the held funds.
+func (b *MutexFullBalance) restore(s balance.Snapshot) error {
+	b.lock()
+	defer b.mu.Unlock()
+	if err := s.Check(b.limits.Holding(b.held)); err != nil {
+		return err
+	}
+	b.value, b.trx, b.updated = s.Value, s.TransactionCount, s.LastUpdated
+	return nil
+}
+
+// Add increments the balance and records metadata. With a ceiling
+// configured, deposits that would exceed it are dropped.
 func (b *MutexFullBalance) Add(amount int64) {
-	b.mu.Lock()
+	if amount <= 0 {
+		return
+	}
+
+	b.lock()
 	defer b.mu.Unlock()
+	if b.limits.HasCeiling() {
+		_ = b.addChecked(amount)
+		return
+	}
 	b.value += amount
 	b.trx++
-	b.updated = time.Now().UnixNano()
+	b.updated = b.now()
+	b.notify(balance.EventAdd, amount, b.value-amount, nil)
+}
+
+// AddChecked increments the balance and records metadata, or returns
+// balance.ErrOverflow.
+func (b *MutexFullBalance) AddChecked(amount int64) error {
+	if amount <= 0 {
+		return balance.ErrInvalidAmount
+	}
+
+	b.lock()
+	defer b.mu.Unlock()
+	return b.addChecked(amount)
+}
+
+// AddContext behaves like AddChecked but gives up if ctx is done before the
+// lock is acquired.
+func (b *MutexFullBalance) AddContext(ctx context.Context, amount int64) error {
+	if amount <= 0 {
+		return balance.ErrInvalidAmount
+	}
+
+	if err := b.stats.LockContext(ctx, &b.mu); err != nil {
+		return err
+	}
+	defer b.mu.Unlock()
+	return b.addChecked(amount)
 }
 
 // Subtract decrements the balance or returns ErrInsufficientFunds.
 func (b *MutexFullBalance) Subtract(amount int64) error {
-	b.mu.Lock()
+	if amount <= 0 {
+		return balance.ErrInvalidAmount
+	}
+
+	b.lock()
+	defer b.mu.Unlock()
+	return b.subtract(amount)
+}
+
+// SubtractContext behaves like Subtract but gives up if ctx is done before
+// the lock is acquired.
+func (b *MutexFullBalance) SubtractContext(ctx context.Context, amount int64) error {
+	if amount <= 0 {
+		return balance.ErrInvalidAmount
+	}
+
+	if err := b.stats.LockContext(ctx, &b.mu); err != nil {
+		return err
+	}
+	defer b.mu.Unlock()
+	return b.subtract(amount)
+}
+
+// ApplyBatch validates ops against the balance and commits them under one
+// lock, so no reader sees part of the batch.
+func (b *MutexFullBalance) ApplyBatch(ops []balance.Op) error {
+	if len(ops) == 0 {
+		return nil
+	}
+
+	b.lock()
+	defer b.mu.Unlock()
+	current := b.value
+	next, err := b.limits.Holding(b.held).Apply(current, ops)
+	if err != nil {
+		b.notify(balance.EventBatch, 0, current, err)
+		return err
+	}
+	b.value = next
+	b.trx += b.batch.Transactions(len(ops))
+	b.updated = b.now()
+	b.notify(balance.EventBatch, 0, current, nil)
+	return nil
+}
+
+// Stats reports how often and how long callers waited for the lock. It is
+// zero unless the balance was built with balance.WithStats.
+func (b *MutexFullBalance) Stats() balance.Stats {
+	return b.stats.Stats()
+}
+
+// TransferTo moves amount into dst, which must also be a *MutexFullBalance.
+// Both locks are held for the whole move and acquired in address order, so
+// opposing transfers cannot deadlock and no reader sees the funds in flight.
+// It returns balance.ErrOverflow or balance.ErrCeilingExceeded if the
+// deposit would overflow dst or exceed its ceiling.
+func (b *MutexFullBalance) TransferTo(dst balance.Balance, amount int64) error {
+	if amount <= 0 {
+		return balance.ErrInvalidAmount
+	}
+
+	to, ok := dst.(*MutexFullBalance)
+	if !ok {
+		return balance.ErrIncompatibleBalance
+	}
+	if to == b {
+		return balance.ErrSameAccount
+	}
+
+	first, second := lockorder.Order(b, to)
+	first.lock()
+	defer first.mu.Unlock()
+	second.lock()
+	defer second.mu.Unlock()
+
+	debited, err := b.limits.Holding(b.held).Withdraw(b.value, amount)
+	if err != nil {
+		b.notify(balance.EventTransferOut, amount, b.value, err)
+		return err
+	}
+	credited, err := to.limits.Deposit(to.value, amount)
+	if err != nil {
+		to.notify(balance.EventTransferIn, amount, to.value, err)
+		return err
+	}
+
+	b.value = debited
+	b.trx++
+	b.updated = max(b.now(), b.updated)
+	to.value = credited
+	to.trx++
+	to.updated = max(to.now(), to.updated)
+	b.notify(balance.EventTransferOut, amount, b.value+amount, nil)
+	to.notify(balance.EventTransferIn, amount, to.value-amount, nil)
+	return nil
+}
+
+// BalanceWith returns the value of the receiver and of other, which must
+// also be a *MutexFullBalance. Both locks are held for the read and
+// acquired in address order, as TransferTo acquires them, so no transfer
+// can land between the two reads.
+func (b *MutexFullBalance) BalanceWith(other balance.Balance) (int64, int64, error) {
+	o, ok := other.(*MutexFullBalance)
+	if !ok {
+		return 0, 0, balance.ErrIncompatibleBalance
+	}
+	if o == b {
+		return 0, 0, balance.ErrSameAccount
+	}
+
+	first, second := lockorder.Order(b, o)
+	first.lock()
+	defer first.mu.Unlock()
+	second.lock()
+	defer second.mu.Unlock()
+	return b.value, o.value, nil
+}
+
+// Available returns the value less held funds under a lock.
+func (b *MutexFullBalance) Available() int64 {
+	b.lock()
+	defer b.mu.Unlock()
+	return b.value - b.held
+}
+
+// Held returns the amount reserved by outstanding holds.
+func (b *MutexFullBalance) Held() int64 {
+	b.lock()
+	defer b.mu.Unlock()
+	return b.held
+}
+
+// Reserve moves amount from the available balance to the held one under
+// the lock, or returns ErrInsufficientFunds.
+func (b *MutexFullBalance) Reserve(amount int64) error {
+	if amount <= 0 {
+		return balance.ErrInvalidAmount
+	}
+
+	b.lock()
+	defer b.mu.Unlock()
+	held, err := b.limits.Reserve(b.value, b.held, amount)
+	if err != nil {
+		b.notify(balance.EventHold, amount, b.value, err)
+		return err
+	}
+	b.held = held
+	b.trx++
+	b.updated = b.now()
+	b.notify(balance.EventHold, amount, b.value, nil)
+	return nil
+}
+
+// Settle ends a reservation of release under the lock, withdrawing capture
+// of it and returning the rest to the available balance.
+func (b *MutexFullBalance) Settle(release, capture int64) error {
+	b.lock()
 	defer b.mu.Unlock()
-	if b.value-amount < 0 {
-		return ErrInsufficientFunds
+	value, held, err := balance.Settle(b.value, b.held, release, capture)
+	if err != nil {
+		return err
+	}
+	b.value, b.held = value, held
+	b.trx++
+	b.updated = b.now()
+	b.notify(balance.EventSettle, capture, b.value+capture, nil)
+	return nil
+}
+
+// notify reports a mutation that moved value from old, or that was rejected
+// with err, to the registered observers. The caller must hold mu.
+func (b *MutexFullBalance) notify(kind balance.EventKind, amount, old int64, err error) {
+	if !b.observers.Enabled() {
+		return
 	}
-	b.value -= amount
+	b.observers.Notify(balance.Event{
+		Kind:        kind,
+		Amount:      amount,
+		Old:         old,
+		New:         b.value,
+		Transaction: b.trx,
+		Timestamp:   b.updated,
+		Err:         err,
+	})
+}
+
+// addChecked applies a checked deposit. The caller must hold mu.
+func (b *MutexFullBalance) addChecked(amount int64) error {
+	next, err := b.limits.Deposit(b.value, amount)
+	if err != nil {
+		b.notify(balance.EventAdd, amount, b.value, err)
+		return err
+	}
+	b.value = next
+	b.trx++
+	b.updated = b.now()
+	b.notify(balance.EventAdd, amount, b.value-amount, nil)
+	return nil
+}
+
+// subtract applies a withdrawal. The caller must hold mu.
+func (b *MutexFullBalance) subtract(amount int64) error {
+	next, err := b.limits.Holding(b.held).Withdraw(b.value, amount)
+	if err != nil {
+		b.notify(balance.EventSubtract, amount, b.value, err)
+		return err
+	}
+	b.value = next
 	b.trx++
-	b.updated = time.Now().UnixNano()
+	b.updated = b.now()
+	b.notify(balance.EventSubtract, amount, b.value+amount, nil)
 	return nil
 }
+
+// now reads the configured clock, falling back to the system clock for a
+// zero-value MutexFullBalance.
+func (b *MutexFullBalance) now() int64 {
+	if b.clock == nil {
+		return balance.SystemClock.Now()
+	}
+	return b.clock.Now()
+}
+
+// lock acquires mu, recording the wait when stats are enabled.
+func (b *MutexFullBalance) lock() {
+	if b.stats == nil {
+		b.mu.Lock()
+		return
+	}
+	b.stats.Lock(&b.mu)
+}
@@ -1,5 +1,7 @@
 /*
 Package full implements a feature-rich Mutex-backed balance that tracks value,
 transaction counts, and timestamps.
+
+Snapshot is consistent: every field is read under the same lock.
 */
 package full
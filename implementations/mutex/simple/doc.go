@@ -1,5 +1,7 @@
 /*
 Package simple offers a standard Mutex-backed balance that only protects the
 value field. It mirrors the RWMutex simple example but uses sync.Mutex.
+
+Snapshot only carries the value, so it is trivially consistent.
 */
 package simple
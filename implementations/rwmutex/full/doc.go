@@ -2,5 +2,7 @@
 Package full implements the feature-rich RWMutex-backed balance that
 tracks value, transaction counts, and timestamps while allowing
 concurrent readers.
+
+Snapshot is consistent: every field is read under the same read lock.
 */
 package full
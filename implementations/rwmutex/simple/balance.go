@@ -1,29 +1,70 @@
 package simple
 
 import (
-	"errors"
+	"context"
 	"sync"
+
+	balance "github.com/madflojo/atomics-v-rwmutex-examples"
+	"github.com/madflojo/atomics-v-rwmutex-examples/internal/contention"
+	"github.com/madflojo/atomics-v-rwmutex-examples/internal/lockorder"
 )
 
-// ErrInsufficientFunds indicates a withdrawal would push the balance negative.
-var ErrInsufficientFunds = errors.New("insufficient funds")
+// ErrInsufficientFunds indicates a withdrawal would take the balance below
+// its floor.
+//
+// Deprecated: Use balance.ErrInsufficientFunds, which it is an alias of.
+var ErrInsufficientFunds = balance.ErrInsufficientFunds
 
 // RWMutexSimpleBalance uses an RWMutex to guard just the balance value.
+//
+// Observers registered with balance.WithObserver are notified while the
+// write lock is held, so they see events in mutation order.
 type RWMutexSimpleBalance struct {
 	// mu protects value.
 	mu sync.RWMutex
 	// value stores the running balance.
 	value int64
+	// held is the part of value reserved by Reserve.
+	held int64
+	// limits bounds value; the zero value is a floor of zero.
+	limits balance.Limits
+	// observers is notified of every mutation while mu is held.
+	observers balance.Observers
+	// stats counts lock waits; it is nil unless built with balance.WithStats.
+	stats *contention.Counters
 }
 
 // New constructs a zeroed RWMutexSimpleBalance.
 func New() *RWMutexSimpleBalance {
-	return &RWMutexSimpleBalance{}
+	return NewWithOptions()
+}
+
+// NewWithOptions is New configured by opts, such as balance.WithLimits.
+// The simple variant does not record timestamps, so balance.WithClock has
+// no effect.
+func NewWithOptions(opts ...balance.Option) *RWMutexSimpleBalance {
+	o := balance.NewOptions(opts...)
+	return &RWMutexSimpleBalance{limits: o.Limits, observers: o.Observers, stats: contention.New(o.Stats)}
+}
+
+func init() {
+	balance.Register(balance.Implementation{
+		Name: "RWMutex_Balance_simple",
+		New:  func() balance.Balance { return New() },
+		NewWithOptions: func(opts ...balance.Option) balance.Balance {
+			return NewWithOptions(opts...)
+		},
+		Traits: balance.Traits{
+			Strategy:           balance.StrategyRWMutex,
+			ConsistentSnapshot: true,
+			OrderedEvents:      true,
+		},
+	})
 }
 
 // Balance returns the current value under a read lock.
 func (b *RWMutexSimpleBalance) Balance() int64 {
-	b.mu.RLock()
+	b.rlock()
 	defer b.mu.RUnlock()
 	return b.value
 }
@@ -39,22 +80,273 @@ func (b *RWMutexSimpleBalance) LastUpdated() int64 {
 	return 0
 }
 
-// Add increments the value with exclusive access.
+// Snapshot returns the current value under a read lock.
+func (b *RWMutexSimpleBalance) Snapshot() balance.Snapshot {
+	b.rlock()
+	defer b.mu.RUnlock()
+	return balance.Snapshot{Value: b.value}
+}
+
+// Add increments the value with exclusive access. With a ceiling configured,
+// deposits that would exceed it are dropped.
 func (b *RWMutexSimpleBalance) Add(amount int64) {
-	b.mu.Lock()
+	if amount <= 0 {
+		return
+	}
+
+	b.lock()
 	defer b.mu.Unlock()
+	if b.limits.HasCeiling() {
+		_ = b.addChecked(amount)
+		return
+	}
 	b.value += amount
+	b.notify(balance.EventAdd, amount, b.value-amount, nil)
+}
+
+// AddChecked increments the value or returns balance.ErrOverflow or
+// balance.ErrCeilingExceeded.
+func (b *RWMutexSimpleBalance) AddChecked(amount int64) error {
+	if amount <= 0 {
+		return balance.ErrInvalidAmount
+	}
+
+	b.lock()
+	defer b.mu.Unlock()
+	return b.addChecked(amount)
+}
+
+// AddContext behaves like AddChecked but gives up if ctx is done before the
+// lock is acquired.
+func (b *RWMutexSimpleBalance) AddContext(ctx context.Context, amount int64) error {
+	if amount <= 0 {
+		return balance.ErrInvalidAmount
+	}
+
+	if err := b.stats.LockContext(ctx, &b.mu); err != nil {
+		return err
+	}
+	defer b.mu.Unlock()
+	return b.addChecked(amount)
 }
 
 // Subtract decrements the value or returns ErrInsufficientFunds.
 func (b *RWMutexSimpleBalance) Subtract(amount int64) error {
-	b.mu.Lock()
+	if amount <= 0 {
+		return balance.ErrInvalidAmount
+	}
+
+	b.lock()
 	defer b.mu.Unlock()
+	return b.subtract(amount)
+}
+
+// SubtractContext behaves like Subtract but gives up if ctx is done before
+// the lock is acquired.
+func (b *RWMutexSimpleBalance) SubtractContext(ctx context.Context, amount int64) error {
+	if amount <= 0 {
+		return balance.ErrInvalidAmount
+	}
 
-	if b.value-amount < 0 {
-		return ErrInsufficientFunds
+	if err := b.stats.LockContext(ctx, &b.mu); err != nil {
+		return err
 	}
+	defer b.mu.Unlock()
+	return b.subtract(amount)
+}
 
-	b.value -= amount
+// ApplyBatch validates ops against the balance and commits them under one
+// write lock, so no reader sees part of the batch.
+func (b *RWMutexSimpleBalance) ApplyBatch(ops []balance.Op) error {
+	if len(ops) == 0 {
+		return nil
+	}
+
+	b.lock()
+	defer b.mu.Unlock()
+	current := b.value
+	next, err := b.limits.Holding(b.held).Apply(current, ops)
+	if err != nil {
+		b.notify(balance.EventBatch, 0, current, err)
+		return err
+	}
+	b.value = next
+	b.notify(balance.EventBatch, 0, current, nil)
 	return nil
 }
+
+// Stats reports how often and how long callers waited for the lock. It is
+// zero unless the balance was built with balance.WithStats.
+func (b *RWMutexSimpleBalance) Stats() balance.Stats {
+	return b.stats.Stats()
+}
+
+// TransferTo moves amount into dst, which must also be a
+// *RWMutexSimpleBalance. Both locks are held for the whole move and acquired
+// in address order, so opposing transfers cannot deadlock and no reader sees
+// the funds in flight. It returns balance.ErrOverflow or
+// balance.ErrCeilingExceeded if the deposit would overflow dst or exceed
+// its ceiling.
+func (b *RWMutexSimpleBalance) TransferTo(dst balance.Balance, amount int64) error {
+	if amount <= 0 {
+		return balance.ErrInvalidAmount
+	}
+
+	to, ok := dst.(*RWMutexSimpleBalance)
+	if !ok {
+		return balance.ErrIncompatibleBalance
+	}
+	if to == b {
+		return balance.ErrSameAccount
+	}
+
+	first, second := lockorder.Order(b, to)
+	first.lock()
+	defer first.mu.Unlock()
+	second.lock()
+	defer second.mu.Unlock()
+
+	debited, err := b.limits.Holding(b.held).Withdraw(b.value, amount)
+	if err != nil {
+		b.notify(balance.EventTransferOut, amount, b.value, err)
+		return err
+	}
+	credited, err := to.limits.Deposit(to.value, amount)
+	if err != nil {
+		to.notify(balance.EventTransferIn, amount, to.value, err)
+		return err
+	}
+
+	b.value = debited
+	to.value = credited
+	b.notify(balance.EventTransferOut, amount, b.value+amount, nil)
+	to.notify(balance.EventTransferIn, amount, to.value-amount, nil)
+	return nil
+}
+
+// BalanceWith returns the value of the receiver and of other, which must
+// also be a *RWMutexSimpleBalance. Both read locks are held for the read
+// and acquired in address order, as TransferTo acquires its write locks,
+// so no transfer can land between the two reads.
+func (b *RWMutexSimpleBalance) BalanceWith(other balance.Balance) (int64, int64, error) {
+	o, ok := other.(*RWMutexSimpleBalance)
+	if !ok {
+		return 0, 0, balance.ErrIncompatibleBalance
+	}
+	if o == b {
+		return 0, 0, balance.ErrSameAccount
+	}
+
+	first, second := lockorder.Order(b, o)
+	first.rlock()
+	defer first.mu.RUnlock()
+	second.rlock()
+	defer second.mu.RUnlock()
+	return b.value, o.value, nil
+}
+
+// Available returns the value less held funds under a lock.
+func (b *RWMutexSimpleBalance) Available() int64 {
+	b.rlock()
+	defer b.mu.RUnlock()
+	return b.value - b.held
+}
+
+// Held returns the amount reserved by outstanding holds.
+func (b *RWMutexSimpleBalance) Held() int64 {
+	b.rlock()
+	defer b.mu.RUnlock()
+	return b.held
+}
+
+// Reserve moves amount from the available balance to the held one under
+// the lock, or returns ErrInsufficientFunds.
+func (b *RWMutexSimpleBalance) Reserve(amount int64) error {
+	if amount <= 0 {
+		return balance.ErrInvalidAmount
+	}
+
+	b.lock()
+	defer b.mu.Unlock()
+	held, err := b.limits.Reserve(b.value, b.held, amount)
+	if err != nil {
+		b.notify(balance.EventHold, amount, b.value, err)
+		return err
+	}
+	b.held = held
+	b.notify(balance.EventHold, amount, b.value, nil)
+	return nil
+}
+
+// Settle ends a reservation of release under the lock, withdrawing capture
+// of it and returning the rest to the available balance.
+func (b *RWMutexSimpleBalance) Settle(release, capture int64) error {
+	b.lock()
+	defer b.mu.Unlock()
+	value, held, err := balance.Settle(b.value, b.held, release, capture)
+	if err != nil {
+		return err
+	}
+	b.value, b.held = value, held
+	b.notify(balance.EventSettle, capture, b.value+capture, nil)
+	return nil
+}
+
+// notify reports a mutation that moved value from old, or that was rejected
+// with err, to the registered observers. The caller must hold mu.
+func (b *RWMutexSimpleBalance) notify(kind balance.EventKind, amount, old int64, err error) {
+	if !b.observers.Enabled() {
+		return
+	}
+	b.observers.Notify(balance.Event{
+		Kind:   kind,
+		Amount: amount,
+		Old:    old,
+		New:    b.value,
+		Err:    err,
+	})
+}
+
+// addChecked applies a checked deposit. The caller must hold mu.
+func (b *RWMutexSimpleBalance) addChecked(amount int64) error {
+	next, err := b.limits.Deposit(b.value, amount)
+	if err != nil {
+		b.notify(balance.EventAdd, amount, b.value, err)
+		return err
+	}
+
+	b.value = next
+	b.notify(balance.EventAdd, amount, b.value-amount, nil)
+	return nil
+}
+
+// subtract applies a withdrawal. The caller must hold mu.
+func (b *RWMutexSimpleBalance) subtract(amount int64) error {
+	next, err := b.limits.Holding(b.held).Withdraw(b.value, amount)
+	if err != nil {
+		b.notify(balance.EventSubtract, amount, b.value, err)
+		return err
+	}
+
+	b.value = next
+	b.notify(balance.EventSubtract, amount, b.value+amount, nil)
+	return nil
+}
+
+// lock acquires mu, recording the wait when stats are enabled.
+func (b *RWMutexSimpleBalance) lock() {
+	if b.stats == nil {
+		b.mu.Lock()
+		return
+	}
+	b.stats.Lock(&b.mu)
+}
+
+// rlock acquires mu for reading, recording the wait when stats are enabled.
+func (b *RWMutexSimpleBalance) rlock() {
+	if b.stats == nil {
+		b.mu.RLock()
+		return
+	}
+	b.stats.RLock(&b.mu)
+}
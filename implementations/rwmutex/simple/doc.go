@@ -2,5 +2,7 @@
 Package simple offers the RWMutex-backed balance that only protects the
 value field, keeping the example lightweight while still showcasing lock
 usage.
+
+Snapshot only carries the value, so it is trivially consistent.
 */
 package simple
@@ -0,0 +1,415 @@
+package seqlock
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	balance "github.com/madflojo/atomics-v-rwmutex-examples"
+	"github.com/madflojo/atomics-v-rwmutex-examples/internal/ctxlock"
+	"github.com/madflojo/atomics-v-rwmutex-examples/internal/lockorder"
+)
+
+// SeqlockBalance lets readers take consistent multi-field snapshots without
+// acquiring a lock.
+//
+// Observers registered with balance.WithObserver are notified while the
+// writer lock is held, after the write window closes, so they see events in
+// mutation order.
+type SeqlockBalance struct {
+	// mu serializes writers.
+	mu sync.Mutex
+	// seq is odd while a write is in progress.
+	seq atomic.Uint64
+	// value stores the running balance.
+	value atomic.Int64
+	// trx counts successful mutations.
+	trx atomic.Int64
+	// updated records the timestamp of the most recent mutation.
+	updated atomic.Int64
+	// held is the part of value reserved by Reserve.
+	held atomic.Int64
+	// clock stamps updated; nil means balance.SystemClock.
+	clock balance.Clock
+	// limits bounds value; the zero value is a floor of zero.
+	limits balance.Limits
+	// batch selects how ApplyBatch advances trx.
+	batch balance.BatchCounting
+	// observers is notified of every mutation while mu is held.
+	observers balance.Observers
+}
+
+// New returns a zeroed SeqlockBalance.
+func New() *SeqlockBalance {
+	return NewWithOptions()
+}
+
+// NewWithOptions is New configured by opts, such as balance.WithClock,
+// balance.WithLimits, and balance.WithBatchCounting.
+func NewWithOptions(opts ...balance.Option) *SeqlockBalance {
+	o := balance.NewOptions(opts...)
+	return &SeqlockBalance{clock: o.Clock, limits: o.Limits, batch: o.BatchCounting, observers: o.Observers}
+}
+
+func init() {
+	balance.Register(balance.Implementation{
+		Name: "Seqlock_Balance",
+		New:  func() balance.Balance { return New() },
+		NewWithOptions: func(opts ...balance.Option) balance.Balance {
+			return NewWithOptions(opts...)
+		},
+		Traits: balance.Traits{
+			Strategy:           balance.StrategySeqlock,
+			TracksMetadata:     true,
+			ConsistentSnapshot: true,
+			OrderedEvents:      true,
+		},
+	})
+}
+
+// Balance returns the current value.
+func (b *SeqlockBalance) Balance() int64 {
+	return b.Snapshot().Value
+}
+
+// TransactionCount returns how many mutations have executed.
+func (b *SeqlockBalance) TransactionCount() int64 {
+	return b.Snapshot().TransactionCount
+}
+
+// LastUpdated returns the timestamp of the latest mutation.
+func (b *SeqlockBalance) LastUpdated() int64 {
+	return b.Snapshot().LastUpdated
+}
+
+// Snapshot reads every field inside one sequence window, retrying until no
+// writer interfered, so the result is always consistent.
+func (b *SeqlockBalance) Snapshot() balance.Snapshot {
+	for {
+		start := b.seq.Load()
+		if start&1 == 1 {
+			// A writer is mid-update; let it finish.
+			runtime.Gosched()
+			continue
+		}
+
+		snap := balance.Snapshot{
+			Value:            b.value.Load(),
+			TransactionCount: b.trx.Load(),
+			LastUpdated:      b.updated.Load(),
+		}
+
+		if b.seq.Load() == start {
+			return snap
+		}
+	}
+}
+
+// Add increments the balance and records metadata. With a ceiling
+// configured, deposits that would exceed it are dropped.
+func (b *SeqlockBalance) Add(amount int64) {
+	if amount <= 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.limits.HasCeiling() {
+		_ = b.addChecked(amount)
+		return
+	}
+
+	b.seq.Add(1)
+	b.value.Add(amount)
+	b.trx.Add(1)
+	b.updated.Store(b.now())
+	b.seq.Add(1)
+	b.notify(balance.EventAdd, amount, b.value.Load()-amount, nil)
+}
+
+// AddChecked increments the balance and records metadata, or returns
+// balance.ErrOverflow or balance.ErrCeilingExceeded.
+func (b *SeqlockBalance) AddChecked(amount int64) error {
+	if amount <= 0 {
+		return balance.ErrInvalidAmount
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.addChecked(amount)
+}
+
+// AddContext behaves like AddChecked but gives up if ctx is done before the
+// lock is acquired.
+func (b *SeqlockBalance) AddContext(ctx context.Context, amount int64) error {
+	if amount <= 0 {
+		return balance.ErrInvalidAmount
+	}
+
+	if err := ctxlock.Lock(ctx, &b.mu); err != nil {
+		return err
+	}
+	defer b.mu.Unlock()
+	return b.addChecked(amount)
+}
+
+// Subtract decrements the balance or returns ErrInsufficientFunds.
+func (b *SeqlockBalance) Subtract(amount int64) error {
+	if amount <= 0 {
+		return balance.ErrInvalidAmount
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.subtract(amount)
+}
+
+// SubtractContext behaves like Subtract but gives up if ctx is done before
+// the lock is acquired.
+func (b *SeqlockBalance) SubtractContext(ctx context.Context, amount int64) error {
+	if amount <= 0 {
+		return balance.ErrInvalidAmount
+	}
+
+	if err := ctxlock.Lock(ctx, &b.mu); err != nil {
+		return err
+	}
+	defer b.mu.Unlock()
+	return b.subtract(amount)
+}
+
+// ApplyBatch validates ops against the balance and commits them inside one
+// write window, so snapshots see either none or all of the batch.
+func (b *SeqlockBalance) ApplyBatch(ops []balance.Op) error {
+	if len(ops) == 0 {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	current := b.value.Load()
+	next, err := b.limits.Holding(b.held.Load()).Apply(current, ops)
+	if err != nil {
+		b.notify(balance.EventBatch, 0, current, err)
+		return err
+	}
+
+	b.seq.Add(1)
+	b.value.Store(next)
+	b.trx.Add(b.batch.Transactions(len(ops)))
+	b.updated.Store(b.now())
+	b.seq.Add(1)
+	b.notify(balance.EventBatch, 0, current, nil)
+	return nil
+}
+
+// TransferTo moves amount into dst, which must also be a *SeqlockBalance.
+// Both locks are held for the whole move and acquired in address order, so
+// opposing transfers cannot deadlock and no reader sees the funds in flight.
+// It returns balance.ErrOverflow or balance.ErrCeilingExceeded if the
+// deposit would overflow dst or exceed its ceiling.
+func (b *SeqlockBalance) TransferTo(dst balance.Balance, amount int64) error {
+	if amount <= 0 {
+		return balance.ErrInvalidAmount
+	}
+
+	to, ok := dst.(*SeqlockBalance)
+	if !ok {
+		return balance.ErrIncompatibleBalance
+	}
+	if to == b {
+		return balance.ErrSameAccount
+	}
+
+	first, second := lockorder.Order(b, to)
+	first.mu.Lock()
+	defer first.mu.Unlock()
+	second.mu.Lock()
+	defer second.mu.Unlock()
+
+	debited, err := b.limits.Holding(b.held.Load()).Withdraw(b.value.Load(), amount)
+	if err != nil {
+		b.notify(balance.EventTransferOut, amount, b.value.Load(), err)
+		return err
+	}
+	credited, err := to.limits.Deposit(to.value.Load(), amount)
+	if err != nil {
+		to.notify(balance.EventTransferIn, amount, to.value.Load(), err)
+		return err
+	}
+
+	fromNow, toNow := max(b.now(), b.updated.Load()), max(to.now(), to.updated.Load())
+	b.seq.Add(1)
+	to.seq.Add(1)
+	b.value.Store(debited)
+	b.trx.Add(1)
+	b.updated.Store(fromNow)
+	to.value.Store(credited)
+	to.trx.Add(1)
+	to.updated.Store(toNow)
+	to.seq.Add(1)
+	b.seq.Add(1)
+	b.notify(balance.EventTransferOut, amount, debited+amount, nil)
+	to.notify(balance.EventTransferIn, amount, credited-amount, nil)
+	return nil
+}
+
+// BalanceWith returns the value of the receiver and of other, which must
+// also be a *SeqlockBalance. Like Snapshot it takes no lock: it reads both
+// values inside both sequence windows, retrying until neither account was
+// written, and TransferTo keeps both windows open for its whole move.
+func (b *SeqlockBalance) BalanceWith(other balance.Balance) (int64, int64, error) {
+	o, ok := other.(*SeqlockBalance)
+	if !ok {
+		return 0, 0, balance.ErrIncompatibleBalance
+	}
+	if o == b {
+		return 0, 0, balance.ErrSameAccount
+	}
+
+	for {
+		mine, theirs := b.seq.Load(), o.seq.Load()
+		if mine&1 == 1 || theirs&1 == 1 {
+			// A writer is mid-update; let it finish.
+			runtime.Gosched()
+			continue
+		}
+
+		from, into := b.value.Load(), o.value.Load()
+
+		if b.seq.Load() == mine && o.seq.Load() == theirs {
+			return from, into, nil
+		}
+	}
+}
+
+// Available returns the value less held funds, both read inside one
+// sequence window.
+func (b *SeqlockBalance) Available() int64 {
+	for {
+		start := b.seq.Load()
+		if start&1 == 1 {
+			// A writer is mid-update; let it finish.
+			runtime.Gosched()
+			continue
+		}
+
+		available := b.value.Load() - b.held.Load()
+
+		if b.seq.Load() == start {
+			return available
+		}
+	}
+}
+
+// Held returns the amount reserved by outstanding holds.
+func (b *SeqlockBalance) Held() int64 {
+	return b.held.Load()
+}
+
+// Reserve moves amount from the available balance to the held one inside
+// one write window, or returns ErrInsufficientFunds.
+func (b *SeqlockBalance) Reserve(amount int64) error {
+	if amount <= 0 {
+		return balance.ErrInvalidAmount
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	held, err := b.limits.Reserve(b.value.Load(), b.held.Load(), amount)
+	if err != nil {
+		b.notify(balance.EventHold, amount, b.value.Load(), err)
+		return err
+	}
+
+	b.seq.Add(1)
+	b.held.Store(held)
+	b.trx.Add(1)
+	b.updated.Store(b.now())
+	b.seq.Add(1)
+	b.notify(balance.EventHold, amount, b.value.Load(), nil)
+	return nil
+}
+
+// Settle ends a reservation of release inside one write window,
+// withdrawing capture of it and returning the rest to the available
+// balance.
+func (b *SeqlockBalance) Settle(release, capture int64) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	value, held, err := balance.Settle(b.value.Load(), b.held.Load(), release, capture)
+	if err != nil {
+		return err
+	}
+
+	b.seq.Add(1)
+	b.value.Store(value)
+	b.held.Store(held)
+	b.trx.Add(1)
+	b.updated.Store(b.now())
+	b.seq.Add(1)
+	b.notify(balance.EventSettle, capture, value+capture, nil)
+	return nil
+}
+
+// notify reports a mutation that moved value from old, or that was rejected
+// with err, to the registered observers. The caller must hold mu.
+func (b *SeqlockBalance) notify(kind balance.EventKind, amount, old int64, err error) {
+	if !b.observers.Enabled() {
+		return
+	}
+	b.observers.Notify(balance.Event{
+		Kind:        kind,
+		Amount:      amount,
+		Old:         old,
+		New:         b.value.Load(),
+		Transaction: b.trx.Load(),
+		Timestamp:   b.updated.Load(),
+		Err:         err,
+	})
+}
+
+// addChecked applies a checked deposit. The caller must hold mu.
+func (b *SeqlockBalance) addChecked(amount int64) error {
+	next, err := b.limits.Deposit(b.value.Load(), amount)
+	if err != nil {
+		b.notify(balance.EventAdd, amount, b.value.Load(), err)
+		return err
+	}
+
+	b.seq.Add(1)
+	b.value.Store(next)
+	b.trx.Add(1)
+	b.updated.Store(b.now())
+	b.seq.Add(1)
+	b.notify(balance.EventAdd, amount, b.value.Load()-amount, nil)
+	return nil
+}
+
+// subtract applies a withdrawal. The caller must hold mu.
+func (b *SeqlockBalance) subtract(amount int64) error {
+	// Writers are serialized, so the value cannot change under this check.
+	next, err := b.limits.Holding(b.held.Load()).Withdraw(b.value.Load(), amount)
+	if err != nil {
+		b.notify(balance.EventSubtract, amount, b.value.Load(), err)
+		return err
+	}
+
+	b.seq.Add(1)
+	b.value.Store(next)
+	b.trx.Add(1)
+	b.updated.Store(b.now())
+	b.seq.Add(1)
+	b.notify(balance.EventSubtract, amount, b.value.Load()+amount, nil)
+	return nil
+}
+
+// now reads the configured clock, falling back to the system clock for a
+// zero-value SeqlockBalance.
+func (b *SeqlockBalance) now() int64 {
+	if b.clock == nil {
+		return balance.SystemClock.Now()
+	}
+	return b.clock.Now()
+}
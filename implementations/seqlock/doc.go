@@ -0,0 +1,11 @@
+/*
+Package seqlock implements a sequence-lock balance for read-mostly
+workloads. Writers serialize on a Mutex and bump a sequence counter to an
+odd number before touching the fields and back to an even number after.
+Readers never write shared memory: they load the sequence, read the
+fields, and retry if the sequence was odd or changed in the meantime.
+
+Snapshot is consistent: every field is read inside the same sequence
+window.
+*/
+package seqlock
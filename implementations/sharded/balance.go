@@ -0,0 +1,421 @@
+package sharded
+
+import (
+	"context"
+	"math/rand/v2"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	balance "github.com/madflojo/atomics-v-rwmutex-examples"
+	"github.com/madflojo/atomics-v-rwmutex-examples/internal/ctxlock"
+	"github.com/madflojo/atomics-v-rwmutex-examples/internal/lockorder"
+)
+
+// cacheLineSize is the padding target for each stripe. 64 bytes covers the
+// common x86-64 and arm64 cache line.
+const cacheLineSize = 64
+
+// maxCollects bounds how many passes Balance makes looking for a stable sum.
+const maxCollects = 8
+
+// stripe holds one slice of the balance on its own cache line.
+type stripe struct {
+	value atomic.Int64
+	_     [cacheLineSize - 8]byte
+}
+
+// ShardedBalance spreads deposits across padded stripes and aggregates them
+// on read.
+//
+// Observers registered with balance.WithObserver are notified while the
+// exclusive lock is held, so they see events in mutation order. Registering
+// one routes every Add through the locked AddChecked path, giving up the
+// lock-free deposits.
+type ShardedBalance struct {
+	// mu is held shared by readers and exclusively by Subtract, so stripes
+	// only ever grow while a reader is summing them.
+	mu sync.RWMutex
+	// stripes holds the partial balances; its length is a power of two.
+	stripes []stripe
+	// mask selects a stripe from a random number.
+	mask uint64
+	// held is the part of the total reserved by Reserve; mu guards it.
+	held int64
+	// limits bounds the total; the zero value is a floor of zero.
+	limits balance.Limits
+	// observers is notified of every mutation while mu is held exclusively.
+	observers balance.Observers
+}
+
+// New returns a zeroed ShardedBalance with one stripe per processor,
+// rounded up to a power of two.
+func New() *ShardedBalance {
+	return NewWithOptions()
+}
+
+// NewWithOptions is New configured by opts, such as balance.WithLimits.
+// The sharded variant does not record timestamps, so balance.WithClock has
+// no effect.
+func NewWithOptions(opts ...balance.Option) *ShardedBalance {
+	o := balance.NewOptions(opts...)
+	n := 1
+	for n < runtime.GOMAXPROCS(0) {
+		n <<= 1
+	}
+	return &ShardedBalance{stripes: make([]stripe, n), mask: uint64(n - 1), limits: o.Limits, observers: o.Observers}
+}
+
+func init() {
+	balance.Register(balance.Implementation{
+		Name: "Sharded_Balance",
+		New:  func() balance.Balance { return New() },
+		NewWithOptions: func(opts ...balance.Option) balance.Balance {
+			return NewWithOptions(opts...)
+		},
+		Traits: balance.Traits{
+			Strategy:           balance.StrategySharded,
+			ConsistentSnapshot: true,
+			OrderedEvents:      true,
+		},
+	})
+}
+
+// Balance returns the aggregated value across every stripe.
+func (b *ShardedBalance) Balance() int64 {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.collect()
+}
+
+// TransactionCount always returns zero because the sharded variant does not
+// track metadata.
+func (b *ShardedBalance) TransactionCount() int64 {
+	return 0
+}
+
+// LastUpdated always reports zero because timestamps are not recorded.
+func (b *ShardedBalance) LastUpdated() int64 {
+	return 0
+}
+
+// Snapshot returns the aggregated value. Only the value is tracked, so the
+// snapshot is trivially consistent.
+func (b *ShardedBalance) Snapshot() balance.Snapshot {
+	return balance.Snapshot{Value: b.Balance()}
+}
+
+// Add increments a randomly chosen stripe without taking any lock. With a
+// ceiling configured or an observer registered it has to read the aggregated
+// total, so it takes the AddChecked path and drops deposits that would
+// exceed the ceiling.
+func (b *ShardedBalance) Add(amount int64) {
+	if amount <= 0 {
+		return
+	}
+	if b.limits.HasCeiling() || b.observers.Enabled() {
+		_ = b.AddChecked(amount)
+		return
+	}
+
+	b.deposit(amount)
+}
+
+// AddChecked escalates to the exclusive lock so the aggregated total can be
+// checked, then increments a stripe or returns balance.ErrOverflow or
+// balance.ErrCeilingExceeded. Individual stripes may wrap; only the total
+// has to fit. Without a ceiling, concurrent unchecked Adds are not covered
+// by the overflow check.
+func (b *ShardedBalance) AddChecked(amount int64) error {
+	if amount <= 0 {
+		return balance.ErrInvalidAmount
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.addChecked(amount)
+}
+
+// AddContext behaves like AddChecked but gives up if ctx is done before the
+// exclusive lock is acquired.
+func (b *ShardedBalance) AddContext(ctx context.Context, amount int64) error {
+	if amount <= 0 {
+		return balance.ErrInvalidAmount
+	}
+
+	if err := ctxlock.Lock(ctx, &b.mu); err != nil {
+		return err
+	}
+	defer b.mu.Unlock()
+	return b.addChecked(amount)
+}
+
+// Subtract takes the exclusive lock, verifies the aggregated balance stays at
+// or above the floor, and borrows from stripes until the withdrawal is
+// satisfied.
+func (b *ShardedBalance) Subtract(amount int64) error {
+	if amount <= 0 {
+		return balance.ErrInvalidAmount
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.withdraw(amount)
+}
+
+// SubtractContext behaves like Subtract but gives up if ctx is done before
+// the exclusive lock is acquired.
+func (b *ShardedBalance) SubtractContext(ctx context.Context, amount int64) error {
+	if amount <= 0 {
+		return balance.ErrInvalidAmount
+	}
+
+	if err := ctxlock.Lock(ctx, &b.mu); err != nil {
+		return err
+	}
+	defer b.mu.Unlock()
+	return b.withdraw(amount)
+}
+
+// ApplyBatch takes the exclusive lock, validates ops against the aggregated
+// balance, and applies the net change to the stripes, so no reader sees part
+// of the batch.
+func (b *ShardedBalance) ApplyBatch(ops []balance.Op) error {
+	if len(ops) == 0 {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	current := b.sum()
+	next, err := b.limits.Holding(b.held).Apply(current, ops)
+	if err != nil {
+		b.notify(balance.EventBatch, 0, current, err)
+		return err
+	}
+
+	switch {
+	case next > current:
+		b.deposit(next - current)
+	case next < current:
+		b.take(current - next)
+	}
+	b.notify(balance.EventBatch, 0, current, nil)
+	return nil
+}
+
+// TransferTo moves amount into dst, which must also be a *ShardedBalance.
+// Both exclusive locks are held for the whole move and acquired in address
+// order, so opposing transfers cannot deadlock and no reader sees the funds
+// in flight. It returns balance.ErrOverflow or balance.ErrCeilingExceeded
+// if the deposit would overflow dst or exceed its ceiling.
+func (b *ShardedBalance) TransferTo(dst balance.Balance, amount int64) error {
+	if amount <= 0 {
+		return balance.ErrInvalidAmount
+	}
+
+	to, ok := dst.(*ShardedBalance)
+	if !ok {
+		return balance.ErrIncompatibleBalance
+	}
+	if to == b {
+		return balance.ErrSameAccount
+	}
+
+	first, second := lockorder.Order(b, to)
+	first.mu.Lock()
+	defer first.mu.Unlock()
+	second.mu.Lock()
+	defer second.mu.Unlock()
+
+	from, into := b.sum(), to.sum()
+	if _, err := b.limits.Holding(b.held).Withdraw(from, amount); err != nil {
+		b.notify(balance.EventTransferOut, amount, from, err)
+		return err
+	}
+	if _, err := to.limits.Deposit(into, amount); err != nil {
+		to.notify(balance.EventTransferIn, amount, into, err)
+		return err
+	}
+	b.take(amount)
+	to.deposit(amount)
+	b.notify(balance.EventTransferOut, amount, from, nil)
+	to.notify(balance.EventTransferIn, amount, into, nil)
+	return nil
+}
+
+// BalanceWith returns the value of the receiver and of other, which must
+// also be a *ShardedBalance. Both locks are held shared for the read and
+// acquired in address order, as TransferTo acquires them exclusively, so no
+// transfer can land between the two reads.
+func (b *ShardedBalance) BalanceWith(other balance.Balance) (int64, int64, error) {
+	o, ok := other.(*ShardedBalance)
+	if !ok {
+		return 0, 0, balance.ErrIncompatibleBalance
+	}
+	if o == b {
+		return 0, 0, balance.ErrSameAccount
+	}
+
+	first, second := lockorder.Order(b, o)
+	first.mu.RLock()
+	defer first.mu.RUnlock()
+	second.mu.RLock()
+	defer second.mu.RUnlock()
+	return b.collect(), o.collect(), nil
+}
+
+// Available returns the aggregated value less held funds under the shared
+// lock.
+func (b *ShardedBalance) Available() int64 {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.collect() - b.held
+}
+
+// Held returns the amount reserved by outstanding holds.
+func (b *ShardedBalance) Held() int64 {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.held
+}
+
+// Reserve takes the exclusive lock and moves amount from the available
+// balance to the held one, or returns ErrInsufficientFunds.
+func (b *ShardedBalance) Reserve(amount int64) error {
+	if amount <= 0 {
+		return balance.ErrInvalidAmount
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	current := b.sum()
+	held, err := b.limits.Reserve(current, b.held, amount)
+	if err != nil {
+		b.notify(balance.EventHold, amount, current, err)
+		return err
+	}
+	b.held = held
+	b.notify(balance.EventHold, amount, current, nil)
+	return nil
+}
+
+// Settle takes the exclusive lock and ends a reservation of release,
+// borrowing capture of it from the stripes and returning the rest to the
+// available balance.
+func (b *ShardedBalance) Settle(release, capture int64) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	current := b.sum()
+	_, held, err := balance.Settle(current, b.held, release, capture)
+	if err != nil {
+		return err
+	}
+	b.held = held
+	b.take(capture)
+	b.notify(balance.EventSettle, capture, current, nil)
+	return nil
+}
+
+// withdraw borrows amount from the stripes. The caller must hold mu
+// exclusively.
+func (b *ShardedBalance) withdraw(amount int64) error {
+	current := b.sum()
+	if _, err := b.limits.Holding(b.held).Withdraw(current, amount); err != nil {
+		b.notify(balance.EventSubtract, amount, current, err)
+		return err
+	}
+
+	b.take(amount)
+	b.notify(balance.EventSubtract, amount, current, nil)
+	return nil
+}
+
+// take borrows amount from the stripes without checking limits. The caller
+// must hold mu exclusively.
+func (b *ShardedBalance) take(amount int64) {
+	// Concurrent Adds can only grow a stripe while the lock is held, so
+	// every stripe still holds at least what the sum above observed.
+	remaining := amount
+	for i := range b.stripes {
+		if remaining == 0 {
+			break
+		}
+		s := &b.stripes[i].value
+		for {
+			current := s.Load()
+			take := min(current, remaining)
+			if take <= 0 {
+				break
+			}
+			if s.CompareAndSwap(current, current-take) {
+				remaining -= take
+				break
+			}
+		}
+	}
+	// Whatever the stripes could not cover is overdraft; any stripe can
+	// carry it because only the total is bounded.
+	if remaining > 0 {
+		b.stripes[0].value.Add(-remaining)
+	}
+}
+
+// collect sums the stripes until two passes agree, up to maxCollects
+// passes. The caller must hold mu.
+func (b *ShardedBalance) collect() int64 {
+	sum := b.sum()
+	for i := 1; i < maxCollects; i++ {
+		next := b.sum()
+		if next == sum {
+			break
+		}
+		sum = next
+	}
+	return sum
+}
+
+// addChecked deposits amount unless the aggregated total would overflow or
+// exceed the ceiling. The caller must hold mu exclusively.
+func (b *ShardedBalance) addChecked(amount int64) error {
+	current := b.sum()
+	if _, err := b.limits.Deposit(current, amount); err != nil {
+		b.notify(balance.EventAdd, amount, current, err)
+		return err
+	}
+
+	b.deposit(amount)
+	b.notify(balance.EventAdd, amount, current, nil)
+	return nil
+}
+
+// deposit increments a randomly chosen stripe without checking limits.
+func (b *ShardedBalance) deposit(amount int64) {
+	b.stripes[rand.Uint64()&b.mask].value.Add(amount)
+}
+
+// notify reports a mutation that moved the total from old, or that was
+// rejected with err, to the registered observers. The caller must hold mu
+// exclusively.
+func (b *ShardedBalance) notify(kind balance.EventKind, amount, old int64, err error) {
+	if !b.observers.Enabled() {
+		return
+	}
+	b.observers.Notify(balance.Event{
+		Kind:   kind,
+		Amount: amount,
+		Old:    old,
+		New:    b.sum(),
+		Err:    err,
+	})
+}
+
+// sum adds up every stripe without synchronization beyond the atomics.
+func (b *ShardedBalance) sum() int64 {
+	var total int64
+	for i := range b.stripes {
+		total += b.stripes[i].value.Load()
+	}
+	return total
+}
@@ -0,0 +1,16 @@
+/*
+Package sharded implements a striped balance tuned for write-heavy
+workloads. Add lands on one of several cache-line-padded stripes, so
+concurrent deposits rarely touch the same memory. Subtract escalates to an
+exclusive lock, checks the aggregated total, and borrows from as many
+stripes as it needs to cover the withdrawal, preserving the no-negative
+rule.
+
+Balance sums the stripes under a shared read lock and repeats the sum until
+two passes agree, so it never observes a withdrawal halfway through. Under
+sustained Add traffic it gives up after a bounded number of passes and
+returns the last sum, which always lies between the totals before and after
+the concurrent deposits. Only the value is tracked, so Snapshot is
+trivially consistent.
+*/
+package sharded
@@ -0,0 +1,17 @@
+/*
+Package arith provides overflow-checked int64 arithmetic shared by the
+balance implementations.
+*/
+package arith
+
+// Add returns a+b and reports whether the sum fits in an int64.
+func Add(a, b int64) (int64, bool) {
+	c := a + b
+	return c, (c > a) == (b > 0)
+}
+
+// Sub returns a-b and reports whether the difference fits in an int64.
+func Sub(a, b int64) (int64, bool) {
+	c := a - b
+	return c, (c < a) == (b > 0)
+}
@@ -0,0 +1,120 @@
+/*
+Package contention counts CAS retries and lock waits for balances built
+with balance.WithStats. A nil *Counters is valid and records nothing, so
+uninstrumented balances pay only a nil check.
+*/
+package contention
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	balance "github.com/madflojo/atomics-v-rwmutex-examples"
+	"github.com/madflojo/atomics-v-rwmutex-examples/internal/ctxlock"
+)
+
+// Locker is satisfied by *sync.Mutex and *sync.RWMutex.
+type Locker interface {
+	Lock()
+	TryLock() bool
+}
+
+// RLocker is satisfied by *sync.RWMutex.
+type RLocker interface {
+	RLock()
+	TryRLock() bool
+}
+
+// Counters accumulates contention statistics.
+type Counters struct {
+	retries   atomic.Int64
+	waits     atomic.Int64
+	waitNanos atomic.Int64
+}
+
+// New returns fresh counters when enabled is true, and nil otherwise.
+func New(enabled bool) *Counters {
+	if !enabled {
+		return nil
+	}
+	return &Counters{}
+}
+
+// Retry records one lost CAS.
+func (c *Counters) Retry() {
+	if c != nil {
+		c.retries.Add(1)
+	}
+}
+
+// Lock acquires l, recording how long it waited if it could not take the
+// lock immediately.
+func (c *Counters) Lock(l Locker) {
+	if c == nil {
+		l.Lock()
+		return
+	}
+	if l.TryLock() {
+		return
+	}
+
+	start := time.Now()
+	l.Lock()
+	c.wait(time.Since(start))
+}
+
+// RLock acquires l for reading, recording how long it waited if it could not
+// take the lock immediately.
+func (c *Counters) RLock(l RLocker) {
+	if c == nil {
+		l.RLock()
+		return
+	}
+	if l.TryRLock() {
+		return
+	}
+
+	start := time.Now()
+	l.RLock()
+	c.wait(time.Since(start))
+}
+
+// LockContext behaves like ctxlock.Lock, recording how long it waited if it
+// could not take the lock immediately. Time spent before a cancellation is
+// recorded too.
+func (c *Counters) LockContext(ctx context.Context, l ctxlock.TryLocker) error {
+	if c == nil {
+		return ctxlock.Lock(ctx, l)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if l.TryLock() {
+		return nil
+	}
+
+	start := time.Now()
+	err := ctxlock.Lock(ctx, l)
+	c.wait(time.Since(start))
+	return err
+}
+
+// Stats returns the counters as a balance.Stats. A nil *Counters reports
+// zero.
+func (c *Counters) Stats() balance.Stats {
+	if c == nil {
+		return balance.Stats{}
+	}
+	return balance.Stats{
+		CASRetries: c.retries.Load(),
+		LockWaits:  c.waits.Load(),
+		LockWait:   time.Duration(c.waitNanos.Load()),
+	}
+}
+
+// wait records one acquisition that waited d.
+func (c *Counters) wait(d time.Duration) {
+	c.waits.Add(1)
+	c.waitNanos.Add(int64(d))
+}
@@ -0,0 +1,54 @@
+/*
+Package ctxlock acquires a sync.Mutex or sync.RWMutex while honoring a
+context. The standard locks cannot be cancelled, so Lock polls TryLock
+with exponential backoff until it succeeds or the context is done. A
+polling waiter does not queue behind blocked Lock callers, so under heavy
+contention it may wait longer than they do; the upside is that the
+balances keep their plain sync locks on every non-context path.
+*/
+package ctxlock
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	// minBackoff is the first wait after a failed TryLock.
+	minBackoff = 5 * time.Microsecond
+	// maxBackoff caps the wait between attempts.
+	maxBackoff = time.Millisecond
+)
+
+// TryLocker is satisfied by *sync.Mutex and *sync.RWMutex.
+type TryLocker interface {
+	TryLock() bool
+}
+
+// Lock acquires l, or returns ctx.Err() if ctx is done first. A context
+// that is already done never acquires the lock.
+func Lock(ctx context.Context, l TryLocker) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if l.TryLock() {
+		return nil
+	}
+
+	backoff := minBackoff
+	timer := time.NewTimer(backoff)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+		}
+
+		if l.TryLock() {
+			return nil
+		}
+		backoff = min(2*backoff, maxBackoff)
+		timer.Reset(backoff)
+	}
+}
@@ -0,0 +1,74 @@
+package ctxlock
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLock(t *testing.T) {
+	t.Run("acquires a free lock", func(t *testing.T) {
+		var mu sync.Mutex
+		if err := Lock(context.Background(), &mu); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if mu.TryLock() {
+			t.Fatalf("expected lock to be held")
+		}
+	})
+
+	t.Run("done context never acquires", func(t *testing.T) {
+		var mu sync.Mutex
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		if err := Lock(ctx, &mu); !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected context.Canceled, got %v", err)
+		}
+		if !mu.TryLock() {
+			t.Fatalf("expected lock to remain free")
+		}
+	})
+
+	t.Run("deadline while held", func(t *testing.T) {
+		var mu sync.Mutex
+		mu.Lock()
+		defer mu.Unlock()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		if err := Lock(ctx, &mu); !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+		}
+	})
+
+	t.Run("cancel while held by readers", func(t *testing.T) {
+		var mu sync.RWMutex
+		mu.RLock()
+		defer mu.RUnlock()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(10*time.Millisecond, cancel)
+
+		if err := Lock(ctx, &mu); !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected context.Canceled, got %v", err)
+		}
+	})
+
+	t.Run("acquires once released", func(t *testing.T) {
+		var mu sync.Mutex
+		mu.Lock()
+		time.AfterFunc(10*time.Millisecond, mu.Unlock)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		if err := Lock(ctx, &mu); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		mu.Unlock()
+	})
+}
@@ -0,0 +1,22 @@
+/*
+Package lockorder ranks objects by address so code that needs to lock two
+of them always acquires the locks in the same global order and cannot
+deadlock. Go's garbage collector does not move heap objects, so an
+object's address is stable for its lifetime.
+*/
+package lockorder
+
+import "unsafe"
+
+// Less reports whether a should be locked before b.
+func Less[T any](a, b *T) bool {
+	return uintptr(unsafe.Pointer(a)) < uintptr(unsafe.Pointer(b))
+}
+
+// Order returns a and b arranged so the first result is locked first.
+func Order[T any](a, b *T) (*T, *T) {
+	if Less(b, a) {
+		return b, a
+	}
+	return a, b
+}
@@ -0,0 +1,302 @@
+/*
+Package mcas updates two int64 words as one atomic step without locks, so
+the atomics/cas/full balance can move funds between accounts without a
+reader ever seeing them in neither.
+
+An update publishes a descriptor and freezes each word, in address order,
+by swapping its value for a token that names the descriptor. Once both
+words are frozen, whoever gets there first decides the outcome with a
+single CAS on the descriptor, and the words are then unfrozen to their
+new values. Nobody waits on a frozen word:
+
+  - Readers resolve it through the descriptor: the old value until the
+    outcome is decided, the new one after.
+  - Writers help. They finish an update whose words are all frozen, and
+    abort one that is still freezing, which then restarts.
+
+Tokens occupy the values below MinValue, so a Word never holds a balance
+in that range.
+*/
+package mcas
+
+import (
+	"math"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	balance "github.com/madflojo/atomics-v-rwmutex-examples"
+	"github.com/madflojo/atomics-v-rwmutex-examples/internal/lockorder"
+)
+
+// tokens is how many values are reserved for tokens. A token is only
+// reused after that many further updates, far more than a helper can
+// sleep through.
+const tokens = 1 << 40
+
+// MinValue is the lowest value a Word can hold. The range below it is
+// reserved for tokens.
+const MinValue = math.MinInt64 + tokens
+
+var (
+	// nextToken numbers descriptors.
+	nextToken atomic.Int64
+	// inflight maps the token of every unfinished update to its
+	// descriptor.
+	inflight sync.Map
+)
+
+// Word is an int64 that Update can change together with another Word. The
+// zero value holds zero. A Word must not be copied after first use.
+type Word struct {
+	v atomic.Int64
+}
+
+// outcome is the decision on an update. It is immutable once published.
+type outcome struct {
+	// next holds the words' new values in address order; equal to the old
+	// values when err is set.
+	next [2]int64
+	// err is the update function's refusal.
+	err error
+	// aborted is set by a helper that found the update still freezing.
+	aborted bool
+}
+
+// abort is the outcome helpers publish for an update they cut short.
+var abort = &outcome{aborted: true}
+
+// descriptor is an update in flight.
+type descriptor struct {
+	token int64
+	// words are the words being updated, in address order.
+	words [2]*Word
+	// old holds each word's value from just before it was frozen. An
+	// entry is fixed once its word holds the token.
+	old [2]atomic.Int64
+	// fn computes the new values from old. It may run on several
+	// goroutines, so it must not have side effects.
+	fn func(old [2]int64) ([2]int64, error)
+	// frozen is set once both words hold the token.
+	frozen atomic.Bool
+	// outcome is nil until the update is decided.
+	outcome atomic.Pointer[outcome]
+}
+
+// Load returns the value, resolving an in-flight update to the value it
+// had or will have, so the update is never seen half applied.
+func (w *Word) Load() int64 {
+	for {
+		v := w.v.Load()
+		if v >= MinValue {
+			return v
+		}
+		if d := lookup(v); d != nil {
+			return d.value(w)
+		}
+		// The update finished between the two loads; read the result.
+	}
+}
+
+// CompareAndSwap stores next if the value is old. If the word is frozen it
+// helps the update along and returns false, so the caller reloads and
+// retries. next must not be below MinValue.
+func (w *Word) CompareAndSwap(old, next int64) bool {
+	if w.v.CompareAndSwap(old, next) {
+		return true
+	}
+	if v := w.v.Load(); v < MinValue {
+		help(v)
+	}
+	return false
+}
+
+// Add adds delta and returns the new value. Like the + operator it wraps
+// on overflow, skipping over the reserved range.
+func (w *Word) Add(delta int64) int64 {
+	for {
+		current := w.Load()
+		next := current + delta
+		if next < MinValue {
+			next += tokens
+		}
+		if w.CompareAndSwap(current, next) {
+			return next
+		}
+	}
+}
+
+// Store sets the value, waiting out any in-flight update so its result
+// does not overwrite v. v must not be below MinValue.
+func (w *Word) Store(v int64) {
+	for !w.CompareAndSwap(w.Load(), v) {
+	}
+}
+
+// Update replaces the values of a and b with fn's results as one atomic
+// step, and returns the values it moved between. If fn returns an error,
+// neither word changes and the error is returned with next equal to old.
+// fn may run more than once and on other goroutines, so it must not have
+// side effects. a and b must be distinct.
+func Update(a, b *Word, fn func(a, b int64) (int64, int64, error)) (old, next [2]int64, err error) {
+	swapped := lockorder.Less(b, a)
+	ordered := func(v [2]int64) [2]int64 {
+		if swapped {
+			v[0], v[1] = v[1], v[0]
+		}
+		return v
+	}
+	apply := func(old [2]int64) ([2]int64, error) {
+		old = ordered(old)
+		na, nb, err := fn(old[0], old[1])
+		return ordered([2]int64{na, nb}), err
+	}
+
+	words := [2]*Word{a, b}
+	if swapped {
+		words[0], words[1] = b, a
+	}
+	for {
+		d := &descriptor{token: token(), words: words, fn: apply}
+		inflight.Store(d.token, d)
+		d.run()
+		inflight.Delete(d.token)
+
+		out := d.outcome.Load()
+		if !out.aborted {
+			return ordered([2]int64{d.old[0].Load(), d.old[1].Load()}), ordered(out.next), out.err
+		}
+		// A writer needed one of the words before both were frozen.
+		runtime.Gosched()
+	}
+}
+
+// Load2 returns the values of a and b as of the same instant.
+func Load2(a, b *Word) (int64, int64) {
+	old, _, _ := Update(a, b, func(a, b int64) (int64, int64, error) {
+		return a, b, nil
+	})
+	return old[0], old[1]
+}
+
+// Limits returns l with its floor raised, if need be, so withdrawals can
+// never take a Word into the reserved range.
+func Limits(l balance.Limits) balance.Limits {
+	if l.Min() >= MinValue {
+		return l
+	}
+	if l.Floor < MinValue {
+		l.Floor = MinValue
+	}
+	l.Overdraft = l.Floor - MinValue
+	return l
+}
+
+// token returns a token no in-flight update is using.
+func token() int64 {
+	return math.MinInt64 + nextToken.Add(1)%tokens
+}
+
+// lookup returns the unfinished update that token names, or nil.
+func lookup(token int64) *descriptor {
+	d, ok := inflight.Load(token)
+	if !ok {
+		return nil
+	}
+	return d.(*descriptor)
+}
+
+// help moves the update that token names out of the way: it finishes one
+// whose words are all frozen and aborts one that is still freezing.
+func help(token int64) {
+	d := lookup(token)
+	if d == nil {
+		return
+	}
+	if d.outcome.Load() == nil {
+		if d.frozen.Load() {
+			d.decide()
+		} else {
+			d.outcome.CompareAndSwap(nil, abort)
+		}
+	}
+	d.finish()
+}
+
+// run freezes the words in address order, decides the update, and
+// unfreezes them. Only the goroutine that published d calls it.
+func (d *descriptor) run() {
+	for i := range d.words {
+		if !d.freeze(i) {
+			d.finish()
+			return
+		}
+	}
+	d.frozen.Store(true)
+	d.decide()
+	d.finish()
+}
+
+// freeze swaps word i's value for the token, helping any other update out
+// of the way. It returns false if d was aborted first.
+func (d *descriptor) freeze(i int) bool {
+	w := d.words[i]
+	for {
+		if d.outcome.Load() != nil {
+			return false
+		}
+		v := w.v.Load()
+		if v < MinValue {
+			help(v)
+			continue
+		}
+		d.old[i].Store(v)
+		if w.v.CompareAndSwap(v, d.token) {
+			return true
+		}
+	}
+}
+
+// decide applies fn to the frozen values and publishes the outcome unless
+// another goroutine already has. The caller must have seen frozen set.
+func (d *descriptor) decide() {
+	if d.outcome.Load() != nil {
+		return
+	}
+	old := [2]int64{d.old[0].Load(), d.old[1].Load()}
+	next, err := d.fn(old)
+	if err != nil {
+		next = old
+	}
+	d.outcome.CompareAndSwap(nil, &outcome{next: next, err: err})
+}
+
+// finish unfreezes every word still holding the token to its decided
+// value. d must be decided.
+func (d *descriptor) finish() {
+	out := d.outcome.Load()
+	for i, w := range d.words {
+		// old is only fixed while the word holds the token, so check
+		// before reading it; the CAS below fails if it has since moved.
+		if w.v.Load() != d.token {
+			continue
+		}
+		v := d.old[i].Load()
+		if !out.aborted {
+			v = out.next[i]
+		}
+		w.v.CompareAndSwap(d.token, v)
+	}
+}
+
+// value returns w's logical value while it holds d's token.
+func (d *descriptor) value(w *Word) int64 {
+	i := 0
+	if d.words[1] == w {
+		i = 1
+	}
+	if out := d.outcome.Load(); out != nil && !out.aborted {
+		return out.next[i]
+	}
+	return d.old[i].Load()
+}
@@ -0,0 +1,116 @@
+package mcas
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"testing"
+
+	balance "github.com/madflojo/atomics-v-rwmutex-examples"
+)
+
+func TestUpdate(t *testing.T) {
+	t.Run("applies both values", func(t *testing.T) {
+		var a, b Word
+		a.Store(10)
+		old, next, err := Update(&a, &b, func(a, b int64) (int64, int64, error) {
+			return a - 4, b + 4, nil
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if old != [2]int64{10, 0} || next != [2]int64{6, 4} {
+			t.Fatalf("expected 10/0 to 6/4, got %v to %v", old, next)
+		}
+		if a.Load() != 6 || b.Load() != 4 {
+			t.Fatalf("expected 6/4, got %d/%d", a.Load(), b.Load())
+		}
+	})
+
+	t.Run("refusal changes nothing", func(t *testing.T) {
+		var a, b Word
+		a.Store(1)
+		refused := errors.New("refused")
+		old, next, err := Update(&b, &a, func(int64, int64) (int64, int64, error) {
+			return 0, 0, refused
+		})
+		if !errors.Is(err, refused) {
+			t.Fatalf("expected the refusal, got %v", err)
+		}
+		if old != [2]int64{0, 1} || next != old {
+			t.Fatalf("expected 0/1 unchanged, got %v to %v", old, next)
+		}
+		if a.Load() != 1 || b.Load() != 0 {
+			t.Fatalf("expected 1/0, got %d/%d", a.Load(), b.Load())
+		}
+	})
+
+	t.Run("readers never see an update half applied", func(t *testing.T) {
+		const (
+			total   = 1_000
+			workers = 8
+			iters   = 500
+		)
+		var a, b Word
+		a.Store(total)
+
+		done, reading := make(chan struct{}), make(chan struct{})
+		go func() {
+			defer close(reading)
+			for {
+				if x, y := Load2(&a, &b); x+y != total {
+					t.Errorf("saw %d + %d, want a total of %d", x, y, total)
+					return
+				}
+				select {
+				case <-done:
+					return
+				default:
+				}
+			}
+		}()
+
+		var wg sync.WaitGroup
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < iters; i++ {
+					from, to := &a, &b
+					if i%2 == 1 {
+						from, to = to, from
+					}
+					_, _, _ = Update(from, to, func(x, y int64) (int64, int64, error) {
+						if x < 3 {
+							return x, y, errors.New("short")
+						}
+						return x - 3, y + 3, nil
+					})
+					// A plain writer on the same word helps any update it
+					// runs into; rewriting the value keeps the total.
+					for v := from.Load(); !from.CompareAndSwap(v, v); v = from.Load() {
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		close(done)
+		<-reading
+
+		if x, y := a.Load(), b.Load(); x+y != total {
+			t.Fatalf("expected a total of %d, got %d + %d", total, x, y)
+		}
+	})
+}
+
+func TestLimits(t *testing.T) {
+	if got := Limits(balance.Limits{Floor: 5, Overdraft: 10}); got != (balance.Limits{Floor: 5, Overdraft: 10}) {
+		t.Fatalf("expected limits within range to be kept, got %+v", got)
+	}
+	if got := Limits(balance.Limits{Overdraft: math.MaxInt64}); got.Min() != MinValue {
+		t.Fatalf("expected the overdraft to stop at MinValue, got %d", got.Min())
+	}
+	if got := Limits(balance.Limits{Floor: math.MinInt64}); got.Min() != MinValue {
+		t.Fatalf("expected the floor to be raised to MinValue, got %d", got.Min())
+	}
+}
@@ -0,0 +1,19 @@
+/*
+Package monotonic keeps lock-free timestamps from moving backwards when
+concurrent writers race to record them.
+*/
+package monotonic
+
+import "sync/atomic"
+
+// Advance stores now in ts unless ts already holds a later value. A slower
+// writer can therefore never overwrite a newer timestamp, and once Advance
+// returns ts is at least now.
+func Advance(ts *atomic.Int64, now int64) {
+	for {
+		cur := ts.Load()
+		if now <= cur || ts.CompareAndSwap(cur, now) {
+			return
+		}
+	}
+}
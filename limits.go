@@ -0,0 +1,82 @@
+package balance
+
+import (
+	"math"
+
+	"github.com/madflojo/atomics-v-rwmutex-examples/internal/arith"
+)
+
+// Limits bounds the value of a single account. The zero value keeps the
+// default contract: withdrawals may not take the balance below zero and
+// deposits are only bounded by int64. Implementations enforce limits
+// atomically, inside the same lock, CAS, or owner goroutine that applies
+// the update.
+type Limits struct {
+	// Floor is the lowest balance a withdrawal may leave before Overdraft
+	// is applied. A positive Floor keeps a minimum reserve.
+	Floor int64
+	// Overdraft is how far below Floor withdrawals may go. It must not be
+	// negative.
+	Overdraft int64
+	// Ceiling, when positive, is the highest balance a deposit may reach.
+	// Zero means no ceiling.
+	Ceiling int64
+}
+
+// Validate returns ErrInvalidLimits if l cannot be enforced.
+func (l Limits) Validate() error {
+	if l.Overdraft < 0 || l.Ceiling < 0 || (l.Ceiling > 0 && l.Ceiling < l.Floor) {
+		return ErrInvalidLimits
+	}
+	return nil
+}
+
+// Min returns the lowest balance a withdrawal may leave: Floor less
+// Overdraft, saturating at math.MinInt64.
+func (l Limits) Min() int64 {
+	if m, ok := arith.Sub(l.Floor, l.Overdraft); ok {
+		return m
+	}
+	return math.MinInt64
+}
+
+// Max returns the highest balance a deposit may reach.
+func (l Limits) Max() int64 {
+	if l.HasCeiling() {
+		return l.Ceiling
+	}
+	return math.MaxInt64
+}
+
+// HasCeiling reports whether deposits are capped below math.MaxInt64.
+// Unchecked Add has to take the checked path when they are.
+func (l Limits) HasCeiling() bool {
+	return l.Ceiling > 0
+}
+
+// Withdraw returns current less amount, or an *InsufficientFundsError if
+// that would leave the balance below Min.
+func (l Limits) Withdraw(current, amount int64) (int64, error) {
+	next, ok := arith.Sub(current, amount)
+	if !ok || next < l.Min() {
+		available, ok := arith.Sub(current, l.Min())
+		if !ok {
+			available = math.MaxInt64
+		}
+		return current, &InsufficientFundsError{Requested: amount, Available: available}
+	}
+	return next, nil
+}
+
+// Deposit returns current plus amount, ErrOverflow if that does not fit in
+// an int64, or ErrCeilingExceeded if it would exceed Max.
+func (l Limits) Deposit(current, amount int64) (int64, error) {
+	next, ok := arith.Add(current, amount)
+	if !ok {
+		return current, ErrOverflow
+	}
+	if next > l.Max() {
+		return current, ErrCeilingExceeded
+	}
+	return next, nil
+}
@@ -0,0 +1,128 @@
+package balance_test
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	balance "github.com/madflojo/atomics-v-rwmutex-examples"
+)
+
+func TestLimits(t *testing.T) {
+	t.Run("validate", func(t *testing.T) {
+		tests := []struct {
+			name   string
+			limits balance.Limits
+			valid  bool
+		}{
+			{name: "zero value", limits: balance.Limits{}, valid: true},
+			{name: "reserve with ceiling", limits: balance.Limits{Floor: 10, Ceiling: 100}, valid: true},
+			{name: "negative overdraft", limits: balance.Limits{Overdraft: -1}},
+			{name: "negative ceiling", limits: balance.Limits{Ceiling: -1}},
+			{name: "ceiling below floor", limits: balance.Limits{Floor: 10, Ceiling: 5}},
+		}
+		for _, tt := range tests {
+			err := tt.limits.Validate()
+			if tt.valid && err != nil {
+				t.Fatalf("%s: unexpected error: %v", tt.name, err)
+			}
+			if !tt.valid && !errors.Is(err, balance.ErrInvalidLimits) {
+				t.Fatalf("%s: expected ErrInvalidLimits, got %v", tt.name, err)
+			}
+		}
+	})
+
+	t.Run("withdraw", func(t *testing.T) {
+		tests := []struct {
+			name    string
+			limits  balance.Limits
+			current int64
+			amount  int64
+			// next is the expected result when rejected is false, and
+			// the expected Available otherwise.
+			next     int64
+			rejected bool
+		}{
+			{name: "default floor", current: 10, amount: 10, next: 0},
+			{name: "default floor rejects", current: 10, amount: 11, next: 10, rejected: true},
+			{name: "reserve", limits: balance.Limits{Floor: 5}, current: 10, amount: 5, next: 5},
+			{name: "reserve rejects", limits: balance.Limits{Floor: 5}, current: 10, amount: 6, next: 5, rejected: true},
+			{name: "overdraft", limits: balance.Limits{Overdraft: 20}, current: 10, amount: 30, next: -20},
+			{name: "overdraft rejects", limits: balance.Limits{Overdraft: 20}, current: 10, amount: 31, next: 30, rejected: true},
+			{
+				name:     "saturated floor rejects wraparound",
+				limits:   balance.Limits{Floor: math.MinInt64 + 1, Overdraft: math.MaxInt64},
+				current:  math.MinInt64,
+				amount:   1,
+				next:     0,
+				rejected: true,
+			},
+		}
+		for _, tt := range tests {
+			next, err := tt.limits.Withdraw(tt.current, tt.amount)
+			if !tt.rejected {
+				if err != nil || next != tt.next {
+					t.Fatalf("%s: got %d, %v want %d, nil", tt.name, next, err, tt.next)
+				}
+				continue
+			}
+			var fundsErr *balance.InsufficientFundsError
+			if !errors.As(err, &fundsErr) {
+				t.Fatalf("%s: expected InsufficientFundsError, got %v", tt.name, err)
+			}
+			if fundsErr.Requested != tt.amount || fundsErr.Available != tt.next {
+				t.Fatalf("%s: got %+v want requested %d available %d", tt.name, fundsErr, tt.amount, tt.next)
+			}
+		}
+	})
+
+	t.Run("deposit", func(t *testing.T) {
+		ceiling := balance.Limits{Ceiling: 100}
+		if next, err := ceiling.Deposit(60, 40); err != nil || next != 100 {
+			t.Fatalf("deposit to the ceiling: got %d, %v want 100, nil", next, err)
+		}
+		if _, err := ceiling.Deposit(60, 41); !errors.Is(err, balance.ErrCeilingExceeded) {
+			t.Fatalf("expected ErrCeilingExceeded, got %v", err)
+		}
+		if _, err := (balance.Limits{}).Deposit(math.MaxInt64, 1); !errors.Is(err, balance.ErrOverflow) {
+			t.Fatalf("expected ErrOverflow, got %v", err)
+		}
+	})
+
+	t.Run("holds", func(t *testing.T) {
+		limits := balance.Limits{Overdraft: 10, Ceiling: 100}
+		held, err := limits.Reserve(50, 20, 40)
+		if err != nil || held != 60 {
+			t.Fatalf("reserve into the overdraft: got %d, %v want 60, nil", held, err)
+		}
+		var fundsErr *balance.InsufficientFundsError
+		if _, err := limits.Reserve(50, 20, 41); !errors.As(err, &fundsErr) || fundsErr.Available != 40 {
+			t.Fatalf("expected InsufficientFundsError with 40 available, got %v", err)
+		}
+		if _, err := limits.Holding(60).Withdraw(50, 1); !errors.Is(err, balance.ErrInsufficientFunds) {
+			t.Fatalf("expected held funds to be out of reach, got %v", err)
+		}
+		if got := limits.Holding(60).Max(); got != 100 {
+			t.Fatalf("expected the ceiling to stay at 100, got %d", got)
+		}
+
+		value, held, err := balance.Settle(50, 60, 40, 15)
+		if err != nil || value != 35 || held != 20 {
+			t.Fatalf("settle: got %d/%d, %v want 35/20, nil", value, held, err)
+		}
+		for _, bad := range [][2]int64{{0, 0}, {10, 11}, {61, 0}, {10, -1}} {
+			if _, _, err := balance.Settle(50, 60, bad[0], bad[1]); !errors.Is(err, balance.ErrInvalidAmount) {
+				t.Fatalf("settle %d/%d: expected ErrInvalidAmount, got %v", bad[0], bad[1], err)
+			}
+		}
+	})
+
+	t.Run("WithLimits panics on invalid limits", func(t *testing.T) {
+		defer func() {
+			if recover() == nil {
+				t.Fatalf("expected a panic")
+			}
+		}()
+		balance.WithLimits(balance.Limits{Overdraft: -1})
+	})
+}
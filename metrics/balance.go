@@ -0,0 +1,91 @@
+package metrics
+
+import (
+	"sync/atomic"
+
+	balance "github.com/madflojo/atomics-v-rwmutex-examples"
+)
+
+// Counts is how many Add and Subtract calls a wrapped balance has seen, by
+// outcome.
+type Counts struct {
+	AddSuccess      uint64
+	AddFailure      uint64
+	SubtractSuccess uint64
+	SubtractFailure uint64
+}
+
+// Balance wraps any balance.Balance and counts the outcome of every Add,
+// AddChecked and Subtract. It still satisfies balance.Balance; reads pass
+// straight through to the wrapped value.
+type Balance struct {
+	inner balance.Balance
+
+	addSuccess      atomic.Uint64
+	addFailure      atomic.Uint64
+	subtractSuccess atomic.Uint64
+	subtractFailure atomic.Uint64
+}
+
+// Wrap returns b with operation counters.
+func Wrap(b balance.Balance) *Balance {
+	return &Balance{inner: b}
+}
+
+// Unwrap returns the wrapped Balance.
+func (b *Balance) Unwrap() balance.Balance { return b.inner }
+
+// Balance returns the wrapped balance's value.
+func (b *Balance) Balance() int64 { return b.inner.Balance() }
+
+// TransactionCount returns the wrapped balance's transaction count.
+func (b *Balance) TransactionCount() int64 { return b.inner.TransactionCount() }
+
+// LastUpdated returns the wrapped balance's last update timestamp.
+func (b *Balance) LastUpdated() int64 { return b.inner.LastUpdated() }
+
+// Snapshot returns the wrapped balance's snapshot.
+func (b *Balance) Snapshot() balance.Snapshot { return b.inner.Snapshot() }
+
+// Add deposits amount like AddChecked and drops the error. A deposit the
+// wrapped balance refuses for overflow or a ceiling is therefore dropped
+// rather than wrapped, and counts as a failure.
+func (b *Balance) Add(amount int64) {
+	_ = b.AddChecked(amount)
+}
+
+// AddChecked deposits amount through balance.AddChecked and counts the
+// outcome. Unless the wrapped balance implements balance.CheckedBalance,
+// only non-positive amounts are rejected.
+func (b *Balance) AddChecked(amount int64) error {
+	err := balance.AddChecked(b.inner, amount)
+	if err != nil {
+		b.addFailure.Add(1)
+		return err
+	}
+
+	b.addSuccess.Add(1)
+	return nil
+}
+
+// Subtract withdraws amount and counts the outcome.
+func (b *Balance) Subtract(amount int64) error {
+	if err := b.inner.Subtract(amount); err != nil {
+		b.subtractFailure.Add(1)
+		return err
+	}
+
+	b.subtractSuccess.Add(1)
+	return nil
+}
+
+// Counts returns the operation counters. Each counter is read atomically,
+// but they are not read as one consistent unit.
+func (b *Balance) Counts() Counts {
+	return Counts{
+		AddSuccess:      b.addSuccess.Load(),
+		AddFailure:      b.addFailure.Load(),
+		SubtractSuccess: b.subtractSuccess.Load(),
+		SubtractFailure: b.subtractFailure.Load(),
+	}
+}
@@ -0,0 +1,17 @@
+/*
+Package metrics exposes balances to Prometheus without any dependency
+beyond the standard library:
+
+	alice := metrics.Wrap(full.New()) // counts Add and Subtract outcomes
+
+	h := metrics.NewHandler()
+	h.Register("alice", alice)
+	h.Register("bob", full.New()) // state only, no operation counters
+	http.Handle("/metrics", h)
+
+Every registered balance reports its value, transaction count and
+LastUpdated as gauges and counters. Balances wrapped with Wrap also report
+success and failure counters for Add and Subtract. The handler speaks the
+Prometheus text format, and OpenMetrics when the scraper asks for it.
+*/
+package metrics
@@ -0,0 +1,197 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	balance "github.com/madflojo/atomics-v-rwmutex-examples"
+)
+
+const (
+	// contentTypeText is the Prometheus text exposition format.
+	contentTypeText = "text/plain; version=0.0.4; charset=utf-8"
+	// contentTypeOpenMetrics is the OpenMetrics text format, served when
+	// the scraper's Accept header asks for it.
+	contentTypeOpenMetrics = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+)
+
+// family describes one metric family in the exposition.
+type family struct {
+	name string
+	help string
+	typ  string
+}
+
+var (
+	valueFamily = family{
+		name: "balance_value",
+		help: "Current account balance.",
+		typ:  "gauge",
+	}
+	transactionsFamily = family{
+		name: "balance_transactions",
+		help: "Mutations applied to the balance; zero for implementations that do not track metadata.",
+		typ:  "counter",
+	}
+	lastUpdatedFamily = family{
+		name: "balance_last_updated_timestamp_seconds",
+		help: "Time of the latest mutation as reported by LastUpdated, in seconds.",
+		typ:  "gauge",
+	}
+	operationsFamily = family{
+		name: "balance_operations",
+		help: "Add and Subtract calls by outcome, for balances wrapped with metrics.Wrap.",
+		typ:  "counter",
+	}
+)
+
+// labelEscaper escapes label values as both formats require.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// Handler serves the state of registered balances in the Prometheus text
+// format. It is safe for concurrent use, including registering balances
+// while scrapes are in flight.
+type Handler struct {
+	mu       sync.RWMutex
+	balances map[string]balance.Balance
+}
+
+// NewHandler returns a Handler with no balances registered.
+func NewHandler() *Handler {
+	return &Handler{balances: make(map[string]balance.Balance)}
+}
+
+// Register exposes b under name, replacing any balance already registered
+// with that name. Balances returned by Wrap also report their operation
+// counters.
+func (h *Handler) Register(name string, b balance.Balance) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.balances[name] = b
+}
+
+// Unregister stops exposing the balance registered under name.
+func (h *Handler) Unregister(name string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.balances, name)
+}
+
+// ServeHTTP renders every registered balance, sorted by name. Each balance
+// is read with one Snapshot, so its value, transaction count and timestamp
+// are as consistent as that implementation's Snapshot.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	openMetrics := strings.Contains(r.Header.Get("Accept"), "application/openmetrics-text")
+	var buf bytes.Buffer
+	h.write(&buf, openMetrics)
+
+	if openMetrics {
+		w.Header().Set("Content-Type", contentTypeOpenMetrics)
+	} else {
+		w.Header().Set("Content-Type", contentTypeText)
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	if r.Method == http.MethodHead {
+		return
+	}
+	_, _ = w.Write(buf.Bytes())
+}
+
+// sample is one registered balance read for a scrape.
+type sample struct {
+	name    string
+	label   string
+	snap    balance.Snapshot
+	counts  Counts
+	counted bool
+}
+
+// write renders the exposition into buf.
+func (h *Handler) write(buf *bytes.Buffer, openMetrics bool) {
+	samples := h.collect()
+
+	writeHeader(buf, valueFamily, openMetrics)
+	for _, s := range samples {
+		fmt.Fprintf(buf, "%s{balance=\"%s\"} %d\n", valueFamily.name, s.label, s.snap.Value)
+	}
+
+	writeHeader(buf, transactionsFamily, openMetrics)
+	for _, s := range samples {
+		fmt.Fprintf(buf, "%s_total{balance=\"%s\"} %d\n", transactionsFamily.name, s.label, s.snap.TransactionCount)
+	}
+
+	writeHeader(buf, lastUpdatedFamily, openMetrics)
+	for _, s := range samples {
+		fmt.Fprintf(buf, "%s{balance=\"%s\"} %s\n", lastUpdatedFamily.name, s.label, seconds(s.snap.LastUpdated))
+	}
+
+	if slices.ContainsFunc(samples, func(s sample) bool { return s.counted }) {
+		writeHeader(buf, operationsFamily, openMetrics)
+		for _, s := range samples {
+			if !s.counted {
+				continue
+			}
+			for _, op := range []struct {
+				op, result string
+				n          uint64
+			}{
+				{"add", "success", s.counts.AddSuccess},
+				{"add", "failure", s.counts.AddFailure},
+				{"subtract", "success", s.counts.SubtractSuccess},
+				{"subtract", "failure", s.counts.SubtractFailure},
+			} {
+				fmt.Fprintf(buf, "%s_total{balance=\"%s\",op=\"%s\",result=\"%s\"} %d\n",
+					operationsFamily.name, s.label, op.op, op.result, op.n)
+			}
+		}
+	}
+
+	if openMetrics {
+		buf.WriteString("# EOF\n")
+	}
+}
+
+// collect reads every registered balance once, sorted by name.
+func (h *Handler) collect() []sample {
+	h.mu.RLock()
+	samples := make([]sample, 0, len(h.balances))
+	for name, b := range h.balances {
+		s := sample{name: name, label: labelEscaper.Replace(name), snap: b.Snapshot()}
+		if counted, ok := b.(*Balance); ok {
+			s.counts, s.counted = counted.Counts(), true
+		}
+		samples = append(samples, s)
+	}
+	h.mu.RUnlock()
+
+	slices.SortFunc(samples, func(a, b sample) int { return strings.Compare(a.name, b.name) })
+	return samples
+}
+
+// writeHeader writes the HELP and TYPE lines for f. The Prometheus text
+// format names counters by their _total sample; OpenMetrics names the
+// family.
+func writeHeader(buf *bytes.Buffer, f family, openMetrics bool) {
+	name := f.name
+	if f.typ == "counter" && !openMetrics {
+		name += "_total"
+	}
+	fmt.Fprintf(buf, "# HELP %s %s\n", name, f.help)
+	fmt.Fprintf(buf, "# TYPE %s %s\n", name, f.typ)
+}
+
+// seconds formats a nanosecond timestamp as seconds.
+func seconds(nanos int64) string {
+	return strconv.FormatFloat(float64(nanos)/1e9, 'f', -1, 64)
+}
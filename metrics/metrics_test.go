@@ -0,0 +1,221 @@
+package metrics_test
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	balance "github.com/madflojo/atomics-v-rwmutex-examples"
+	"github.com/madflojo/atomics-v-rwmutex-examples/balancetest"
+	_ "github.com/madflojo/atomics-v-rwmutex-examples/implementations/all"
+	"github.com/madflojo/atomics-v-rwmutex-examples/metrics"
+)
+
+func TestMetrics(t *testing.T) {
+	for _, impl := range balance.Implementations() {
+		impl := impl
+		if impl.NewWithOptions == nil {
+			continue
+		}
+		t.Run(impl.Name, func(t *testing.T) {
+			clock := balancetest.NewManualClock(1_500_000_000)
+			acct := metrics.Wrap(balancetest.New(t, impl, balance.WithClock(clock)))
+			acct.Add(100)
+			acct.Add(0)
+			if err := acct.Subtract(30); err != nil {
+				t.Fatalf("unexpected subtract error: %v", err)
+			}
+			if err := acct.Subtract(1_000); err == nil {
+				t.Fatalf("expected overdraw to fail")
+			}
+
+			h := metrics.NewHandler()
+			h.Register("alice", acct)
+			body, contentType := scrape(t, h, "")
+			if !strings.HasPrefix(contentType, "text/plain; version=0.0.4") {
+				t.Fatalf("unexpected content type %q", contentType)
+			}
+
+			want := []string{
+				"# TYPE balance_value gauge",
+				`balance_value{balance="alice"} 70`,
+				"# TYPE balance_transactions_total counter",
+				`balance_operations_total{balance="alice",op="add",result="success"} 1`,
+				`balance_operations_total{balance="alice",op="add",result="failure"} 1`,
+				`balance_operations_total{balance="alice",op="subtract",result="success"} 1`,
+				`balance_operations_total{balance="alice",op="subtract",result="failure"} 1`,
+			}
+			if impl.Traits.TracksMetadata {
+				want = append(want,
+					`balance_transactions_total{balance="alice"} 2`,
+					`balance_last_updated_timestamp_seconds{balance="alice"} 1.5`,
+				)
+			}
+			for _, line := range want {
+				if !hasLine(body, line) {
+					t.Fatalf("expected line %q in:\n%s", line, body)
+				}
+			}
+		})
+	}
+
+	t.Run("add dropped by a ceiling counts as a failure", func(t *testing.T) {
+		impl := balance.Implementations()[0]
+		acct := metrics.Wrap(balancetest.New(t, impl, balance.WithLimits(balance.Limits{Ceiling: 50})))
+		acct.Add(40)
+		acct.Add(20)
+		if got := acct.Balance(); got != 40 {
+			t.Fatalf("expected the ceiling to drop the second deposit, got %d", got)
+		}
+		if got := acct.Counts(); got.AddSuccess != 1 || got.AddFailure != 1 {
+			t.Fatalf("expected one success and one failure, got %+v", got)
+		}
+	})
+
+	t.Run("unwrapped balances omit operation counters", func(t *testing.T) {
+		acct := balancetest.New(t, balance.Implementations()[0])
+		acct.Add(5)
+		h := metrics.NewHandler()
+		h.Register("bob", acct)
+
+		body, _ := scrape(t, h, "")
+		if !hasLine(body, `balance_value{balance="bob"} 5`) {
+			t.Fatalf("expected bob's value in:\n%s", body)
+		}
+		if strings.Contains(body, "balance_operations") {
+			t.Fatalf("unexpected operation counters for an unwrapped balance:\n%s", body)
+		}
+	})
+
+	t.Run("openmetrics", func(t *testing.T) {
+		h := metrics.NewHandler()
+		h.Register("alice", metrics.Wrap(balancetest.New(t, balance.Implementations()[0])))
+
+		body, contentType := scrape(t, h, "application/openmetrics-text; version=1.0.0,text/plain;q=0.5")
+		if !strings.HasPrefix(contentType, "application/openmetrics-text") {
+			t.Fatalf("unexpected content type %q", contentType)
+		}
+		for _, line := range []string{
+			"# TYPE balance_transactions counter",
+			"# TYPE balance_operations counter",
+			`balance_transactions_total{balance="alice"} 0`,
+		} {
+			if !hasLine(body, line) {
+				t.Fatalf("expected line %q in:\n%s", line, body)
+			}
+		}
+		if !strings.HasSuffix(body, "# EOF\n") {
+			t.Fatalf("expected OpenMetrics output to end with # EOF:\n%s", body)
+		}
+	})
+
+	t.Run("sorted names and escaped labels", func(t *testing.T) {
+		impl := balance.Implementations()[0]
+		h := metrics.NewHandler()
+		h.Register("zed", balancetest.New(t, impl))
+		h.Register("a\"b\\c\nd", balancetest.New(t, impl))
+		h.Register("gone", balancetest.New(t, impl))
+		h.Unregister("gone")
+
+		body, _ := scrape(t, h, "")
+		escaped := `balance_value{balance="a\"b\\c\nd"} 0`
+		zed := `balance_value{balance="zed"} 0`
+		if !hasLine(body, escaped) || !hasLine(body, zed) {
+			t.Fatalf("expected escaped and plain labels in:\n%s", body)
+		}
+		if strings.Index(body, escaped) > strings.Index(body, zed) {
+			t.Fatalf("expected balances sorted by name:\n%s", body)
+		}
+		if strings.Contains(body, "gone") {
+			t.Fatalf("unregistered balance still exposed:\n%s", body)
+		}
+	})
+
+	t.Run("rejects other methods", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		metrics.NewHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/metrics", nil))
+		if rec.Code != http.StatusMethodNotAllowed {
+			t.Fatalf("expected 405, got %d", rec.Code)
+		}
+	})
+
+	t.Run("scrapes during mutations", func(t *testing.T) {
+		h := metrics.NewHandler()
+		srv := httptest.NewServer(h)
+		defer srv.Close()
+
+		var accounts []*metrics.Balance
+		for i, impl := range balance.Implementations() {
+			acct := metrics.Wrap(balancetest.New(t, impl))
+			accounts = append(accounts, acct)
+			h.Register(fmt.Sprintf("%02d-%s", i, impl.Name), acct)
+		}
+
+		stop := make(chan struct{})
+		var wg sync.WaitGroup
+		for _, acct := range accounts {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					select {
+					case <-stop:
+						return
+					default:
+						acct.Add(2)
+						_ = acct.Subtract(1)
+					}
+				}
+			}()
+		}
+
+		deadline := time.Now().Add(100 * time.Millisecond)
+		for time.Now().Before(deadline) {
+			resp, err := srv.Client().Get(srv.URL)
+			if err != nil {
+				t.Fatalf("scrape failed: %v", err)
+			}
+			body, err := io.ReadAll(resp.Body)
+			_ = resp.Body.Close()
+			if err != nil || resp.StatusCode != http.StatusOK {
+				t.Fatalf("scrape returned %d: %v", resp.StatusCode, err)
+			}
+			if got := strings.Count(string(body), "balance_value{"); got != len(accounts) {
+				t.Fatalf("expected %d balance_value samples, got %d", len(accounts), got)
+			}
+		}
+		close(stop)
+		wg.Wait()
+	})
+}
+
+// scrape serves one GET with the given Accept header and returns the body
+// and content type.
+func scrape(t *testing.T, h http.Handler, accept string) (string, string) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	return rec.Body.String(), rec.Header().Get("Content-Type")
+}
+
+// hasLine reports whether body contains line as a whole line.
+func hasLine(body, line string) bool {
+	for _, l := range strings.Split(body, "\n") {
+		if l == line {
+			return true
+		}
+	}
+	return false
+}
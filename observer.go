@@ -0,0 +1,123 @@
+package balance
+
+import "fmt"
+
+// EventKind identifies the mutation an Event reports.
+type EventKind int
+
+const (
+	// EventAdd reports Add, AddChecked, or AddContext.
+	EventAdd EventKind = iota + 1
+	// EventSubtract reports Subtract or SubtractContext.
+	EventSubtract
+	// EventTransferOut reports the withdrawal side of a transfer.
+	EventTransferOut
+	// EventTransferIn reports the deposit side of a transfer. The
+	// atomics/bugs implementations also report a refunded transfer this way
+	// on the source.
+	EventTransferIn
+	// EventBatch reports a committed or rejected ApplyBatch.
+	EventBatch
+	// EventHold reports Holder.Reserve. It leaves New equal to Old.
+	EventHold
+	// EventSettle reports Holder.Settle, whose Amount is the captured part
+	// of the hold.
+	EventSettle
+)
+
+// String returns a short name for the kind.
+func (k EventKind) String() string {
+	switch k {
+	case EventAdd:
+		return "Add"
+	case EventSubtract:
+		return "Subtract"
+	case EventTransferOut:
+		return "TransferOut"
+	case EventTransferIn:
+		return "TransferIn"
+	case EventBatch:
+		return "Batch"
+	case EventHold:
+		return "Hold"
+	case EventSettle:
+		return "Settle"
+	default:
+		return fmt.Sprintf("EventKind(%d)", int(k))
+	}
+}
+
+// Event describes one mutation of a Balance.
+type Event struct {
+	// Kind is the mutation that produced the event.
+	Kind EventKind
+	// Amount is the requested amount; it is zero for EventBatch, whose net
+	// change is New - Old.
+	Amount int64
+	// Old is the value the mutation started from.
+	Old int64
+	// New is the value the mutation left behind. It equals Old when Err is
+	// set.
+	New int64
+	// Transaction is the TransactionCount after the mutation, or the
+	// unchanged count when Err is set. It is zero for implementations that
+	// do not track metadata.
+	Transaction int64
+	// Timestamp is the LastUpdated stamp the mutation recorded, or the
+	// unchanged one when Err is set. It is zero for implementations that do
+	// not track metadata.
+	Timestamp int64
+	// Err is set for a mutation the balance rejected, such as
+	// *InsufficientFundsError, ErrOverflow, or ErrCeilingExceeded. Failed
+	// events are only delivered with WithFailureEvents. Invalid amounts and
+	// cancelled contexts are rejected before the balance is read and are
+	// never reported.
+	Err error
+}
+
+// Observer receives an Event for every mutation of a balance it is
+// registered with.
+//
+// Observe runs synchronously on the mutating goroutine. Lock-based
+// implementations call it while holding their lock, so events arrive in
+// mutation order, but Observe must not call back into the same balance and
+// should return quickly. Lock-free implementations call it after their CAS
+// or atomic add succeeds, outside any critical section, so concurrent
+// mutations can be observed out of order; each event's Old and New are still
+// the exact values its own update moved between. See each implementation's
+// documentation for its guarantee.
+type Observer interface {
+	Observe(e Event)
+}
+
+// ObserverFunc adapts a function to an Observer.
+type ObserverFunc func(e Event)
+
+// Observe calls f(e).
+func (f ObserverFunc) Observe(e Event) {
+	f(e)
+}
+
+// Observers is the set of hooks an implementation notifies. Build it with
+// WithObserver and WithFailureEvents; the zero value notifies nobody.
+type Observers struct {
+	hooks    []Observer
+	failures bool
+}
+
+// Enabled reports whether any hook is registered, letting implementations
+// skip work that only feeds events.
+func (o Observers) Enabled() bool {
+	return len(o.hooks) > 0
+}
+
+// Notify delivers e to every hook in registration order. Failed events are
+// dropped unless WithFailureEvents was given.
+func (o Observers) Notify(e Event) {
+	if e.Err != nil && !o.failures {
+		return
+	}
+	for _, h := range o.hooks {
+		h.Observe(e)
+	}
+}
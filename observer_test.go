@@ -0,0 +1,221 @@
+package balance_test
+
+import (
+	"errors"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	balance "github.com/madflojo/atomics-v-rwmutex-examples"
+	"github.com/madflojo/atomics-v-rwmutex-examples/balancetest"
+)
+
+func TestObservers(t *testing.T) {
+	for _, impl := range balance.Implementations() {
+		impl := impl
+		if impl.NewWithOptions == nil {
+			continue
+		}
+		t.Run(impl.Name, func(t *testing.T) {
+			t.Run("reports successful mutations", func(t *testing.T) {
+				clock := balancetest.NewManualClock(1_000)
+				log := &eventLog{}
+				acct := balancetest.New(t, impl, balance.WithClock(clock), balance.WithObserver(log))
+
+				acct.Add(100)
+				clock.Advance(time.Second)
+				if err := acct.Subtract(30); err != nil {
+					t.Fatalf("unexpected subtract error: %v", err)
+				}
+				if err := acct.Subtract(1_000); !errors.Is(err, balance.ErrInsufficientFunds) {
+					t.Fatalf("expected ErrInsufficientFunds, got %v", err)
+				}
+				if err := acct.Subtract(0); !errors.Is(err, balance.ErrInvalidAmount) {
+					t.Fatalf("expected ErrInvalidAmount, got %v", err)
+				}
+				err := balance.ApplyBatch(acct, []balance.Op{
+					{Kind: balance.OpAdd, Amount: 5},
+					{Kind: balance.OpSubtract, Amount: 15},
+				})
+				if err != nil {
+					t.Fatalf("unexpected batch error: %v", err)
+				}
+
+				want := []balance.Event{
+					{Kind: balance.EventAdd, Amount: 100, Old: 0, New: 100, Transaction: 1, Timestamp: 1_000},
+					{Kind: balance.EventSubtract, Amount: 30, Old: 100, New: 70, Transaction: 2, Timestamp: 1_000 + int64(time.Second)},
+					{Kind: balance.EventBatch, Old: 70, New: 60, Transaction: 4, Timestamp: 1_000 + int64(time.Second)},
+				}
+				assertEvents(t, impl, log.Events(), want)
+			})
+
+			t.Run("reports failures when asked", func(t *testing.T) {
+				log := &eventLog{}
+				acct := balancetest.New(t, impl, balance.WithObserver(log), balance.WithFailureEvents())
+				acct.Add(10)
+				before := acct.Snapshot()
+
+				if err := acct.Subtract(11); !errors.Is(err, balance.ErrInsufficientFunds) {
+					t.Fatalf("expected ErrInsufficientFunds, got %v", err)
+				}
+				if err := acct.Subtract(-1); !errors.Is(err, balance.ErrInvalidAmount) {
+					t.Fatalf("expected ErrInvalidAmount, got %v", err)
+				}
+
+				events := log.Events()
+				if len(events) != 2 {
+					t.Fatalf("expected 2 events, got %d: %+v", len(events), events)
+				}
+				got := events[1]
+				if got.Kind != balance.EventSubtract || got.Amount != 11 || got.Old != 10 || got.New != 10 {
+					t.Fatalf("unexpected failure event: %+v", got)
+				}
+				if !errors.Is(got.Err, balance.ErrInsufficientFunds) {
+					t.Fatalf("expected failure event to carry ErrInsufficientFunds, got %v", got.Err)
+				}
+				if got.Transaction != before.TransactionCount || got.Timestamp != before.LastUpdated {
+					t.Fatalf("failure event metadata %d/%d, want unchanged %d/%d",
+						got.Transaction, got.Timestamp, before.TransactionCount, before.LastUpdated)
+				}
+			})
+
+			t.Run("reports both sides of a transfer", func(t *testing.T) {
+				fromLog, toLog := &eventLog{}, &eventLog{}
+				from := balancetest.New(t, impl, balance.WithObserver(fromLog))
+				to := balancetest.New(t, impl, balance.WithObserver(toLog))
+				if _, ok := from.(balance.Transferer); !ok {
+					t.Skip("implementation does not support transfers")
+				}
+				from.Add(50)
+
+				if err := balance.Transfer(from, to, 20); err != nil {
+					t.Fatalf("unexpected transfer error: %v", err)
+				}
+
+				assertEvents(t, impl, fromLog.Events(), []balance.Event{
+					{Kind: balance.EventAdd, Amount: 50, Old: 0, New: 50, Transaction: 1},
+					{Kind: balance.EventTransferOut, Amount: 20, Old: 50, New: 30, Transaction: 2},
+				})
+				assertEvents(t, impl, toLog.Events(), []balance.Event{
+					{Kind: balance.EventTransferIn, Amount: 20, Old: 0, New: 20, Transaction: 1},
+				})
+			})
+
+			t.Run("notifies observers in registration order", func(t *testing.T) {
+				var calls []string
+				first := balance.ObserverFunc(func(balance.Event) { calls = append(calls, "first") })
+				second := balance.ObserverFunc(func(balance.Event) { calls = append(calls, "second") })
+				acct := balancetest.New(t, impl,
+					balance.WithObserver(first), balance.WithObserver(nil), balance.WithObserver(second))
+
+				acct.Add(1)
+				if len(calls) != 2 || calls[0] != "first" || calls[1] != "second" {
+					t.Fatalf("unexpected call order: %v", calls)
+				}
+			})
+
+			t.Run("delivers every mutation under concurrency", func(t *testing.T) {
+				const (
+					workers = 8
+					iters   = 250
+				)
+
+				log := &eventLog{}
+				acct := balancetest.New(t, impl, balance.WithObserver(log))
+				acct.Add(1_000)
+
+				var (
+					wg        sync.WaitGroup
+					succeeded atomic.Int64
+				)
+				for w := 0; w < workers; w++ {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						for i := 0; i < iters; i++ {
+							amount := 1 + rand.Int64N(20)
+							if rand.IntN(2) == 0 {
+								acct.Add(amount)
+								succeeded.Add(1)
+								continue
+							}
+							if err := acct.Subtract(amount); err == nil {
+								succeeded.Add(1)
+							}
+						}
+					}()
+				}
+				wg.Wait()
+
+				events := log.Events()
+				if int64(len(events)) != 1+succeeded.Load() {
+					t.Fatalf("expected %d events, got %d", 1+succeeded.Load(), len(events))
+				}
+
+				var net int64
+				for _, e := range events {
+					net += e.New - e.Old
+				}
+				if got := acct.Balance(); got != net {
+					t.Fatalf("events net to %d, balance is %d", net, got)
+				}
+
+				if !impl.Traits.OrderedEvents {
+					return
+				}
+				for i := 1; i < len(events); i++ {
+					prev, e := events[i-1], events[i]
+					if e.Old != prev.New {
+						t.Fatalf("event %d starts at %d, previous ended at %d", i, e.Old, prev.New)
+					}
+					if impl.Traits.TracksMetadata && e.Transaction != prev.Transaction+1 {
+						t.Fatalf("event %d has transaction %d after %d", i, e.Transaction, prev.Transaction)
+					}
+				}
+			})
+		})
+	}
+}
+
+// eventLog is an Observer that records events in delivery order.
+type eventLog struct {
+	mu     sync.Mutex
+	events []balance.Event
+}
+
+func (l *eventLog) Observe(e balance.Event) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(l.events, e)
+}
+
+// Events returns a copy of the events recorded so far.
+func (l *eventLog) Events() []balance.Event {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]balance.Event(nil), l.events...)
+}
+
+// assertEvents compares got against want, ignoring Transaction and
+// Timestamp for implementations that do not track metadata and Timestamp
+// wherever want leaves it zero.
+func assertEvents(t *testing.T, impl balance.Implementation, got, want []balance.Event) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("expected %d events, got %d: %+v", len(want), len(got), got)
+	}
+	for i := range want {
+		w := want[i]
+		if !impl.Traits.TracksMetadata {
+			w.Transaction, w.Timestamp = 0, 0
+		}
+		if w.Timestamp == 0 {
+			w.Timestamp = got[i].Timestamp
+		}
+		if got[i] != w {
+			t.Fatalf("event %d: got %+v want %+v", i, got[i], w)
+		}
+	}
+}
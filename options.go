@@ -0,0 +1,90 @@
+package balance
+
+import "fmt"
+
+// Options holds the settings shared by implementations that accept
+// option-style constructors. Build it with NewOptions.
+type Options struct {
+	// Clock stamps LastUpdated. It is never nil after NewOptions.
+	Clock Clock
+	// Limits bounds the balance. The zero value keeps the default floor of
+	// zero and no ceiling.
+	Limits Limits
+	// BatchCounting selects how ApplyBatch advances TransactionCount.
+	BatchCounting BatchCounting
+	// Observers receives an Event for every mutation.
+	Observers Observers
+	// Stats enables contention counters reported by StatsBalance.
+	Stats bool
+}
+
+// Option configures an implementation at construction time.
+type Option func(*Options)
+
+// WithClock makes an implementation read timestamps from c instead of the
+// system clock. A nil c keeps the default.
+func WithClock(c Clock) Option {
+	return func(o *Options) {
+		if c != nil {
+			o.Clock = c
+		}
+	}
+}
+
+// WithLimits makes an implementation enforce l on every withdrawal and
+// deposit. It panics if l fails Validate.
+func WithLimits(l Limits) Option {
+	if err := l.Validate(); err != nil {
+		panic(fmt.Sprintf("balance: WithLimits called with %+v: %v", l, err))
+	}
+	return func(o *Options) {
+		o.Limits = l
+	}
+}
+
+// WithBatchCounting selects how ApplyBatch advances TransactionCount on
+// implementations that track metadata.
+func WithBatchCounting(c BatchCounting) Option {
+	return func(o *Options) {
+		o.BatchCounting = c
+	}
+}
+
+// WithObserver registers obs to receive an Event for every successful
+// mutation. It may be given more than once; observers are notified in the
+// order they were registered. A nil obs is ignored.
+func WithObserver(obs Observer) Option {
+	return func(o *Options) {
+		if obs != nil {
+			o.Observers.hooks = append(o.Observers.hooks, obs)
+		}
+	}
+}
+
+// WithFailureEvents makes observers also receive an Event, with Err set, for
+// every mutation the balance rejects.
+func WithFailureEvents() Option {
+	return func(o *Options) {
+		o.Observers.failures = true
+	}
+}
+
+// WithStats makes an implementation count CAS retries or lock waits and
+// report them through StatsBalance. It is off by default because measuring
+// lock waits reads the clock on every contended acquisition.
+func WithStats() Option {
+	return func(o *Options) {
+		o.Stats = true
+	}
+}
+
+// NewOptions applies opts over the defaults.
+func NewOptions(opts ...Option) Options {
+	o := Options{Clock: SystemClock}
+	for _, opt := range opts {
+		if opt != nil {
+			opt(&o)
+		}
+	}
+	return o
+}
@@ -0,0 +1,77 @@
+package balance_test
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	balance "github.com/madflojo/atomics-v-rwmutex-examples"
+	"github.com/madflojo/atomics-v-rwmutex-examples/balancetest"
+)
+
+func TestAddChecked(t *testing.T) {
+	for _, impl := range balance.Implementations() {
+		impl := impl
+		t.Run(impl.Name, func(t *testing.T) {
+			acct, ok := balancetest.New(t, impl).(balance.CheckedBalance)
+			if !ok {
+				t.Fatalf("implementation does not satisfy CheckedBalance")
+			}
+
+			if err := acct.AddChecked(math.MaxInt64 - 10); err != nil {
+				t.Fatalf("unexpected error seeding balance: %v", err)
+			}
+
+			t.Run("up to the boundary", func(t *testing.T) {
+				if err := acct.AddChecked(10); err != nil {
+					t.Fatalf("unexpected error reaching MaxInt64: %v", err)
+				}
+				if acct.Balance() != math.MaxInt64 {
+					t.Fatalf("expected MaxInt64, got %d", acct.Balance())
+				}
+			})
+
+			t.Run("past the boundary", func(t *testing.T) {
+				before := acct.Snapshot()
+				for _, amount := range []int64{1, 1_000, math.MaxInt64} {
+					if err := acct.AddChecked(amount); !errors.Is(err, balance.ErrOverflow) {
+						t.Fatalf("AddChecked(%d): expected ErrOverflow, got %v", amount, err)
+					}
+				}
+				if after := acct.Snapshot(); after != before {
+					t.Fatalf("rejected deposits changed state, got %+v want %+v", after, before)
+				}
+			})
+
+			t.Run("withdraw then deposit again", func(t *testing.T) {
+				if err := acct.Subtract(5); err != nil {
+					t.Fatalf("unexpected subtract error: %v", err)
+				}
+				if err := acct.AddChecked(5); err != nil {
+					t.Fatalf("unexpected error re-filling to MaxInt64: %v", err)
+				}
+				if err := acct.AddChecked(1); !errors.Is(err, balance.ErrOverflow) {
+					t.Fatalf("expected ErrOverflow, got %v", err)
+				}
+			})
+
+			t.Run("transfer into a full account", func(t *testing.T) {
+				src := balancetest.New(t, impl)
+				if _, ok := src.(balance.Transferer); !ok {
+					t.Skip("implementation does not support transfers")
+				}
+				src.Add(100)
+
+				if err := balance.Transfer(src, acct, 50); !errors.Is(err, balance.ErrOverflow) {
+					t.Fatalf("expected ErrOverflow, got %v", err)
+				}
+				if src.Balance() != 100 {
+					t.Fatalf("source lost funds on rejected transfer, got %d", src.Balance())
+				}
+				if acct.Balance() != math.MaxInt64 {
+					t.Fatalf("destination changed on rejected transfer, got %d", acct.Balance())
+				}
+			})
+		})
+	}
+}
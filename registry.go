@@ -0,0 +1,115 @@
+package balance
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Strategy names the synchronization technique an implementation relies on.
+type Strategy string
+
+const (
+	// StrategyAtomic uses plain atomic loads and adds without CAS protection.
+	StrategyAtomic Strategy = "atomic"
+	// StrategyCAS guards read-modify-write updates with compare-and-swap loops.
+	StrategyCAS Strategy = "cas"
+	// StrategyCopyOnWrite publishes immutable state through atomic.Pointer
+	// CAS loops.
+	StrategyCopyOnWrite Strategy = "cow"
+	// StrategyRWMutex guards state with a sync.RWMutex.
+	StrategyRWMutex Strategy = "rwmutex"
+	// StrategyMutex guards state with a sync.Mutex.
+	StrategyMutex Strategy = "mutex"
+	// StrategyActor confines state to a single goroutine fed by channels.
+	StrategyActor Strategy = "actor"
+	// StrategySeqlock serializes writers and lets readers retry on a
+	// sequence counter.
+	StrategySeqlock Strategy = "seqlock"
+	// StrategySharded spreads state across padded stripes and aggregates on
+	// read.
+	StrategySharded Strategy = "sharded"
+)
+
+// Factory constructs a fresh, zeroed Balance.
+type Factory func() Balance
+
+// OptionFactory constructs a fresh, zeroed Balance configured by opts.
+type OptionFactory func(opts ...Option) Balance
+
+// Traits describes the behavior callers can expect from an implementation.
+type Traits struct {
+	// Strategy is the synchronization technique used.
+	Strategy Strategy
+	// TracksMetadata reports whether TransactionCount and LastUpdated are
+	// maintained. Implementations without metadata always report zero.
+	TracksMetadata bool
+	// ConsistentSnapshot reports whether Snapshot reads every field as one
+	// consistent unit.
+	ConsistentSnapshot bool
+	// OrderedEvents reports whether observers registered with WithObserver
+	// see events in mutation order.
+	OrderedEvents bool
+	// KnownBuggy marks implementations that intentionally break the
+	// no-negative guarantee under contention.
+	KnownBuggy bool
+}
+
+// Implementation describes a registered Balance variant.
+type Implementation struct {
+	// Name uniquely identifies the implementation.
+	Name string
+	// New constructs a fresh instance.
+	New Factory
+	// NewWithOptions constructs a fresh instance configured by options. It
+	// is nil for implementations that do not accept options.
+	NewWithOptions OptionFactory
+	// Traits describes the implementation's guarantees.
+	Traits Traits
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]Implementation)
+)
+
+// Register makes an implementation available through Lookup and
+// Implementations. Implementation packages call it from init. Register
+// panics if the name is empty, the factory is nil, or the name is already
+// registered.
+func Register(impl Implementation) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if impl.Name == "" {
+		panic("balance: Register called with empty name")
+	}
+	if impl.New == nil {
+		panic(fmt.Sprintf("balance: Register called with nil factory for %q", impl.Name))
+	}
+	if _, dup := registry[impl.Name]; dup {
+		panic(fmt.Sprintf("balance: Register called twice for %q", impl.Name))
+	}
+	registry[impl.Name] = impl
+}
+
+// Lookup returns the implementation registered under name.
+func Lookup(name string) (Implementation, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	impl, ok := registry[name]
+	return impl, ok
+}
+
+// Implementations returns every registered implementation sorted by name.
+func Implementations() []Implementation {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	impls := make([]Implementation, 0, len(registry))
+	for _, impl := range registry {
+		impls = append(impls, impl)
+	}
+	sort.Slice(impls, func(i, j int) bool { return impls[i].Name < impls[j].Name })
+	return impls
+}
@@ -0,0 +1,102 @@
+package balance
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+)
+
+// SnapshotVersion is the serialization format written by Snapshot's
+// marshalers. Unmarshalers reject any other version.
+const SnapshotVersion = 1
+
+// snapshotBinarySize is the version byte followed by three int64 fields.
+const snapshotBinarySize = 1 + 3*8
+
+// snapshotJSON is the JSON form of a Snapshot.
+type snapshotJSON struct {
+	Version          int   `json:"version"`
+	Value            int64 `json:"value"`
+	TransactionCount int64 `json:"transaction_count"`
+	LastUpdated      int64 `json:"last_updated"`
+}
+
+// MarshalBinary encodes s as a version byte followed by Value,
+// TransactionCount and LastUpdated as little-endian int64s.
+func (s Snapshot) MarshalBinary() ([]byte, error) {
+	data := make([]byte, 0, snapshotBinarySize)
+	data = append(data, SnapshotVersion)
+	data = binary.LittleEndian.AppendUint64(data, uint64(s.Value))
+	data = binary.LittleEndian.AppendUint64(data, uint64(s.TransactionCount))
+	data = binary.LittleEndian.AppendUint64(data, uint64(s.LastUpdated))
+	return data, nil
+}
+
+// UnmarshalBinary decodes data written by MarshalBinary. It returns an
+// error wrapping ErrSnapshotVersion for another format version, and
+// ErrInvalidSnapshot for data of the wrong length.
+func (s *Snapshot) UnmarshalBinary(data []byte) error {
+	if len(data) == 0 {
+		return fmt.Errorf("%w: empty", ErrInvalidSnapshot)
+	}
+	if data[0] != SnapshotVersion {
+		return fmt.Errorf("%w: %d", ErrSnapshotVersion, data[0])
+	}
+	if len(data) != snapshotBinarySize {
+		return fmt.Errorf("%w: %d bytes, want %d", ErrInvalidSnapshot, len(data), snapshotBinarySize)
+	}
+
+	*s = Snapshot{
+		Value:            int64(binary.LittleEndian.Uint64(data[1:])),
+		TransactionCount: int64(binary.LittleEndian.Uint64(data[9:])),
+		LastUpdated:      int64(binary.LittleEndian.Uint64(data[17:])),
+	}
+	return nil
+}
+
+// MarshalJSON encodes s as an object carrying the format version:
+//
+//	{"version":1,"value":100,"transaction_count":3,"last_updated":1700000000000000000}
+func (s Snapshot) MarshalJSON() ([]byte, error) {
+	return json.Marshal(snapshotJSON{
+		Version:          SnapshotVersion,
+		Value:            s.Value,
+		TransactionCount: s.TransactionCount,
+		LastUpdated:      s.LastUpdated,
+	})
+}
+
+// UnmarshalJSON decodes data written by MarshalJSON. It returns an error
+// wrapping ErrSnapshotVersion when the version is missing or unknown.
+func (s *Snapshot) UnmarshalJSON(data []byte) error {
+	var v snapshotJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidSnapshot, err)
+	}
+	if v.Version != SnapshotVersion {
+		return fmt.Errorf("%w: %d", ErrSnapshotVersion, v.Version)
+	}
+
+	*s = Snapshot{
+		Value:            v.Value,
+		TransactionCount: v.TransactionCount,
+		LastUpdated:      v.LastUpdated,
+	}
+	return nil
+}
+
+// Check returns an error wrapping ErrInvalidSnapshot if s cannot be
+// restored into an account bounded by l: a value outside l, or a negative
+// transaction count or timestamp.
+func (s Snapshot) Check(l Limits) error {
+	if s.Value < l.Min() || s.Value > l.Max() {
+		return fmt.Errorf("%w: value %d outside [%d, %d]", ErrInvalidSnapshot, s.Value, l.Min(), l.Max())
+	}
+	if s.TransactionCount < 0 {
+		return fmt.Errorf("%w: negative transaction count %d", ErrInvalidSnapshot, s.TransactionCount)
+	}
+	if s.LastUpdated < 0 {
+		return fmt.Errorf("%w: negative timestamp %d", ErrInvalidSnapshot, s.LastUpdated)
+	}
+	return nil
+}
@@ -0,0 +1,257 @@
+package balance_test
+
+import (
+	"encoding"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+
+	balance "github.com/madflojo/atomics-v-rwmutex-examples"
+	"github.com/madflojo/atomics-v-rwmutex-examples/balancetest"
+	casfull "github.com/madflojo/atomics-v-rwmutex-examples/implementations/atomics/cas/full"
+	mutexfull "github.com/madflojo/atomics-v-rwmutex-examples/implementations/mutex/full"
+	rwmutexfull "github.com/madflojo/atomics-v-rwmutex-examples/implementations/rwmutex/full"
+)
+
+// serializable is a balance that can save and load its state.
+type serializable interface {
+	balance.Balance
+	encoding.BinaryMarshaler
+	encoding.BinaryUnmarshaler
+	json.Marshaler
+	json.Unmarshaler
+}
+
+// restorers lists every Restore constructor.
+var restorers = []struct {
+	name    string
+	restore func(balance.Snapshot, ...balance.Option) (serializable, error)
+}{
+	{"cas/full", func(s balance.Snapshot, opts ...balance.Option) (serializable, error) {
+		return casfull.Restore(s, opts...)
+	}},
+	{"mutex/full", func(s balance.Snapshot, opts ...balance.Option) (serializable, error) {
+		return mutexfull.Restore(s, opts...)
+	}},
+	{"rwmutex/full", func(s balance.Snapshot, opts ...balance.Option) (serializable, error) {
+		return rwmutexfull.Restore(s, opts...)
+	}},
+}
+
+func TestSnapshotEncoding(t *testing.T) {
+	want := balance.Snapshot{Value: -42, TransactionCount: 7, LastUpdated: 1_700_000_000_123_456_789}
+
+	t.Run("binary", func(t *testing.T) {
+		data, err := want.MarshalBinary()
+		if err != nil {
+			t.Fatalf("unexpected marshal error: %v", err)
+		}
+		var got balance.Snapshot
+		if err := got.UnmarshalBinary(data); err != nil {
+			t.Fatalf("unexpected unmarshal error: %v", err)
+		}
+		if got != want {
+			t.Fatalf("expected %+v, got %+v", want, got)
+		}
+
+		data[0] = balance.SnapshotVersion + 1
+		if err := got.UnmarshalBinary(data); !errors.Is(err, balance.ErrSnapshotVersion) {
+			t.Fatalf("expected ErrSnapshotVersion, got %v", err)
+		}
+		data[0] = balance.SnapshotVersion
+		if err := got.UnmarshalBinary(data[:len(data)-1]); !errors.Is(err, balance.ErrInvalidSnapshot) {
+			t.Fatalf("expected ErrInvalidSnapshot for a short buffer, got %v", err)
+		}
+	})
+
+	t.Run("json", func(t *testing.T) {
+		data, err := json.Marshal(want)
+		if err != nil {
+			t.Fatalf("unexpected marshal error: %v", err)
+		}
+		const encoded = `{"version":1,"value":-42,"transaction_count":7,"last_updated":1700000000123456789}`
+		if string(data) != encoded {
+			t.Fatalf("expected %s, got %s", encoded, data)
+		}
+		var got balance.Snapshot
+		if err := json.Unmarshal(data, &got); err != nil {
+			t.Fatalf("unexpected unmarshal error: %v", err)
+		}
+		if got != want {
+			t.Fatalf("expected %+v, got %+v", want, got)
+		}
+
+		if err := json.Unmarshal([]byte(`{"value":1}`), &got); !errors.Is(err, balance.ErrSnapshotVersion) {
+			t.Fatalf("expected ErrSnapshotVersion without a version, got %v", err)
+		}
+		if err := json.Unmarshal([]byte(`{"version":1,"value":"x"}`), &got); !errors.Is(err, balance.ErrInvalidSnapshot) {
+			t.Fatalf("expected ErrInvalidSnapshot, got %v", err)
+		}
+	})
+
+	t.Run("check", func(t *testing.T) {
+		if err := want.Check(balance.Limits{Overdraft: 100}); err != nil {
+			t.Fatalf("unexpected check error: %v", err)
+		}
+		if err := want.Check(balance.Limits{}); !errors.Is(err, balance.ErrInvalidSnapshot) {
+			t.Fatalf("expected ErrInvalidSnapshot below the floor, got %v", err)
+		}
+		bad := balance.Snapshot{Value: 1, TransactionCount: -1}
+		if err := bad.Check(balance.Limits{}); !errors.Is(err, balance.ErrInvalidSnapshot) {
+			t.Fatalf("expected ErrInvalidSnapshot for a negative count, got %v", err)
+		}
+	})
+}
+
+func TestRestore(t *testing.T) {
+	for _, from := range restorers {
+		for _, to := range restorers {
+			from, to := from, to
+			t.Run(from.name+" to "+to.name, func(t *testing.T) {
+				clock := balancetest.NewManualClock(1_000)
+				src, err := from.restore(balance.Snapshot{}, balance.WithClock(clock))
+				if err != nil {
+					t.Fatalf("unexpected restore error: %v", err)
+				}
+				src.Add(100)
+				clock.Advance(5)
+				if err := src.Subtract(40); err != nil {
+					t.Fatalf("unexpected subtract error: %v", err)
+				}
+				want := src.Snapshot()
+
+				t.Run("constructor", func(t *testing.T) {
+					dst, err := to.restore(want)
+					if err != nil {
+						t.Fatalf("unexpected restore error: %v", err)
+					}
+					if got := dst.Snapshot(); got != want {
+						t.Fatalf("expected %+v, got %+v", want, got)
+					}
+					dst.Add(1)
+					if got := dst.TransactionCount(); got != want.TransactionCount+1 {
+						t.Fatalf("expected restored count to keep counting, got %d", got)
+					}
+				})
+
+				t.Run("binary", func(t *testing.T) {
+					data, err := src.MarshalBinary()
+					if err != nil {
+						t.Fatalf("unexpected marshal error: %v", err)
+					}
+					dst, _ := to.restore(balance.Snapshot{})
+					if err := dst.UnmarshalBinary(data); err != nil {
+						t.Fatalf("unexpected unmarshal error: %v", err)
+					}
+					if got := dst.Snapshot(); got != want {
+						t.Fatalf("expected %+v, got %+v", want, got)
+					}
+				})
+
+				t.Run("json", func(t *testing.T) {
+					data, err := json.Marshal(src)
+					if err != nil {
+						t.Fatalf("unexpected marshal error: %v", err)
+					}
+					dst, _ := to.restore(balance.Snapshot{})
+					if err := json.Unmarshal(data, dst); err != nil {
+						t.Fatalf("unexpected unmarshal error: %v", err)
+					}
+					if got := dst.Snapshot(); got != want {
+						t.Fatalf("expected %+v, got %+v", want, got)
+					}
+				})
+			})
+		}
+	}
+
+	for _, r := range restorers {
+		r := r
+		t.Run(r.name+" rejects state outside its limits", func(t *testing.T) {
+			over := balance.Snapshot{Value: 500}
+			if _, err := r.restore(over, balance.WithLimits(balance.Limits{Ceiling: 100})); !errors.Is(err, balance.ErrInvalidSnapshot) {
+				t.Fatalf("expected ErrInvalidSnapshot, got %v", err)
+			}
+
+			dst, _ := r.restore(balance.Snapshot{Value: 10}, balance.WithLimits(balance.Limits{Ceiling: 100}))
+			data, _ := over.MarshalBinary()
+			if err := dst.UnmarshalBinary(data); !errors.Is(err, balance.ErrInvalidSnapshot) {
+				t.Fatalf("expected ErrInvalidSnapshot, got %v", err)
+			}
+			if got := dst.Balance(); got != 10 {
+				t.Fatalf("expected a rejected unmarshal to leave 10, got %d", got)
+			}
+		})
+
+		t.Run(r.name+" marshals a consistent snapshot under writers", func(t *testing.T) {
+			acct, _ := r.restore(balance.Snapshot{})
+			stop := make(chan struct{})
+			var wg sync.WaitGroup
+			for w := 0; w < 4; w++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for {
+						select {
+						case <-stop:
+							return
+						default:
+							acct.Add(1)
+						}
+					}
+				}()
+			}
+
+			// Every deposit adds one to both the value and the count, so a
+			// consistent read always finds them equal.
+			for acct.TransactionCount() < 100_000 {
+				data, err := acct.MarshalBinary()
+				if err != nil {
+					t.Fatalf("unexpected marshal error: %v", err)
+				}
+				var s balance.Snapshot
+				if err := s.UnmarshalBinary(data); err != nil {
+					t.Fatalf("unexpected unmarshal error: %v", err)
+				}
+				if s.Value != s.TransactionCount {
+					close(stop)
+					wg.Wait()
+					t.Fatalf("inconsistent snapshot %+v", s)
+				}
+			}
+			close(stop)
+			wg.Wait()
+		})
+
+		t.Run(r.name+" keeps held funds covered", func(t *testing.T) {
+			dst, _ := r.restore(balance.Snapshot{Value: 100})
+			h, ok := dst.(balance.Holder)
+			if !ok {
+				t.Skip(r.name + " does not implement balance.Holder")
+			}
+			if err := h.Reserve(100); err != nil {
+				t.Fatalf("unexpected reserve error: %v", err)
+			}
+
+			short, _ := balance.Snapshot{Value: 10}.MarshalBinary()
+			if err := dst.UnmarshalBinary(short); !errors.Is(err, balance.ErrInvalidSnapshot) {
+				t.Fatalf("expected ErrInvalidSnapshot below the held funds, got %v", err)
+			}
+			if err := json.Unmarshal([]byte(`{"version":1,"value":10}`), dst); !errors.Is(err, balance.ErrInvalidSnapshot) {
+				t.Fatalf("expected ErrInvalidSnapshot below the held funds, got %v", err)
+			}
+			if got := h.Available(); got != 0 {
+				t.Fatalf("expected a rejected unmarshal to leave 0 available, got %d", got)
+			}
+
+			covered, _ := balance.Snapshot{Value: 150}.MarshalBinary()
+			if err := dst.UnmarshalBinary(covered); err != nil {
+				t.Fatalf("unexpected unmarshal error: %v", err)
+			}
+			if got := h.Available(); got != 50 {
+				t.Fatalf("expected 50 available with 100 held, got %d", got)
+			}
+		})
+	}
+}
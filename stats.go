@@ -0,0 +1,27 @@
+package balance
+
+import "time"
+
+// Stats reports how much contention an instrumented balance has seen since
+// it was created. Enable it with WithStats.
+type Stats struct {
+	// CASRetries counts compare-and-swap attempts that lost to a concurrent
+	// writer and had to reload and try again.
+	CASRetries int64
+	// LockWaits counts lock acquisitions that could not take the lock
+	// immediately and had to wait for it.
+	LockWaits int64
+	// LockWait is the total time spent waiting for locks.
+	LockWait time.Duration
+}
+
+// StatsBalance is implemented by balances that can report contention
+// statistics. CAS-based implementations count retries; lock-based ones
+// record lock waits. Stats is all zero unless the balance was built with
+// WithStats.
+type StatsBalance interface {
+	Balance
+	// Stats returns the counters accumulated so far. Each field is read
+	// atomically, but the fields are not read as one consistent unit.
+	Stats() Stats
+}
@@ -0,0 +1,114 @@
+package balance_test
+
+import (
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	balance "github.com/madflojo/atomics-v-rwmutex-examples"
+	"github.com/madflojo/atomics-v-rwmutex-examples/balancetest"
+)
+
+func TestStats(t *testing.T) {
+	for _, impl := range balance.Implementations() {
+		impl := impl
+		if _, ok := impl.New().(balance.StatsBalance); !ok || impl.NewWithOptions == nil {
+			continue
+		}
+		t.Run(impl.Name, func(t *testing.T) {
+			t.Run("disabled by default", func(t *testing.T) {
+				acct := balancetest.New(t, impl).(balance.StatsBalance)
+				hammer(acct, 4, 500)
+				if got := acct.Stats(); got != (balance.Stats{}) {
+					t.Fatalf("expected zero stats without WithStats, got %+v", got)
+				}
+			})
+
+			switch impl.Traits.Strategy {
+			case balance.StrategyMutex, balance.StrategyRWMutex:
+				t.Run("records lock waits", func(t *testing.T) {
+					const hold = 20 * time.Millisecond
+
+					// The observer runs under the lock, so blocking it holds
+					// the lock while a second writer queues behind it.
+					locked, release := make(chan struct{}), make(chan struct{})
+					var once sync.Once
+					block := balance.ObserverFunc(func(balance.Event) {
+						once.Do(func() {
+							close(locked)
+							<-release
+						})
+					})
+					acct := balancetest.New(t, impl, balance.WithStats(), balance.WithObserver(block)).(balance.StatsBalance)
+
+					done := make(chan struct{})
+					go func() {
+						defer close(done)
+						acct.Add(1)
+					}()
+					<-locked
+
+					waited := make(chan struct{})
+					go func() {
+						defer close(waited)
+						acct.Add(1)
+					}()
+					time.Sleep(hold)
+					close(release)
+					<-done
+					<-waited
+
+					got := acct.Stats()
+					if got.LockWaits != 1 {
+						t.Fatalf("expected 1 lock wait, got %+v", got)
+					}
+					if got.LockWait < hold/2 {
+						t.Fatalf("expected at least %v of lock wait, got %+v", hold/2, got)
+					}
+					if got.CASRetries != 0 {
+						t.Fatalf("lock-based balance reported CAS retries: %+v", got)
+					}
+				})
+
+			case balance.StrategyCAS, balance.StrategyCopyOnWrite:
+				t.Run("counts CAS retries", func(t *testing.T) {
+					acct := balancetest.New(t, impl, balance.WithStats()).(balance.StatsBalance)
+
+					// A retry needs a writer to be interrupted between its
+					// load and its CAS. Running more Ps than CPUs lets the
+					// OS do that even on a single core; keep hammering until
+					// one shows up.
+					defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(8))
+					deadline := time.Now().Add(5 * time.Second)
+					for acct.Stats().CASRetries == 0 {
+						if time.Now().After(deadline) {
+							t.Skip("no CAS contention observed")
+						}
+						hammer(acct, 8, 5_000)
+					}
+					if got := acct.Stats(); got.LockWaits != 0 || got.LockWait != 0 {
+						t.Fatalf("CAS balance reported lock waits: %+v", got)
+					}
+				})
+			}
+		})
+	}
+}
+
+// hammer runs workers goroutines that each alternate iters deposits and
+// withdrawals against acct.
+func hammer(acct balance.Balance, workers, iters int) {
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < iters; i++ {
+				acct.Add(2)
+				_ = acct.Subtract(1)
+			}
+		}()
+	}
+	wg.Wait()
+}
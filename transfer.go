@@ -0,0 +1,49 @@
+package balance
+
+// Transferer is implemented by balances that can move funds to another
+// account of the same implementation as a single operation.
+type Transferer interface {
+	// TransferTo withdraws amount from the receiver and deposits it into
+	// dst. It fails without changing either account if amount is not
+	// positive or the receiver would go negative. dst must be the same
+	// implementation as the receiver, otherwise ErrIncompatibleBalance is
+	// returned.
+	TransferTo(dst Balance, amount int64) error
+}
+
+// PairReader is implemented by balances that can read their value together
+// with another account's, so a transfer between them is seen either whole
+// or not at all.
+type PairReader interface {
+	// BalanceWith returns the receiver's value and other's, as of the same
+	// instant. other must be the same implementation as the receiver,
+	// otherwise ErrIncompatibleBalance is returned.
+	BalanceWith(other Balance) (int64, int64, error)
+}
+
+// Transfer moves amount from one account to another as one atomic step: no
+// reader, and no ReadPair, ever sees the funds in neither account.
+// Lock-based implementations hold both locks for the whole move, acquired
+// in a fixed global order so opposing transfers cannot deadlock. Lock-free
+// implementations freeze both accounts with a shared descriptor that
+// concurrent readers resolve and writers help complete. The atomics/bugs
+// variants are the exception: they withdraw and then deposit without
+// coordination. atomics/cas/simple returns ErrTransferUnsupported.
+func Transfer(from, to Balance, amount int64) error {
+	t, ok := from.(Transferer)
+	if !ok {
+		return ErrTransferUnsupported
+	}
+	return t.TransferTo(to, amount)
+}
+
+// ReadPair returns the values of a and b as of the same instant, so the sum
+// of two accounts only trading with each other never changes. It returns
+// ErrTransferUnsupported if a cannot read itself with another account.
+func ReadPair(a, b Balance) (int64, int64, error) {
+	r, ok := a.(PairReader)
+	if !ok {
+		return 0, 0, ErrTransferUnsupported
+	}
+	return r.BalanceWith(b)
+}
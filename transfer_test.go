@@ -0,0 +1,221 @@
+package balance_test
+
+import (
+	"errors"
+	"math/rand/v2"
+	"sync"
+	"testing"
+
+	balance "github.com/madflojo/atomics-v-rwmutex-examples"
+	"github.com/madflojo/atomics-v-rwmutex-examples/balancetest"
+)
+
+func TestTransfer(t *testing.T) {
+	for _, impl := range balance.Implementations() {
+		impl := impl
+		t.Run(impl.Name, func(t *testing.T) {
+			const (
+				accounts = 4
+				deposit  = 1_000
+				rounds   = 10
+				workers  = 8
+				iters    = 25
+			)
+
+			accts := make([]balance.Balance, accounts)
+			for i := range accts {
+				accts[i] = balancetest.New(t, impl)
+				accts[i].Add(deposit)
+			}
+			total := int64(accounts * deposit)
+
+			if _, ok := accts[0].(balance.Transferer); !ok {
+				t.Run("unsupported", func(t *testing.T) {
+					if err := balance.Transfer(accts[0], accts[1], 1); !errors.Is(err, balance.ErrTransferUnsupported) {
+						t.Fatalf("expected ErrTransferUnsupported, got %v", err)
+					}
+					if _, _, err := balance.ReadPair(accts[0], accts[1]); !errors.Is(err, balance.ErrTransferUnsupported) {
+						t.Fatalf("expected ErrTransferUnsupported from ReadPair, got %v", err)
+					}
+					if accts[0].Balance() != deposit || accts[1].Balance() != deposit {
+						t.Fatalf("unsupported transfer changed balances")
+					}
+				})
+				return
+			}
+			_, pairs := accts[0].(balance.PairReader)
+
+			t.Run("rejects same account", func(t *testing.T) {
+				if err := balance.Transfer(accts[0], accts[0], 1); !errors.Is(err, balance.ErrSameAccount) {
+					t.Fatalf("expected ErrSameAccount, got %v", err)
+				}
+				if _, _, err := balance.ReadPair(accts[0], accts[0]); pairs && !errors.Is(err, balance.ErrSameAccount) {
+					t.Fatalf("expected ErrSameAccount from ReadPair, got %v", err)
+				}
+			})
+
+			t.Run("rejects other implementations", func(t *testing.T) {
+				other := &stubBalance{}
+				if err := balance.Transfer(accts[0], other, 1); !errors.Is(err, balance.ErrIncompatibleBalance) {
+					t.Fatalf("expected ErrIncompatibleBalance, got %v", err)
+				}
+				if err := balance.Transfer(other, accts[0], 1); !errors.Is(err, balance.ErrTransferUnsupported) {
+					t.Fatalf("expected ErrTransferUnsupported, got %v", err)
+				}
+				if _, _, err := balance.ReadPair(accts[0], other); pairs && !errors.Is(err, balance.ErrIncompatibleBalance) {
+					t.Fatalf("expected ErrIncompatibleBalance from ReadPair, got %v", err)
+				}
+			})
+
+			t.Run("rejects invalid amounts", func(t *testing.T) {
+				from, to := accts[0].Snapshot(), accts[1].Snapshot()
+				for _, amount := range []int64{0, -1, -100} {
+					if err := balance.Transfer(accts[0], accts[1], amount); !errors.Is(err, balance.ErrInvalidAmount) {
+						t.Fatalf("Transfer(%d): expected ErrInvalidAmount, got %v", amount, err)
+					}
+				}
+				if accts[0].Snapshot() != from || accts[1].Snapshot() != to {
+					t.Fatalf("rejected transfers changed state")
+				}
+			})
+
+			t.Run("insufficient funds", func(t *testing.T) {
+				from, to := accts[0].Balance(), accts[1].Balance()
+				err := balance.Transfer(accts[0], accts[1], from+1)
+				if !errors.Is(err, balance.ErrInsufficientFunds) {
+					t.Fatalf("expected ErrInsufficientFunds when transferring past balance, got %v", err)
+				}
+				if accts[0].Balance() != from || accts[1].Balance() != to {
+					t.Fatalf("failed transfer changed balances")
+				}
+			})
+
+			t.Run("single transfer", func(t *testing.T) {
+				from, to := accts[0].Balance(), accts[1].Balance()
+				if err := balance.Transfer(accts[0], accts[1], 100); err != nil {
+					t.Fatalf("unexpected transfer error: %v", err)
+				}
+				if accts[0].Balance() != from-100 || accts[1].Balance() != to+100 {
+					t.Fatalf(
+						"transfer mismatch, got %d/%d want %d/%d",
+						accts[0].Balance(),
+						accts[1].Balance(),
+						from-100,
+						to+100,
+					)
+				}
+			})
+
+			t.Run("read pair", func(t *testing.T) {
+				if !pairs {
+					t.Skip("implementation cannot read two accounts at once")
+				}
+				from, to := accts[0].Balance(), accts[1].Balance()
+				got0, got1, err := balance.ReadPair(accts[0], accts[1])
+				if err != nil {
+					t.Fatalf("unexpected read error: %v", err)
+				}
+				if got0 != from || got1 != to {
+					t.Fatalf("read %d/%d, want %d/%d", got0, got1, from, to)
+				}
+				if got1, got0, _ = balance.ReadPair(accts[1], accts[0]); got0 != from || got1 != to {
+					t.Fatalf("reversed read %d/%d, want %d/%d", got0, got1, from, to)
+				}
+			})
+
+			t.Run("concurrent transfers conserve total", func(t *testing.T) {
+				for r := 0; r < rounds; r++ {
+					var wg sync.WaitGroup
+					for w := 0; w < workers; w++ {
+						wg.Add(1)
+						go func() {
+							defer wg.Done()
+							for i := 0; i < iters; i++ {
+								from := rand.IntN(accounts)
+								to := (from + 1 + rand.IntN(accounts-1)) % accounts
+								_ = balance.Transfer(accts[from], accts[to], 1+rand.Int64N(75))
+							}
+						}()
+					}
+					wg.Wait()
+
+					var sum int64
+					for i, acct := range accts {
+						v := acct.Balance()
+						if v < 0 && !impl.Traits.KnownBuggy {
+							t.Fatalf("round %d: account %d went negative: %d", r, i, v)
+						}
+						sum += v
+					}
+					if sum != total {
+						t.Fatalf("round %d: total changed, got %d want %d", r, sum, total)
+					}
+				}
+			})
+
+			t.Run("readers never see funds in flight", func(t *testing.T) {
+				if !pairs {
+					t.Skip("implementation cannot read two accounts at once")
+				}
+				pair := [2]balance.Balance{balancetest.New(t, impl), balancetest.New(t, impl)}
+				for _, acct := range pair {
+					acct.Add(deposit)
+				}
+
+				// The reader runs for as long as the transfers do, reading
+				// both accounts at once, from either side, and checking the
+				// sum every time.
+				done, reading := make(chan struct{}), make(chan struct{})
+				go func() {
+					defer close(reading)
+					for i := 0; ; i++ {
+						a, b := pair[i%2], pair[(i+1)%2]
+						x, y, err := balance.ReadPair(a, b)
+						if err != nil {
+							t.Errorf("read %d: unexpected error: %v", i, err)
+							return
+						}
+						if x+y != 2*deposit {
+							t.Errorf("read %d: saw funds in flight, got %d + %d want a total of %d", i, x, y, 2*deposit)
+							return
+						}
+						select {
+						case <-done:
+							return
+						default:
+						}
+					}
+				}()
+
+				var wg sync.WaitGroup
+				for w := 0; w < workers; w++ {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						for i := 0; i < rounds*iters; i++ {
+							from := rand.IntN(2)
+							_ = balance.Transfer(pair[from], pair[1-from], 1+rand.Int64N(75))
+						}
+					}()
+				}
+				wg.Wait()
+				close(done)
+				<-reading
+
+				if sum := pair[0].Balance() + pair[1].Balance(); sum != 2*deposit {
+					t.Fatalf("total changed, got %d want %d", sum, 2*deposit)
+				}
+			})
+		})
+	}
+}
+
+// stubBalance is a minimal Balance that does not support transfers.
+type stubBalance struct{}
+
+func (*stubBalance) Balance() int64             { return 0 }
+func (*stubBalance) TransactionCount() int64    { return 0 }
+func (*stubBalance) LastUpdated() int64         { return 0 }
+func (*stubBalance) Snapshot() balance.Snapshot { return balance.Snapshot{} }
+func (*stubBalance) Add(int64)                  {}
+func (*stubBalance) Subtract(int64) error       { return nil }
@@ -0,0 +1,39 @@
+/*
+Package wal makes any Balance survive a restart by logging every successful
+mutation to a write-ahead log before applying it:
+
+	factory := func() balance.Balance { return full.New() }
+	acct, err := wal.Open(dir, factory,
+		wal.WithSyncEvery(64),             // fsync every 64 records...
+		wal.WithSyncInterval(time.Second), // ...and at least once a second
+		wal.WithSnapshotEvery(10_000),     // snapshot and truncate the log
+	)
+	defer acct.Close()
+
+Open rebuilds the balance from the newest snapshot and the log records
+written after it, using the given factory for a fresh instance. A record
+cut short by a crash, or a damaged last record, is a torn tail: it is
+discarded and the file is truncated back to the last good record before new
+records are appended. A damaged record with more of the log behind it is
+corruption, and Open fails with ErrCorruptLog rather than drop the records
+that follow.
+
+Mutations are serialized by the wrapper so that the log order is the order
+they were applied in. Each one is first tried on a shadow balance, built by
+the same factory and kept in step with the wrapped one, so a mutation that
+would be rejected is never logged. An accepted one is written to the file,
+synced per the sync options, and only then applied to the wrapped balance,
+so readers never see a mutation the log does not hold. A crashed process
+loses nothing; only records not yet fsynced can be lost if the machine
+itself goes down. The default syncs every record.
+
+Snapshots hold the value, TransactionCount and LastUpdated. With
+WithRestore, Open resumes all three through a Restore constructor such as
+mutex/full's, and each replayed record then counts as the mutations it
+logged, so recovery restores the value and TransactionCount exactly.
+LastUpdated is the snapshot's, or the time of the replay if records
+followed it. Without WithRestore only the value is restored: the snapshot
+is deposited into a fresh balance from the factory, which counts it as one
+deposit.
+*/
+package wal
@@ -0,0 +1,124 @@
+package wal
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+
+	balance "github.com/madflojo/atomics-v-rwmutex-examples"
+)
+
+// A record is framed as
+//
+//	length uint32 | crc32c uint32 | seq uint64 | n uvarint | n × (kind byte | amount varint)
+//
+// where length counts the bytes after the CRC and the CRC covers them. All
+// fixed-size integers are little endian.
+const headerSize = 8
+
+// maxRecord bounds the length field so a corrupted header cannot trigger a
+// huge allocation.
+const maxRecord = 64 << 20
+
+// crcTable is the Castagnoli polynomial, which has hardware support on
+// common CPUs.
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+var (
+	// errTorn reports a record cut short by the end of the log, as a crash
+	// mid-append leaves it.
+	errTorn = errors.New("torn record")
+	// errDamaged reports a complete record with an impossible length or a
+	// checksum that does not match. It is only a torn tail if nothing but
+	// zeros follows it.
+	errDamaged = errors.New("damaged record")
+)
+
+// record is one logged mutation: a single Add or Subtract, or a batch.
+type record struct {
+	seq uint64
+	ops []balance.Op
+}
+
+// appendRecord appends the framed encoding of r to buf.
+func appendRecord(buf []byte, r record) []byte {
+	start := len(buf)
+	buf = append(buf, make([]byte, headerSize)...)
+	buf = binary.LittleEndian.AppendUint64(buf, r.seq)
+	buf = binary.AppendUvarint(buf, uint64(len(r.ops)))
+	for _, op := range r.ops {
+		buf = append(buf, byte(op.Kind))
+		buf = binary.AppendVarint(buf, op.Amount)
+	}
+
+	body := buf[start+headerSize:]
+	binary.LittleEndian.PutUint32(buf[start:], uint32(len(body)))
+	binary.LittleEndian.PutUint32(buf[start+4:], crc32.Checksum(body, crcTable))
+	return buf
+}
+
+// readRecord reads the next record from r. It returns io.EOF at a clean end
+// of the log, errTorn for a record cut short and errDamaged for one that
+// fails its checks.
+func readRecord(r *bufio.Reader) (record, int, error) {
+	var header [headerSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		if err == io.EOF {
+			return record{}, 0, io.EOF
+		}
+		return record{}, 0, errTorn
+	}
+
+	size := binary.LittleEndian.Uint32(header[:4])
+	if size < 9 || size > maxRecord {
+		return record{}, 0, errDamaged
+	}
+	body := make([]byte, size)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return record{}, 0, errTorn
+	}
+	if crc32.Checksum(body, crcTable) != binary.LittleEndian.Uint32(header[4:]) {
+		return record{}, 0, errDamaged
+	}
+
+	rec, err := decodeBody(body)
+	if err != nil {
+		return record{}, 0, err
+	}
+	return rec, headerSize + int(size), nil
+}
+
+// decodeBody decodes a record body whose checksum has already matched. A
+// body that still fails to decode was written by something else, so it is
+// reported as corruption rather than a torn tail.
+func decodeBody(body []byte) (record, error) {
+	rec := record{seq: binary.LittleEndian.Uint64(body)}
+	body = body[8:]
+
+	n, size := binary.Uvarint(body)
+	if size <= 0 || n > uint64(len(body)) {
+		return record{}, fmt.Errorf("%w: record %d: bad op count", ErrCorruptLog, rec.seq)
+	}
+	body = body[size:]
+
+	rec.ops = make([]balance.Op, 0, n)
+	for i := uint64(0); i < n; i++ {
+		if len(body) == 0 {
+			return record{}, fmt.Errorf("%w: record %d: missing op %d", ErrCorruptLog, rec.seq, i)
+		}
+		kind := balance.OpKind(body[0])
+		amount, size := binary.Varint(body[1:])
+		if size <= 0 || (kind != balance.OpAdd && kind != balance.OpSubtract) {
+			return record{}, fmt.Errorf("%w: record %d: bad op %d", ErrCorruptLog, rec.seq, i)
+		}
+		rec.ops = append(rec.ops, balance.Op{Kind: kind, Amount: amount})
+		body = body[1+size:]
+	}
+	if len(body) != 0 {
+		return record{}, fmt.Errorf("%w: record %d: trailing bytes", ErrCorruptLog, rec.seq)
+	}
+	return rec, nil
+}
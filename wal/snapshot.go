@@ -0,0 +1,99 @@
+package wal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+
+	balance "github.com/madflojo/atomics-v-rwmutex-examples"
+)
+
+// snapshotMagic starts every snapshot file and carries its format version.
+var snapshotMagic = []byte("BALSNAP2")
+
+// stateSize is the length of balance.Snapshot's binary encoding.
+const stateSize = 1 + 3*8
+
+// snapshotSize is the magic, seq, state and CRC.
+const snapshotSize = 8 + 8 + stateSize + 4
+
+// snapshot is the balance as of log record seq.
+type snapshot struct {
+	seq   uint64
+	state balance.Snapshot
+}
+
+// readSnapshot loads the snapshot in dir. A missing file is the zero
+// snapshot.
+func readSnapshot(dir string) (snapshot, error) {
+	data, err := os.ReadFile(filepath.Join(dir, SnapshotName))
+	if errors.Is(err, os.ErrNotExist) {
+		return snapshot{}, nil
+	}
+	if err != nil {
+		return snapshot{}, err
+	}
+
+	// Snapshots are written to a temporary file and renamed into place, so
+	// unlike the log they are never torn; any damage is corruption.
+	if len(data) != snapshotSize || !bytes.Equal(data[:8], snapshotMagic) {
+		return snapshot{}, fmt.Errorf("%w: bad header", ErrCorruptSnapshot)
+	}
+	body, sum := data[:snapshotSize-4], binary.LittleEndian.Uint32(data[snapshotSize-4:])
+	if crc32.Checksum(body, crcTable) != sum {
+		return snapshot{}, fmt.Errorf("%w: checksum mismatch", ErrCorruptSnapshot)
+	}
+	s := snapshot{seq: binary.LittleEndian.Uint64(data[8:])}
+	if err := s.state.UnmarshalBinary(data[16 : 16+stateSize]); err != nil {
+		return snapshot{}, fmt.Errorf("%w: %w", ErrCorruptSnapshot, err)
+	}
+	return s, nil
+}
+
+// writeSnapshot durably replaces the snapshot in dir with s.
+func writeSnapshot(dir string, s snapshot) error {
+	state, err := s.state.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	data := make([]byte, 0, snapshotSize)
+	data = append(data, snapshotMagic...)
+	data = binary.LittleEndian.AppendUint64(data, s.seq)
+	data = append(data, state...)
+	data = binary.LittleEndian.AppendUint32(data, crc32.Checksum(data, crcTable))
+
+	tmp := filepath.Join(dir, SnapshotName+".tmp")
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, SnapshotName)); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// syncDir fsyncs dir so a newly created or renamed entry survives a crash.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,501 @@
+package wal
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	balance "github.com/madflojo/atomics-v-rwmutex-examples"
+)
+
+const (
+	// LogName is the write-ahead log's file name inside the directory.
+	LogName = "wal.log"
+	// SnapshotName is the snapshot's file name inside the directory.
+	SnapshotName = "snapshot"
+)
+
+var (
+	// ErrCorruptLog is returned by Open for a log that cannot be replayed:
+	// a damaged record before the tail, an undecodable record or a gap in
+	// the sequence numbers.
+	ErrCorruptLog = errors.New("wal: corrupt log")
+	// ErrCorruptSnapshot is returned by Open for a snapshot that fails its
+	// checksum or has an unknown format.
+	ErrCorruptSnapshot = errors.New("wal: corrupt snapshot")
+)
+
+// config holds the settings applied by Options.
+type config struct {
+	syncEvery     int
+	syncInterval  time.Duration
+	snapshotEvery int
+	restore       func(balance.Snapshot) (balance.Balance, error)
+}
+
+// Option configures Open.
+type Option func(*config)
+
+// WithSyncEvery fsyncs the log once every n records instead of after each
+// one. Values below 1 are treated as 1.
+func WithSyncEvery(n int) Option {
+	return func(c *config) { c.syncEvery = max(n, 1) }
+}
+
+// WithSyncInterval also fsyncs any unsynced records every d, bounding how
+// long an acknowledged mutation stays exposed to a machine crash when
+// WithSyncEvery batches writes.
+func WithSyncInterval(d time.Duration) Option {
+	return func(c *config) { c.syncInterval = d }
+}
+
+// WithSnapshotEvery writes a snapshot and truncates the log once every n
+// records. Without it the log grows until Checkpoint is called. A snapshot
+// that fails is retried after the next record and reported by Sync.
+func WithSnapshotEvery(n int) Option {
+	return func(c *config) { c.snapshotEvery = n }
+}
+
+// WithRestore has Open resume the snapshot through restore, typically a
+// full implementation's Restore constructor, instead of depositing its
+// value into a fresh balance from the factory. The snapshot's
+// TransactionCount and LastUpdated then carry over too.
+func WithRestore(restore func(balance.Snapshot) (balance.Balance, error)) Option {
+	return func(c *config) { c.restore = restore }
+}
+
+// Balance wraps a balance.Balance with a write-ahead log. It still satisfies
+// balance.Balance; reads pass straight through to the wrapped value.
+type Balance struct {
+	inner balance.Balance
+	// shadow mirrors inner: it is built by the same factory and takes every
+	// logged mutation first, so a mutation it accepts is known to succeed
+	// before it is logged and applied to inner.
+	shadow balance.Balance
+	dir    string
+	cfg    config
+
+	mu      sync.Mutex
+	log     *os.File
+	buf     []byte
+	seq     uint64
+	pending int
+	since   int
+	closed  bool
+	// err is the first log write failure. The shadow already took the
+	// mutation that hit it, so the shadow, the log and the wrapped balance
+	// may have diverged and every later mutation is refused.
+	err error
+	// snapErr is the failure of the last automatic snapshot. The mutation
+	// that triggered it was logged and applied, so it is kept for the next
+	// Checkpoint or Sync to report instead.
+	snapErr error
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// Open rebuilds a balance from the snapshot and log in dir, creating the
+// directory if needed, and returns it ready to log further mutations.
+// factory supplies the fresh instance to replay into, unless WithRestore
+// supplies one resumed from the snapshot; either should be configured with
+// the same limits as the original so the replay succeeds.
+func Open(dir string, factory balance.Factory, opts ...Option) (*Balance, error) {
+	cfg := config{syncEvery: 1}
+	for _, opt := range opts {
+		if opt != nil {
+			opt(&cfg)
+		}
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("wal: %w", err)
+	}
+	snap, err := readSnapshot(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	inner, err := resume(cfg, factory, snap.state)
+	if err != nil {
+		return nil, fmt.Errorf("wal: restore snapshot: %w", err)
+	}
+	shadow, err := resume(cfg, factory, snap.state)
+	if err != nil {
+		closeBalance(inner)
+		return nil, fmt.Errorf("wal: restore snapshot: %w", err)
+	}
+
+	log, err := os.OpenFile(filepath.Join(dir, LogName), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		closeBalance(inner)
+		closeBalance(shadow)
+		return nil, fmt.Errorf("wal: %w", err)
+	}
+	seq, end, err := replay(log, snap.seq, func(ops []balance.Op) error {
+		if err := apply(shadow, ops); err != nil {
+			return err
+		}
+		return apply(inner, ops)
+	})
+	if err == nil {
+		err = truncate(log, end)
+	}
+	if err == nil {
+		err = syncDir(dir)
+	}
+	if err != nil {
+		_ = log.Close()
+		closeBalance(inner)
+		closeBalance(shadow)
+		return nil, err
+	}
+
+	b := &Balance{
+		inner:  inner,
+		shadow: shadow,
+		dir:    dir,
+		cfg:    cfg,
+		log:    log,
+		seq:    seq,
+		stop:   make(chan struct{}),
+	}
+	if cfg.syncInterval > 0 {
+		b.wg.Add(1)
+		go b.syncLoop()
+	}
+	return b, nil
+}
+
+// Unwrap returns the wrapped Balance. Mutating it directly bypasses the log,
+// and a later mutation it makes fail is refused along with all after it.
+func (b *Balance) Unwrap() balance.Balance { return b.inner }
+
+// Balance returns the wrapped balance's value.
+func (b *Balance) Balance() int64 { return b.inner.Balance() }
+
+// TransactionCount returns the wrapped balance's transaction count.
+func (b *Balance) TransactionCount() int64 { return b.inner.TransactionCount() }
+
+// LastUpdated returns the wrapped balance's last update timestamp.
+func (b *Balance) LastUpdated() int64 { return b.inner.LastUpdated() }
+
+// Snapshot returns the wrapped balance's snapshot.
+func (b *Balance) Snapshot() balance.Snapshot { return b.inner.Snapshot() }
+
+// Add deposits amount. Errors, including failures to write the log, are
+// dropped; use AddChecked to see them.
+func (b *Balance) Add(amount int64) { _ = b.AddChecked(amount) }
+
+// AddChecked logs a deposit of amount and then applies it, returning once
+// both are done.
+func (b *Balance) AddChecked(amount int64) error {
+	return b.mutate([]balance.Op{{Kind: balance.OpAdd, Amount: amount}}, func(acct balance.Balance) error {
+		return balance.AddChecked(acct, amount)
+	})
+}
+
+// Subtract logs a withdrawal of amount and then applies it, returning once
+// both are done.
+func (b *Balance) Subtract(amount int64) error {
+	return b.mutate([]balance.Op{{Kind: balance.OpSubtract, Amount: amount}}, func(acct balance.Balance) error {
+		return acct.Subtract(amount)
+	})
+}
+
+// ApplyBatch logs ops as a single record and then applies them with the
+// wrapped balance's ApplyBatch, so a replay applies all of them or none.
+func (b *Balance) ApplyBatch(ops []balance.Op) error {
+	return b.mutate(ops, func(acct balance.Balance) error {
+		return balance.ApplyBatch(acct, ops)
+	})
+}
+
+// Checkpoint writes a snapshot of the current state and truncates the log.
+// Its result supersedes any failed automatic snapshot.
+func (b *Balance) Checkpoint() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.usable(); err != nil {
+		return err
+	}
+	b.snapErr = nil
+	return b.checkpoint()
+}
+
+// Sync fsyncs any records written since the last sync. It also reports, once,
+// an automatic snapshot that failed since the last Checkpoint or Sync; the
+// log still holds every record, so nothing was lost.
+func (b *Balance) Sync() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.usable(); err != nil {
+		return err
+	}
+	if err := b.sync(); err != nil {
+		return err
+	}
+	err := b.snapErr
+	b.snapErr = nil
+	return err
+}
+
+// Close syncs and closes the log, then closes the wrapped balance if it has
+// a Close method. Mutations after Close return balance.ErrClosed.
+func (b *Balance) Close() error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return nil
+	}
+	b.closed = true
+	b.mu.Unlock()
+
+	close(b.stop)
+	b.wg.Wait()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var err error
+	if b.err == nil && b.pending > 0 {
+		err = b.log.Sync()
+	}
+	err = errors.Join(err, b.log.Close())
+	if c, ok := b.inner.(io.Closer); ok {
+		err = errors.Join(err, c.Close())
+	}
+	closeBalance(b.shadow)
+	return err
+}
+
+// mutate validates a mutation against the shadow, logs ops and syncs them
+// per the configured policy, and only then applies the mutation to the
+// wrapped balance. A mutation the shadow rejects is neither logged nor
+// applied.
+func (b *Balance) mutate(ops []balance.Op, apply func(balance.Balance) error) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.usable(); err != nil {
+		return err
+	}
+	if err := apply(b.shadow); err != nil {
+		return err
+	}
+	if len(ops) == 0 {
+		return nil
+	}
+
+	b.seq++
+	b.buf = appendRecord(b.buf[:0], record{seq: b.seq, ops: ops})
+	if _, err := b.log.Write(b.buf); err != nil {
+		b.err = fmt.Errorf("wal: append record %d: %w", b.seq, err)
+		return b.err
+	}
+	b.pending++
+	if b.pending >= b.cfg.syncEvery {
+		if err := b.sync(); err != nil {
+			return err
+		}
+	}
+
+	if err := apply(b.inner); err != nil {
+		// The shadow accepted it, so the wrapped balance was mutated
+		// behind the log's back.
+		b.err = fmt.Errorf("wal: apply record %d: %w", b.seq, err)
+		return b.err
+	}
+	b.since++
+	if b.cfg.snapshotEvery > 0 && b.since >= b.cfg.snapshotEvery {
+		// The mutation is already durable and applied, so a failed
+		// snapshot must not make it look rejected. since stays put and
+		// the next mutation tries again.
+		b.snapErr = b.checkpoint()
+	}
+	return nil
+}
+
+// usable reports why the log cannot take more records, if it cannot.
+func (b *Balance) usable() error {
+	if b.closed {
+		return balance.ErrClosed
+	}
+	return b.err
+}
+
+// sync fsyncs pending records. The caller must hold b.mu.
+func (b *Balance) sync() error {
+	if b.pending == 0 {
+		return nil
+	}
+	if err := b.log.Sync(); err != nil {
+		b.err = fmt.Errorf("wal: sync: %w", err)
+		return b.err
+	}
+	b.pending = 0
+	return nil
+}
+
+// checkpoint snapshots the wrapped balance as of b.seq and empties the
+// log. A crash between the two leaves records the snapshot already covers,
+// which replay skips by sequence number. The caller must hold b.mu.
+func (b *Balance) checkpoint() error {
+	if err := b.sync(); err != nil {
+		return err
+	}
+	if err := writeSnapshot(b.dir, snapshot{seq: b.seq, state: b.inner.Snapshot()}); err != nil {
+		return fmt.Errorf("wal: snapshot: %w", err)
+	}
+	if err := truncate(b.log, 0); err != nil {
+		b.err = err
+		return err
+	}
+	b.since = 0
+	return nil
+}
+
+// syncLoop fsyncs pending records every cfg.syncInterval until Close.
+func (b *Balance) syncLoop() {
+	defer b.wg.Done()
+	ticker := time.NewTicker(b.cfg.syncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.stop:
+			return
+		case <-ticker.C:
+			b.mu.Lock()
+			if b.usable() == nil {
+				_ = b.sync()
+			}
+			b.mu.Unlock()
+		}
+	}
+}
+
+// replay passes the ops of every intact record after seq after to apply and
+// returns the last sequence number applied and the offset just past the
+// last intact record. Only the tail may be torn: a damaged record with more
+// than zeros after it is reported as ErrCorruptLog rather than dropping the
+// records behind it.
+func replay(log *os.File, after uint64, apply func([]balance.Op) error) (uint64, int64, error) {
+	r := bufio.NewReader(log)
+	seq := after
+	var end int64
+	for {
+		rec, n, err := readRecord(r)
+		if err == io.EOF || errors.Is(err, errTorn) {
+			return seq, end, nil
+		}
+		if errors.Is(err, errDamaged) {
+			rest, err := io.ReadAll(r)
+			if err != nil {
+				return 0, 0, fmt.Errorf("wal: read log: %w", err)
+			}
+			if !zeros(rest) {
+				return 0, 0, fmt.Errorf("%w: damaged record after %d with more log behind it", ErrCorruptLog, seq)
+			}
+			return seq, end, nil
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+
+		switch {
+		case rec.seq <= after:
+			// Already in the snapshot; left behind by a crash before the
+			// log was truncated.
+		case rec.seq == seq+1:
+			if err := apply(rec.ops); err != nil {
+				return 0, 0, fmt.Errorf("wal: replay record %d: %w", rec.seq, err)
+			}
+			seq = rec.seq
+		default:
+			return 0, 0, fmt.Errorf("%w: record %d follows %d", ErrCorruptLog, rec.seq, seq)
+		}
+		end += int64(n)
+	}
+}
+
+// apply replays one record's ops against b.
+func apply(b balance.Balance, ops []balance.Op) error {
+	if len(ops) != 1 {
+		return balance.ApplyBatch(b, ops)
+	}
+
+	switch op := ops[0]; op.Kind {
+	case balance.OpAdd:
+		return balance.AddChecked(b, op.Amount)
+	default:
+		return b.Subtract(op.Amount)
+	}
+}
+
+// resume returns a balance in state s: through cfg.restore when set, and
+// otherwise a fresh one from factory brought to s.Value, whose metadata
+// starts over.
+func resume(cfg config, factory balance.Factory, s balance.Snapshot) (balance.Balance, error) {
+	if cfg.restore != nil {
+		return cfg.restore(s)
+	}
+
+	b := factory()
+	if err := restore(b, s.Value); err != nil {
+		closeBalance(b)
+		return nil, err
+	}
+	return b, nil
+}
+
+// restore brings a fresh balance to value. A negative value is withdrawn
+// in steps no larger than math.MaxInt64, since -math.MinInt64 overflows.
+func restore(b balance.Balance, value int64) error {
+	if value > 0 {
+		return balance.AddChecked(b, value)
+	}
+	for value < 0 {
+		step := max(value, -math.MaxInt64)
+		if err := b.Subtract(-step); err != nil {
+			return err
+		}
+		value -= step
+	}
+	return nil
+}
+
+// zeros reports whether data holds only zero bytes, such as a crash can
+// leave where the file was extended but not yet written.
+func zeros(data []byte) bool {
+	for _, c := range data {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// truncate cuts the log to size, positions writes at its end and syncs it.
+func truncate(log *os.File, size int64) error {
+	if err := log.Truncate(size); err != nil {
+		return fmt.Errorf("wal: truncate log: %w", err)
+	}
+	if _, err := log.Seek(size, io.SeekStart); err != nil {
+		return fmt.Errorf("wal: truncate log: %w", err)
+	}
+	if err := log.Sync(); err != nil {
+		return fmt.Errorf("wal: truncate log: %w", err)
+	}
+	return nil
+}
+
+// closeBalance closes b if it has a Close method.
+func closeBalance(b balance.Balance) {
+	if c, ok := b.(io.Closer); ok {
+		_ = c.Close()
+	}
+}
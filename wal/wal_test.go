@@ -0,0 +1,390 @@
+package wal_test
+
+import (
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	balance "github.com/madflojo/atomics-v-rwmutex-examples"
+	"github.com/madflojo/atomics-v-rwmutex-examples/balancetest"
+	_ "github.com/madflojo/atomics-v-rwmutex-examples/implementations/all"
+	mutexfull "github.com/madflojo/atomics-v-rwmutex-examples/implementations/mutex/full"
+	"github.com/madflojo/atomics-v-rwmutex-examples/wal"
+)
+
+func TestWAL(t *testing.T) {
+	for _, impl := range balance.Implementations() {
+		impl := impl
+		t.Run(impl.Name, func(t *testing.T) {
+			t.Run("replays after restart", func(t *testing.T) {
+				dir := t.TempDir()
+				acct := openWAL(t, dir, impl.New)
+				acct.Add(100)
+				if err := acct.Subtract(30); err != nil {
+					t.Fatalf("unexpected subtract error: %v", err)
+				}
+				if err := acct.Subtract(1_000); !errors.Is(err, balance.ErrInsufficientFunds) {
+					t.Fatalf("expected insufficient funds, got %v", err)
+				}
+				if err := acct.ApplyBatch([]balance.Op{
+					{Kind: balance.OpAdd, Amount: 5},
+					{Kind: balance.OpSubtract, Amount: 15},
+				}); err != nil {
+					t.Fatalf("unexpected batch error: %v", err)
+				}
+				closeWAL(t, acct)
+
+				acct = openWAL(t, dir, impl.New)
+				if got := acct.Balance(); got != 60 {
+					t.Fatalf("expected 60 after replay, got %d", got)
+				}
+			})
+
+			t.Run("concurrent writers", func(t *testing.T) {
+				dir := t.TempDir()
+				acct := openWAL(t, dir, impl.New, wal.WithSyncEvery(64), wal.WithSyncInterval(time.Millisecond), wal.WithSnapshotEvery(50))
+				var wg sync.WaitGroup
+				for w := 0; w < 4; w++ {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						for i := 0; i < 100; i++ {
+							acct.Add(2)
+							_ = acct.Subtract(1)
+						}
+					}()
+				}
+				wg.Wait()
+				want := acct.Balance()
+				closeWAL(t, acct)
+
+				acct = openWAL(t, dir, impl.New)
+				if got := acct.Balance(); got != want {
+					t.Fatalf("expected %d after replay, got %d", want, got)
+				}
+			})
+		})
+	}
+
+	impl := balance.Implementations()[0]
+
+	t.Run("torn tail", func(t *testing.T) {
+		for name, tear := range map[string]func(data []byte) []byte{
+			"truncated": func(data []byte) []byte { return data[:len(data)-3] },
+			"bad checksum": func(data []byte) []byte {
+				data[len(data)-1] ^= 0xff
+				return data
+			},
+			"partial header": func(data []byte) []byte { return append(data, 0x01, 0x02) },
+		} {
+			t.Run(name, func(t *testing.T) {
+				dir := t.TempDir()
+				acct := openWAL(t, dir, impl.New)
+				acct.Add(10)
+				acct.Add(20)
+				closeWAL(t, acct)
+				intact := logSize(t, dir)
+
+				acct = openWAL(t, dir, impl.New)
+				acct.Add(40)
+				closeWAL(t, acct)
+				path := filepath.Join(dir, wal.LogName)
+				data, err := os.ReadFile(path)
+				if err != nil {
+					t.Fatalf("unexpected read error: %v", err)
+				}
+				if err := os.WriteFile(path, tear(data), 0o644); err != nil {
+					t.Fatalf("unexpected write error: %v", err)
+				}
+
+				want := int64(70)
+				if name != "partial header" {
+					want = 30
+				}
+				acct = openWAL(t, dir, impl.New)
+				if got := acct.Balance(); got != want {
+					t.Fatalf("expected %d after recovery, got %d", want, got)
+				}
+				if name != "partial header" && logSize(t, dir) != intact {
+					t.Fatalf("expected the log truncated to %d bytes, got %d", intact, logSize(t, dir))
+				}
+
+				// New records land after the last good one and replay too.
+				acct.Add(1)
+				closeWAL(t, acct)
+				acct = openWAL(t, dir, impl.New)
+				if got := acct.Balance(); got != want+1 {
+					t.Fatalf("expected %d after second recovery, got %d", want+1, got)
+				}
+			})
+		}
+	})
+
+	t.Run("damaged record before the tail", func(t *testing.T) {
+		dir := t.TempDir()
+		acct := openWAL(t, dir, impl.New)
+		acct.Add(10)
+		acct.Add(20)
+		closeWAL(t, acct)
+
+		path := filepath.Join(dir, wal.LogName)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("unexpected read error: %v", err)
+		}
+		// Flip a byte of the first record's checksum.
+		data[4] ^= 0xff
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatalf("unexpected write error: %v", err)
+		}
+		if _, err := wal.Open(dir, impl.New); !errors.Is(err, wal.ErrCorruptLog) {
+			t.Fatalf("expected ErrCorruptLog, got %v", err)
+		}
+		if got := int64(len(data)); logSize(t, dir) != got {
+			t.Fatalf("expected the log left at %d bytes, got %d", got, logSize(t, dir))
+		}
+	})
+
+	t.Run("rejected mutations are not logged", func(t *testing.T) {
+		dir := t.TempDir()
+		acct := openWAL(t, dir, impl.New)
+		acct.Add(10)
+		size := logSize(t, dir)
+		if err := acct.Subtract(1_000); !errors.Is(err, balance.ErrInsufficientFunds) {
+			t.Fatalf("expected insufficient funds, got %v", err)
+		}
+		if err := acct.AddChecked(0); !errors.Is(err, balance.ErrInvalidAmount) {
+			t.Fatalf("expected ErrInvalidAmount, got %v", err)
+		}
+		if got := logSize(t, dir); got != size {
+			t.Fatalf("expected rejected mutations to leave the log at %d bytes, got %d", size, got)
+		}
+	})
+
+	t.Run("snapshot at the lowest int64", func(t *testing.T) {
+		full, ok := balance.Lookup("Mutex_Balance_full")
+		if !ok {
+			t.Fatalf("Mutex_Balance_full is not registered")
+		}
+		factory := func() balance.Balance {
+			return full.NewWithOptions(balance.WithLimits(balance.Limits{Floor: math.MinInt64}))
+		}
+
+		dir := t.TempDir()
+		acct := openWAL(t, dir, factory)
+		if err := acct.Subtract(math.MaxInt64); err != nil {
+			t.Fatalf("unexpected subtract error: %v", err)
+		}
+		if err := acct.Subtract(1); err != nil {
+			t.Fatalf("unexpected subtract error: %v", err)
+		}
+		if err := acct.Checkpoint(); err != nil {
+			t.Fatalf("unexpected checkpoint error: %v", err)
+		}
+		closeWAL(t, acct)
+
+		acct = openWAL(t, dir, factory)
+		if got := acct.Balance(); got != math.MinInt64 {
+			t.Fatalf("expected %d after restore, got %d", int64(math.MinInt64), got)
+		}
+	})
+
+	t.Run("restore keeps metadata", func(t *testing.T) {
+		clock := balancetest.NewManualClock(1_000)
+		restore := wal.WithRestore(func(s balance.Snapshot) (balance.Balance, error) {
+			return mutexfull.Restore(s, balance.WithClock(clock))
+		})
+
+		dir := t.TempDir()
+		acct := openWAL(t, dir, impl.New, restore)
+		acct.Add(50)
+		clock.Advance(time.Second)
+		if err := acct.Subtract(20); err != nil {
+			t.Fatalf("unexpected subtract error: %v", err)
+		}
+		if err := acct.Checkpoint(); err != nil {
+			t.Fatalf("unexpected checkpoint error: %v", err)
+		}
+		want := acct.Snapshot()
+		closeWAL(t, acct)
+
+		clock.Advance(time.Hour)
+		acct = openWAL(t, dir, impl.New, restore)
+		if got := acct.Snapshot(); got != want {
+			t.Fatalf("expected %+v after restore, got %+v", want, got)
+		}
+
+		// Records after the snapshot keep counting from it.
+		acct.Add(5)
+		closeWAL(t, acct)
+		acct = openWAL(t, dir, impl.New, restore)
+		if got := acct.TransactionCount(); got != want.TransactionCount+1 {
+			t.Fatalf("expected %d transactions after replay, got %d", want.TransactionCount+1, got)
+		}
+	})
+
+	t.Run("snapshots truncate the log", func(t *testing.T) {
+		dir := t.TempDir()
+		acct := openWAL(t, dir, impl.New, wal.WithSnapshotEvery(4))
+		for i := 0; i < 10; i++ {
+			acct.Add(10)
+		}
+		if err := acct.Subtract(25); err != nil {
+			t.Fatalf("unexpected subtract error: %v", err)
+		}
+		closeWAL(t, acct)
+
+		if _, err := os.Stat(filepath.Join(dir, wal.SnapshotName)); err != nil {
+			t.Fatalf("expected a snapshot: %v", err)
+		}
+		acct = openWAL(t, dir, impl.New)
+		if got := acct.Balance(); got != 75 {
+			t.Fatalf("expected 75 after replay, got %d", got)
+		}
+		if err := acct.Checkpoint(); err != nil {
+			t.Fatalf("unexpected checkpoint error: %v", err)
+		}
+		if size := logSize(t, dir); size != 0 {
+			t.Fatalf("expected an empty log after Checkpoint, got %d bytes", size)
+		}
+	})
+
+	t.Run("failed snapshot", func(t *testing.T) {
+		dir := t.TempDir()
+		acct := openWAL(t, dir, impl.New, wal.WithSnapshotEvery(1))
+		// A directory in the way of the snapshot's temporary file makes
+		// every snapshot fail.
+		tmp := filepath.Join(dir, wal.SnapshotName+".tmp")
+		if err := os.Mkdir(tmp, 0o755); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := acct.AddChecked(10); err != nil {
+			t.Fatalf("expected the logged deposit to succeed, got %v", err)
+		}
+		if got := acct.Balance(); got != 10 {
+			t.Fatalf("expected 10, got %d", got)
+		}
+		if err := acct.Sync(); err == nil {
+			t.Fatal("expected Sync to report the failed snapshot")
+		}
+		if err := acct.Sync(); err != nil {
+			t.Fatalf("expected the failure to be reported once, got %v", err)
+		}
+
+		if err := os.Remove(tmp); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		acct.Add(5)
+		if err := acct.Sync(); err != nil {
+			t.Fatalf("unexpected sync error: %v", err)
+		}
+		if size := logSize(t, dir); size != 0 {
+			t.Fatalf("expected the retried snapshot to empty the log, got %d bytes", size)
+		}
+		closeWAL(t, acct)
+
+		acct = openWAL(t, dir, impl.New)
+		if got := acct.Balance(); got != 15 {
+			t.Fatalf("expected 15 after replay, got %d", got)
+		}
+	})
+
+	t.Run("crash between snapshot and truncation", func(t *testing.T) {
+		dir := t.TempDir()
+		acct := openWAL(t, dir, impl.New)
+		acct.Add(50)
+		if err := acct.Subtract(20); err != nil {
+			t.Fatalf("unexpected subtract error: %v", err)
+		}
+		if err := acct.Sync(); err != nil {
+			t.Fatalf("unexpected sync error: %v", err)
+		}
+		path := filepath.Join(dir, wal.LogName)
+		stale, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("unexpected read error: %v", err)
+		}
+		if err := acct.Checkpoint(); err != nil {
+			t.Fatalf("unexpected checkpoint error: %v", err)
+		}
+		closeWAL(t, acct)
+
+		// Put back the records the snapshot already covers, as if the
+		// process died before truncating them.
+		if err := os.WriteFile(path, stale, 0o644); err != nil {
+			t.Fatalf("unexpected write error: %v", err)
+		}
+		acct = openWAL(t, dir, impl.New)
+		if got := acct.Balance(); got != 30 {
+			t.Fatalf("expected 30 without double-applying records, got %d", got)
+		}
+	})
+
+	t.Run("corrupt snapshot", func(t *testing.T) {
+		dir := t.TempDir()
+		acct := openWAL(t, dir, impl.New)
+		acct.Add(5)
+		if err := acct.Checkpoint(); err != nil {
+			t.Fatalf("unexpected checkpoint error: %v", err)
+		}
+		closeWAL(t, acct)
+
+		path := filepath.Join(dir, wal.SnapshotName)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("unexpected read error: %v", err)
+		}
+		data[10] ^= 0xff
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatalf("unexpected write error: %v", err)
+		}
+		if _, err := wal.Open(dir, impl.New); !errors.Is(err, wal.ErrCorruptSnapshot) {
+			t.Fatalf("expected ErrCorruptSnapshot, got %v", err)
+		}
+	})
+
+	t.Run("closed", func(t *testing.T) {
+		acct := openWAL(t, t.TempDir(), impl.New)
+		closeWAL(t, acct)
+		if err := acct.Subtract(1); !errors.Is(err, balance.ErrClosed) {
+			t.Fatalf("expected ErrClosed, got %v", err)
+		}
+		if err := acct.AddChecked(1); !errors.Is(err, balance.ErrClosed) {
+			t.Fatalf("expected ErrClosed, got %v", err)
+		}
+	})
+}
+
+// openWAL opens a WAL-backed balance in dir and closes it when the test
+// ends.
+func openWAL(t *testing.T, dir string, factory balance.Factory, opts ...wal.Option) *wal.Balance {
+	t.Helper()
+	acct, err := wal.Open(dir, factory, opts...)
+	if err != nil {
+		t.Fatalf("unexpected open error: %v", err)
+	}
+	t.Cleanup(func() { _ = acct.Close() })
+	return acct
+}
+
+// closeWAL closes acct, failing the test on error.
+func closeWAL(t *testing.T, acct *wal.Balance) {
+	t.Helper()
+	if err := acct.Close(); err != nil {
+		t.Fatalf("unexpected close error: %v", err)
+	}
+}
+
+// logSize returns the size of the log in dir.
+func logSize(t *testing.T, dir string) int64 {
+	t.Helper()
+	info, err := os.Stat(filepath.Join(dir, wal.LogName))
+	if err != nil {
+		t.Fatalf("unexpected stat error: %v", err)
+	}
+	return info.Size()
+}